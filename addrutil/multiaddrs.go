@@ -0,0 +1,221 @@
+package addrutil
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+	ma "github.com/multiformats/go-multiaddr"
+	"net"
+)
+
+// MultiAddrOptions filters the multiaddrs derived from an ENR. The zero value includes all dialable addresses.
+type MultiAddrOptions struct {
+	// SkipIP4 excludes the IPv4 addresses
+	SkipIP4 bool
+	// SkipIP6 excludes the IPv6 addresses
+	SkipIP6 bool
+	// SkipTCP excludes the TCP addresses
+	SkipTCP bool
+	// SkipQUIC excludes the QUIC-v1 addresses
+	SkipQUIC bool
+	// IncludeUDP adds the plain UDP (discovery) addresses, these are not dialable with libp2p
+	IncludeUDP bool
+	// IncludePeerID appends the /p2p/<peer id> component to each address
+	IncludePeerID bool
+}
+
+type enrPorts struct {
+	ip   net.IP
+	tcp  uint16
+	quic uint16
+	udp  uint16
+}
+
+// loadEnrPorts loads the IPv4 and IPv6 endpoints of the node.
+// As per ENR convention, the IPv6 ports default to the IPv4 ports if not specified.
+func loadEnrPorts(node *enode.Node) (ip4 enrPorts, ip6 enrPorts) {
+	var (
+		ipv4  enr.IPv4
+		ipv6  enr.IPv6
+		tcp   enr.TCP
+		tcp6  enr.TCP6
+		quic  QUIC
+		quic6 QUIC6
+		udp   enr.UDP
+		udp6  enr.UDP6
+	)
+	_ = node.Load(&ipv4)
+	_ = node.Load(&ipv6)
+	_ = node.Load(&tcp)
+	_ = node.Load(&quic)
+	_ = node.Load(&udp)
+	if err := node.Load(&tcp6); err != nil {
+		tcp6 = enr.TCP6(tcp)
+	}
+	if err := node.Load(&quic6); err != nil {
+		quic6 = QUIC6(quic)
+	}
+	if err := node.Load(&udp6); err != nil {
+		udp6 = enr.UDP6(udp)
+	}
+	ip4 = enrPorts{ip: net.IP(ipv4), tcp: uint16(tcp), quic: uint16(quic), udp: uint16(udp)}
+	ip6 = enrPorts{ip: net.IP(ipv6), tcp: uint16(tcp6), quic: uint16(quic6), udp: uint16(udp6)}
+	return
+}
+
+// EnodeToMultiAddrs returns the multiaddrs of the node: TCP and QUIC-v1, over IPv4 and IPv6,
+// filtered by the options. Nodes without any IP or port result in an empty list.
+func EnodeToMultiAddrs(node *enode.Node, opts MultiAddrOptions) ([]ma.Multiaddr, error) {
+	suffix := ""
+	if opts.IncludePeerID {
+		pubkey := node.Pubkey()
+		if pubkey == nil {
+			return nil, fmt.Errorf("node %s has no secp256k1 pubkey to derive a peer ID from", node.ID())
+		}
+		suffix = "/p2p/" + PeerIDFromPubkey(pubkey).String()
+	}
+	ip4, ip6 := loadEnrPorts(node)
+	var out []ma.Multiaddr
+	add := func(ipScheme string, ip net.IP, transport string, port uint16) error {
+		if port == 0 {
+			return nil
+		}
+		multiAddr, err := ma.NewMultiaddr(fmt.Sprintf("/%s/%s/%s%s", ipScheme, ip.String(), fmt.Sprintf(transport, port), suffix))
+		if err != nil {
+			return err
+		}
+		out = append(out, multiAddr)
+		return nil
+	}
+	for _, ep := range []struct {
+		ipScheme string
+		ports    enrPorts
+		skip     bool
+	}{
+		{"ip4", ip4, opts.SkipIP4},
+		{"ip6", ip6, opts.SkipIP6},
+	} {
+		if ep.skip || ep.ports.ip == nil {
+			continue
+		}
+		if !opts.SkipTCP {
+			if err := add(ep.ipScheme, ep.ports.ip, "tcp/%d", ep.ports.tcp); err != nil {
+				return nil, err
+			}
+		}
+		if !opts.SkipQUIC {
+			if err := add(ep.ipScheme, ep.ports.ip, "udp/%d/quic-v1", ep.ports.quic); err != nil {
+				return nil, err
+			}
+		}
+		if opts.IncludeUDP {
+			if err := add(ep.ipScheme, ep.ports.ip, "udp/%d", ep.ports.udp); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return out, nil
+}
+
+// advertisable returns false for IPs that other nodes cannot dial: unspecified, loopback and link-local IPs.
+func advertisable(ip net.IP) bool {
+	return !ip.IsUnspecified() && !ip.IsLoopback() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast()
+}
+
+// MultiAddrsToENREntries converts listen addresses into the ENR ip/ip6, tcp/tcp6, quic/quic6 and udp/udp6 entries.
+// A /p2p component is ignored. Addresses with unspecified, loopback or link-local IPs are skipped,
+// and an error is returned if no address with an advertisable IP is left.
+// An error is returned for unsupported addresses,
+// and for conflicting addresses, as an ENR can only hold one endpoint of each kind.
+func MultiAddrsToENREntries(addrs []ma.Multiaddr) ([]enr.Entry, error) {
+	var ip4, ip6 enrPorts
+	set := func(addr ma.Multiaddr, dst *uint16, port uint16) error {
+		if *dst != 0 && *dst != port {
+			return fmt.Errorf("address %s conflicts with port %d", addr, *dst)
+		}
+		*dst = port
+		return nil
+	}
+	for _, addr := range addrs {
+		var ip net.IP
+		var ports *enrPorts
+		var transport string
+		var port uint16
+		quic := false
+		var err error
+		ma.ForEach(addr, func(c ma.Component) bool {
+			switch c.Protocol().Code {
+			case ma.P_IP4:
+				ip, ports = net.IP(c.RawValue()), &ip4
+			case ma.P_IP6:
+				ip, ports = net.IP(c.RawValue()), &ip6
+			case ma.P_TCP, ma.P_UDP:
+				if transport != "" {
+					err = fmt.Errorf("address %s has multiple transports", addr)
+					return false
+				}
+				transport = c.Protocol().Name
+				raw := c.RawValue()
+				port = uint16(raw[0])<<8 | uint16(raw[1])
+			case ma.P_QUIC_V1:
+				quic = true
+			case ma.P_P2P:
+			default:
+				err = fmt.Errorf("address %s has unsupported protocol %s", addr, c.Protocol().Name)
+				return false
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		if ports == nil || transport == "" || (quic && transport != "udp") {
+			return nil, fmt.Errorf("address %s is not a TCP, UDP or QUIC-v1 address over IPv4 or IPv6", addr)
+		}
+		if !advertisable(ip) {
+			continue
+		}
+		if ports.ip != nil && !ports.ip.Equal(ip) {
+			return nil, fmt.Errorf("address %s conflicts with IP %s", addr, ports.ip)
+		}
+		ports.ip = ip
+		dst := &ports.tcp
+		if quic {
+			dst = &ports.quic
+		} else if transport == "udp" {
+			dst = &ports.udp
+		}
+		if err := set(addr, dst, port); err != nil {
+			return nil, err
+		}
+	}
+	if ip4.ip == nil && ip6.ip == nil {
+		return nil, fmt.Errorf("none of the %d addresses has an advertisable IP", len(addrs))
+	}
+	var out []enr.Entry
+	if ip4.ip != nil {
+		out = append(out, enr.IPv4(ip4.ip))
+		if ip4.tcp != 0 {
+			out = append(out, enr.TCP(ip4.tcp))
+		}
+		if ip4.quic != 0 {
+			out = append(out, QUIC(ip4.quic))
+		}
+		if ip4.udp != 0 {
+			out = append(out, enr.UDP(ip4.udp))
+		}
+	}
+	if ip6.ip != nil {
+		out = append(out, enr.IPv6(ip6.ip))
+		if ip6.tcp != 0 {
+			out = append(out, enr.TCP6(ip6.tcp))
+		}
+		if ip6.quic != 0 {
+			out = append(out, QUIC6(ip6.quic))
+		}
+		if ip6.udp != 0 {
+			out = append(out, enr.UDP6(ip6.udp))
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,218 @@
+package addrutil
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	gcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func testNode(t *testing.T, signed bool, entries ...enr.Entry) *enode.Node {
+	var rec enr.Record
+	for _, e := range entries {
+		rec.Set(e)
+	}
+	if !signed {
+		return enode.SignNull(&rec, enode.ID{1})
+	}
+	k, err := gcrypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := enode.SignV4(&rec, k); err != nil {
+		t.Fatal(err)
+	}
+	n, err := enode.New(enode.ValidSchemes, &rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func multiAddrStrings(addrs []ma.Multiaddr) []string {
+	out := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		out = append(out, a.String())
+	}
+	return out
+}
+
+func TestEnodeToMultiAddrs(t *testing.T) {
+	ip4 := enr.IPv4{1, 2, 3, 4}
+	ip6 := enr.IPv6(net.ParseIP("2001:db8::1"))
+	cases := []struct {
+		name     string
+		entries  []enr.Entry
+		opts     MultiAddrOptions
+		expected []string
+	}{
+		{"ipv4 tcp", []enr.Entry{ip4, enr.TCP(9000)}, MultiAddrOptions{},
+			[]string{"/ip4/1.2.3.4/tcp/9000"}},
+		{"ipv4 tcp and quic", []enr.Entry{ip4, enr.TCP(9000), QUIC(9001), enr.UDP(9000)}, MultiAddrOptions{},
+			[]string{"/ip4/1.2.3.4/tcp/9000", "/ip4/1.2.3.4/udp/9001/quic-v1"}},
+		{"include udp", []enr.Entry{ip4, enr.TCP(9000), enr.UDP(9002)}, MultiAddrOptions{IncludeUDP: true},
+			[]string{"/ip4/1.2.3.4/tcp/9000", "/ip4/1.2.3.4/udp/9002"}},
+		{"ipv6 own ports", []enr.Entry{ip6, enr.TCP6(9100), QUIC6(9101)}, MultiAddrOptions{},
+			[]string{"/ip6/2001:db8::1/tcp/9100", "/ip6/2001:db8::1/udp/9101/quic-v1"}},
+		{"ipv6 default ports", []enr.Entry{ip4, ip6, enr.TCP(9000), QUIC(9001)}, MultiAddrOptions{},
+			[]string{"/ip4/1.2.3.4/tcp/9000", "/ip4/1.2.3.4/udp/9001/quic-v1",
+				"/ip6/2001:db8::1/tcp/9000", "/ip6/2001:db8::1/udp/9001/quic-v1"}},
+		{"skip ipv4", []enr.Entry{ip4, ip6, enr.TCP(9000)}, MultiAddrOptions{SkipIP4: true},
+			[]string{"/ip6/2001:db8::1/tcp/9000"}},
+		{"skip ipv6", []enr.Entry{ip4, ip6, enr.TCP(9000)}, MultiAddrOptions{SkipIP6: true},
+			[]string{"/ip4/1.2.3.4/tcp/9000"}},
+		{"skip tcp", []enr.Entry{ip4, enr.TCP(9000), QUIC(9001)}, MultiAddrOptions{SkipTCP: true},
+			[]string{"/ip4/1.2.3.4/udp/9001/quic-v1"}},
+		{"skip quic", []enr.Entry{ip4, enr.TCP(9000), QUIC(9001)}, MultiAddrOptions{SkipQUIC: true},
+			[]string{"/ip4/1.2.3.4/tcp/9000"}},
+		{"missing ports", []enr.Entry{ip4}, MultiAddrOptions{}, []string{}},
+		{"missing ip", []enr.Entry{enr.TCP(9000)}, MultiAddrOptions{}, []string{}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			out, err := EnodeToMultiAddrs(testNode(t, true, c.entries...), c.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := multiAddrStrings(out); !reflect.DeepEqual(got, c.expected) {
+				t.Fatalf("got %v, expected %v", got, c.expected)
+			}
+		})
+	}
+}
+
+func TestEnodeToMultiAddrsPeerID(t *testing.T) {
+	n := testNode(t, true, enr.IPv4{1, 2, 3, 4}, enr.TCP(9000))
+	out, err := EnodeToMultiAddrs(n, MultiAddrOptions{IncludePeerID: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"/ip4/1.2.3.4/tcp/9000/p2p/" + PeerIDFromPubkey(n.Pubkey()).String()}
+	if got := multiAddrStrings(out); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("got %v, expected %v", got, expected)
+	}
+	if _, err := EnodeToMultiAddrs(testNode(t, false, enr.IPv4{1, 2, 3, 4}, enr.TCP(9000)), MultiAddrOptions{IncludePeerID: true}); err == nil {
+		t.Fatal("expected an error for a node without pubkey")
+	}
+}
+
+func TestEnodesToMultiAddrs(t *testing.T) {
+	withKey := testNode(t, true, enr.IPv4{1, 2, 3, 4}, enr.TCP(9000), QUIC(9001))
+	noKey := testNode(t, false, enr.IPv4{5, 6, 7, 8}, enr.TCP(9000))
+	noIP := testNode(t, true, enr.TCP(9000))
+	nodes := []*enode.Node{withKey, noKey, noIP}
+	suffix := "/p2p/" + PeerIDFromPubkey(withKey.Pubkey()).String()
+
+	out, err := EnodesToMultiAddrs(nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"/ip4/1.2.3.4/tcp/9000" + suffix}
+	if got := multiAddrStrings(out); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("got %v, expected %v", got, expected)
+	}
+
+	out, err = EnodesToMultiAddrsWithOptions(nodes, MultiAddrOptions{IncludePeerID: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{"/ip4/1.2.3.4/tcp/9000" + suffix, "/ip4/1.2.3.4/udp/9001/quic-v1" + suffix}
+	if got := multiAddrStrings(out); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("got %v, expected %v", got, expected)
+	}
+
+	out, err = EnodesToMultiAddrsWithOptions(nodes, MultiAddrOptions{SkipQUIC: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{"/ip4/1.2.3.4/tcp/9000", "/ip4/5.6.7.8/tcp/9000"}
+	if got := multiAddrStrings(out); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("got %v, expected %v", got, expected)
+	}
+}
+
+func TestAdvertisable(t *testing.T) {
+	cases := []struct {
+		ip       string
+		expected bool
+	}{
+		{"1.2.3.4", true},
+		{"192.168.1.1", true},
+		{"2001:db8::1", true},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"169.254.1.1", false},
+		{"fe80::1", false},
+		{"ff02::1", false},
+	}
+	for _, c := range cases {
+		t.Run(c.ip, func(t *testing.T) {
+			if got := advertisable(net.ParseIP(c.ip)); got != c.expected {
+				t.Fatalf("got %v, expected %v", got, c.expected)
+			}
+		})
+	}
+}
+
+func TestMultiAddrsToENREntries(t *testing.T) {
+	ip6 := enr.IPv6(net.ParseIP("2001:db8::1"))
+	cases := []struct {
+		name     string
+		addrs    []string
+		expected []enr.Entry
+		err      bool
+	}{
+		{"ipv4 tcp", []string{"/ip4/1.2.3.4/tcp/9000"},
+			[]enr.Entry{enr.IPv4{1, 2, 3, 4}, enr.TCP(9000)}, false},
+		{"ipv4 all", []string{"/ip4/1.2.3.4/tcp/9000", "/ip4/1.2.3.4/udp/9001/quic-v1", "/ip4/1.2.3.4/udp/9000"},
+			[]enr.Entry{enr.IPv4{1, 2, 3, 4}, enr.TCP(9000), QUIC(9001), enr.UDP(9000)}, false},
+		{"ipv6", []string{"/ip6/2001:db8::1/tcp/9100", "/ip6/2001:db8::1/udp/9101/quic-v1"},
+			[]enr.Entry{ip6, enr.TCP6(9100), QUIC6(9101)}, false},
+		{"dual stack", []string{"/ip4/1.2.3.4/tcp/9000", "/ip6/2001:db8::1/tcp/9000"},
+			[]enr.Entry{enr.IPv4{1, 2, 3, 4}, enr.TCP(9000), ip6, enr.TCP6(9000)}, false},
+		{"peer id ignored", []string{"/ip4/1.2.3.4/tcp/9000/p2p/16Uiu2HAmQ4bJ6Bf2LzGJ5Ba1cU7fK3tBkJ8KmjHkWy7v7kLbqVvA"},
+			[]enr.Entry{enr.IPv4{1, 2, 3, 4}, enr.TCP(9000)}, false},
+		{"loopback and link-local skipped", []string{"/ip4/127.0.0.1/tcp/9000", "/ip6/fe80::1/tcp/9000", "/ip4/1.2.3.4/tcp/9000"},
+			[]enr.Entry{enr.IPv4{1, 2, 3, 4}, enr.TCP(9000)}, false},
+		{"unspecified skipped", []string{"/ip4/0.0.0.0/tcp/9000", "/ip6/::/tcp/9000", "/ip6/2001:db8::1/tcp/9000"},
+			[]enr.Entry{ip6, enr.TCP6(9000)}, false},
+		{"only loopback", []string{"/ip4/127.0.0.1/tcp/9000", "/ip6/::1/tcp/9000"}, nil, true},
+		{"no addrs", nil, nil, true},
+		{"conflicting ip", []string{"/ip4/1.2.3.4/tcp/9000", "/ip4/1.2.3.5/tcp/9000"}, nil, true},
+		{"conflicting port", []string{"/ip4/1.2.3.4/tcp/9000", "/ip4/1.2.3.4/tcp/9001"}, nil, true},
+		{"missing port", []string{"/ip4/1.2.3.4"}, nil, true},
+		{"dns", []string{"/dns4/example.com/tcp/9000"}, nil, true},
+		{"quic over tcp", []string{"/ip4/1.2.3.4/tcp/9000/quic-v1"}, nil, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			addrs := make([]ma.Multiaddr, 0, len(c.addrs))
+			for _, s := range c.addrs {
+				a, err := ma.NewMultiaddr(s)
+				if err != nil {
+					t.Fatal(err)
+				}
+				addrs = append(addrs, a)
+			}
+			entries, err := MultiAddrsToENREntries(addrs)
+			if c.err {
+				if err == nil {
+					t.Fatalf("expected an error, got entries %v", entries)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(entries, c.expected) {
+				t.Fatalf("got %v, expected %v", entries, c.expected)
+			}
+		})
+	}
+}
@@ -14,9 +14,11 @@ import (
 	"github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/peer"
 	ma "github.com/multiformats/go-multiaddr"
+	"github.com/protolambda/go-eth2-peerstore/types"
 	"github.com/protolambda/zrnt/eth2/beacon/common"
 	"github.com/protolambda/ztyp/codec"
 	"net"
+	"sort"
 	"strings"
 )
 
@@ -77,6 +79,121 @@ func (aee AttnetsENREntry) String() string {
 	return hex.EncodeToString(aee)
 }
 
+// SyncnetsENREntry is the Altair "syncnets" key, which holds the sync committee subnets bitvector of the node.
+type SyncnetsENREntry []byte
+
+func NewSyncnetsENREntry(dat *types.SyncnetBits) SyncnetsENREntry {
+	var buf bytes.Buffer
+	if err := dat.Serialize(codec.NewEncodingWriter(&buf)); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+func (see SyncnetsENREntry) ENRKey() string {
+	return "syncnets"
+}
+
+func (see SyncnetsENREntry) SyncnetBits() (types.SyncnetBits, error) {
+	var dat types.SyncnetBits
+	if err := dat.Deserialize(codec.NewDecodingReader(bytes.NewReader(see), uint64(len(see)))); err != nil {
+		return types.SyncnetBits{}, err
+	}
+	return dat, nil
+}
+
+func (see SyncnetsENREntry) String() string {
+	return hex.EncodeToString(see)
+}
+
+// CustodyGroupCountENREntry is the Fulu "cgc" key, which holds the PeerDAS custody group count of the node.
+type CustodyGroupCountENREntry uint64
+
+func (cee CustodyGroupCountENREntry) ENRKey() string {
+	return "cgc"
+}
+
+func (cee CustodyGroupCountENREntry) String() string {
+	return fmt.Sprintf("%d", uint64(cee))
+}
+
+// NextForkDigestENREntry is the Fulu "nfd" key, which holds the fork digest of the next scheduled fork.
+type NextForkDigestENREntry []byte
+
+func NewNextForkDigestENREntry(digest common.ForkDigest) NextForkDigestENREntry {
+	return digest[:]
+}
+
+func (nee NextForkDigestENREntry) ENRKey() string {
+	return "nfd"
+}
+
+func (nee NextForkDigestENREntry) ForkDigest() (common.ForkDigest, error) {
+	var dat common.ForkDigest
+	if len(nee) != len(dat) {
+		return common.ForkDigest{}, fmt.Errorf("expected 4 bytes, got %d", len(nee))
+	}
+	copy(dat[:], nee)
+	return dat, nil
+}
+
+func (nee NextForkDigestENREntry) String() string {
+	return hex.EncodeToString(nee)
+}
+
+// ClientENREntry is the EIP-7636 "client" key, which holds the client name, version and optional build.
+type ClientENREntry struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Build   string `json:"build,omitempty" rlp:"optional"`
+}
+
+func (cee ClientENREntry) ENRKey() string {
+	return "client"
+}
+
+// String formats the client like a user agent: name/version/build
+func (cee ClientENREntry) String() string {
+	out := cee.Name
+	if cee.Version != "" {
+		out += "/" + cee.Version
+	}
+	if cee.Build != "" {
+		out += "/" + cee.Build
+	}
+	return out
+}
+
+// MatchesUserAgent checks if the libp2p identify user agent is consistent with the client:
+// the first user agent segment must be the client name, and the second segment must start with the version, if any.
+// Names are compared case-insensitive, versions without "v" prefix.
+func (cee ClientENREntry) MatchesUserAgent(userAgent string) bool {
+	parts := strings.Split(userAgent, "/")
+	if !strings.EqualFold(parts[0], cee.Name) {
+		return false
+	}
+	if cee.Version == "" {
+		return true
+	}
+	if len(parts) < 2 {
+		return false
+	}
+	trim := func(v string) string {
+		return strings.TrimPrefix(strings.ToLower(v), "v")
+	}
+	return strings.HasPrefix(trim(parts[1]), trim(cee.Version))
+}
+
+// QUIC is the "quic" key, which holds the QUIC port of the node.
+type QUIC uint16
+
+func (v QUIC) ENRKey() string { return "quic" }
+
+// QUIC6 is the "quic6" key, which holds the IPv6-specific QUIC port of the node.
+type QUIC6 uint16
+
+func (v QUIC6) ENRKey() string { return "quic6" }
+
 var EnrEntries = map[string]func() (enr.Entry, func() string){
 	"secp256k1": func() (enr.Entry, func() string) {
 		res := new(enode.Secp256k1)
@@ -111,6 +228,18 @@ var EnrEntries = map[string]func() (enr.Entry, func() string){
 			return fmt.Sprintf("%d", *res)
 		}
 	},
+	"quic": func() (enr.Entry, func() string) {
+		res := new(QUIC)
+		return res, func() string {
+			return fmt.Sprintf("%d", *res)
+		}
+	},
+	"quic6": func() (enr.Entry, func() string) {
+		res := new(QUIC6)
+		return res, func() string {
+			return fmt.Sprintf("%d", *res)
+		}
+	},
 	"id": func() (enr.Entry, func() string) {
 		res := new(enr.ID)
 		return res, func() string {
@@ -141,6 +270,30 @@ var EnrEntries = map[string]func() (enr.Entry, func() string){
 			return res.String()
 		}
 	},
+	"syncnets": func() (enr.Entry, func() string) {
+		res := new(SyncnetsENREntry)
+		return res, func() string {
+			return res.String()
+		}
+	},
+	"client": func() (enr.Entry, func() string) {
+		res := new(ClientENREntry)
+		return res, func() string {
+			return res.String()
+		}
+	},
+	"cgc": func() (enr.Entry, func() string) {
+		res := new(CustodyGroupCountENREntry)
+		return res, func() string {
+			return res.String()
+		}
+	},
+	"nfd": func() (enr.Entry, func() string) {
+		res := new(NextForkDigestENREntry)
+		return res, func() string {
+			return res.String()
+		}
+	},
 }
 
 func ParseEnrBytes(v string) ([]byte, error) {
@@ -299,10 +452,12 @@ func EnrToString(record *enr.Record) (string, error) {
 	return "enr:" + b64, nil
 }
 
+// EnodesToMultiAddrs returns the TCP multiaddr of each node with an IP and pubkey, including the /p2p/<peer id> component.
+// See EnodesToMultiAddrsWithOptions for all addresses of the nodes.
 func EnodesToMultiAddrs(nodes []*enode.Node) ([]ma.Multiaddr, error) {
 	var out []ma.Multiaddr
 	for _, n := range nodes {
-		if n.IP() == nil {
+		if n.IP() == nil || n.Pubkey() == nil {
 			continue
 		}
 		multiAddr, err := EnodeToMultiAddr(n)
@@ -314,6 +469,23 @@ func EnodesToMultiAddrs(nodes []*enode.Node) ([]ma.Multiaddr, error) {
 	return out, nil
 }
 
+// EnodesToMultiAddrsWithOptions returns the multiaddrs of all nodes, filtered by the options.
+// If the options include the peer ID, nodes without a secp256k1 pubkey are skipped.
+func EnodesToMultiAddrsWithOptions(nodes []*enode.Node, opts MultiAddrOptions) ([]ma.Multiaddr, error) {
+	var out []ma.Multiaddr
+	for _, n := range nodes {
+		if opts.IncludePeerID && n.Pubkey() == nil {
+			continue
+		}
+		multiAddrs, err := EnodeToMultiAddrs(n, opts)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, multiAddrs...)
+	}
+	return out, nil
+}
+
 func PeerIDFromPubkey(pubkey *ecdsa.PublicKey) peer.ID {
 	// save for this kind of pubkey
 	// TODO: struct init, instead of type cast
@@ -321,6 +493,8 @@ func PeerIDFromPubkey(pubkey *ecdsa.PublicKey) peer.ID {
 	return id
 }
 
+// EnodeToMultiAddr returns the TCP multiaddr of the node IP, including the /p2p/<peer id> component.
+// See EnodeToMultiAddrs for all addresses of the node.
 func EnodeToMultiAddr(node *enode.Node) (ma.Multiaddr, error) {
 	ipScheme := "ip4"
 	if len(node.IP()) == net.IPv6len {
@@ -388,3 +562,127 @@ func ParseEnrAttnets(n *enode.Node) (attnetbits *common.AttnetBits, exists bool,
 	}
 	return &dat, true, nil
 }
+
+func ParseEnrSyncnets(n *enode.Node) (syncnetbits *types.SyncnetBits, exists bool, err error) {
+	var syncnets SyncnetsENREntry
+	if err := n.Load(&syncnets); err != nil {
+		if enr.IsNotFound(err) {
+			return nil, false, nil
+		}
+		return nil, true, fmt.Errorf("failed parsing syncnets: %v", err)
+	}
+	dat, err := syncnets.SyncnetBits()
+	if err != nil {
+		return nil, true, fmt.Errorf("failed parsing syncnets bytes: %v", err)
+	}
+	return &dat, true, nil
+}
+
+func ParseEnrClient(n *enode.Node) (client *ClientENREntry, exists bool, err error) {
+	var dat ClientENREntry
+	if err := n.Load(&dat); err != nil {
+		if enr.IsNotFound(err) {
+			return nil, false, nil
+		}
+		return nil, true, fmt.Errorf("failed parsing client: %v", err)
+	}
+	return &dat, true, nil
+}
+
+func ParseEnrCustodyGroupCount(n *enode.Node) (count uint64, exists bool, err error) {
+	var cgc CustodyGroupCountENREntry
+	if err := n.Load(&cgc); err != nil {
+		if enr.IsNotFound(err) {
+			return 0, false, nil
+		}
+		return 0, true, fmt.Errorf("failed parsing cgc: %v", err)
+	}
+	return uint64(cgc), true, nil
+}
+
+func ParseEnrNextForkDigest(n *enode.Node) (digest *common.ForkDigest, exists bool, err error) {
+	var nfd NextForkDigestENREntry
+	if err := n.Load(&nfd); err != nil {
+		if enr.IsNotFound(err) {
+			return nil, false, nil
+		}
+		return nil, true, fmt.Errorf("failed parsing nfd: %v", err)
+	}
+	dat, err := nfd.ForkDigest()
+	if err != nil {
+		return nil, true, fmt.Errorf("failed parsing nfd bytes: %v", err)
+	}
+	return &dat, true, nil
+}
+
+// FormatEnrValue formats a raw ENR value in human-readable form, if the key is known in EnrEntries.
+// Unknown or undecodable values are formatted as hex.
+func FormatEnrValue(key string, raw rlp.RawValue) string {
+	getTypedValue, ok := EnrEntries[key]
+	if !ok {
+		return hex.EncodeToString(raw)
+	}
+	typedValue, getValueStr := getTypedValue()
+	if err := rlp.DecodeBytes(raw, typedValue); err != nil {
+		return hex.EncodeToString(raw)
+	}
+	return getValueStr()
+}
+
+// ENRChange describes the change of a single ENR key between two records
+type ENRChange struct {
+	Key string `json:"key"`
+	// Previous value, empty if the key was added
+	Old string `json:"old,omitempty"`
+	// Next value, empty if the key was removed
+	New string `json:"new,omitempty"`
+}
+
+func (c ENRChange) String() string {
+	return fmt.Sprintf("%s: %q -> %q", c.Key, c.Old, c.New)
+}
+
+func enrPairs(rec *enr.Record) map[string]rlp.RawValue {
+	out := make(map[string]rlp.RawValue)
+	if rec == nil {
+		return out
+	}
+	// first element is the seq nr, followed by key-value pairs
+	elems := rec.AppendElements(nil)
+	for i := 1; i+1 < len(elems); i += 2 {
+		out[elems[i].(string)] = elems[i+1].(rlp.RawValue)
+	}
+	return out
+}
+
+// DiffENR lists the keys that were added, removed or changed from record a to record b, sorted by key.
+// Either record may be nil, to diff against an empty record.
+func DiffENR(a *enr.Record, b *enr.Record) (out []ENRChange) {
+	prev, next := enrPairs(a), enrPairs(b)
+	keys := make([]string, 0, len(prev)+len(next))
+	for k := range prev {
+		keys = append(keys, k)
+	}
+	for k := range next {
+		if _, ok := prev[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		p, inPrev := prev[k]
+		n, inNext := next[k]
+		if inPrev && inNext && bytes.Equal(p, n) {
+			continue
+		}
+		change := ENRChange{Key: k}
+		if inPrev {
+			change.Old = FormatEnrValue(k, p)
+		}
+		if inNext {
+			change.New = FormatEnrValue(k, n)
+		}
+		out = append(out, change)
+	}
+	return out
+}
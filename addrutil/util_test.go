@@ -0,0 +1,116 @@
+package addrutil
+
+import (
+	"testing"
+
+	gcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+)
+
+// reparse signs the record, and parses it back from its text representation
+func reparse(t *testing.T, rec *enr.Record) *enode.Node {
+	t.Helper()
+	k, err := gcrypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := enode.SignV4(rec, k); err != nil {
+		t.Fatal(err)
+	}
+	n, err := enode.New(enode.ValidSchemes, rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseEnr(n.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := enode.New(enode.ValidSchemes, parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestParseEnrClient(t *testing.T) {
+	cases := []struct {
+		name   string
+		client ClientENREntry
+	}{
+		{"with build", ClientENREntry{Name: "Lighthouse", Version: "5.3.0", Build: "d6ba8c3"}},
+		{"without build", ClientENREntry{Name: "teku", Version: "24.10.3"}},
+		{"name only", ClientENREntry{Name: "nimbus"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var rec enr.Record
+			rec.Set(c.client)
+			got, exists, err := ParseEnrClient(reparse(t, &rec))
+			if err != nil || !exists {
+				t.Fatalf("failed to load client entry: %v, %v", exists, err)
+			}
+			if *got != c.client {
+				t.Fatalf("got %+v, expected %+v", *got, c.client)
+			}
+		})
+	}
+	t.Run("missing", func(t *testing.T) {
+		var rec enr.Record
+		got, exists, err := ParseEnrClient(reparse(t, &rec))
+		if err != nil || exists || got != nil {
+			t.Fatalf("expected no client entry, got %v, %v, %v", got, exists, err)
+		}
+	})
+	t.Run("malformed", func(t *testing.T) {
+		var rec enr.Record
+		rec.Set(enr.WithEntry("client", uint64(42)))
+		if _, exists, err := ParseEnrClient(reparse(t, &rec)); err == nil || !exists {
+			t.Fatalf("expected an error for a malformed client entry, got %v, %v", exists, err)
+		}
+	})
+}
+
+func TestClientENREntryString(t *testing.T) {
+	cases := []struct {
+		client   ClientENREntry
+		expected string
+	}{
+		{ClientENREntry{Name: "Lighthouse", Version: "5.3.0", Build: "d6ba8c3"}, "Lighthouse/5.3.0/d6ba8c3"},
+		{ClientENREntry{Name: "teku", Version: "24.10.3"}, "teku/24.10.3"},
+		{ClientENREntry{Name: "nimbus"}, "nimbus"},
+	}
+	for _, c := range cases {
+		if got := c.client.String(); got != c.expected {
+			t.Fatalf("got %q, expected %q", got, c.expected)
+		}
+	}
+}
+
+func TestMatchesUserAgent(t *testing.T) {
+	lighthouse := ClientENREntry{Name: "Lighthouse", Version: "5.3.0", Build: "d6ba8c3"}
+	cases := []struct {
+		name      string
+		client    ClientENREntry
+		userAgent string
+		expected  bool
+	}{
+		{"same client", lighthouse, "Lighthouse/v5.3.0-d6ba8c3/x86_64-linux", true},
+		{"name case", lighthouse, "lighthouse/v5.3.0-d6ba8c3/x86_64-linux", true},
+		{"version prefix in entry", ClientENREntry{Name: "Lighthouse", Version: "v5.3.0"}, "Lighthouse/5.3.0", true},
+		{"no version in entry", ClientENREntry{Name: "nimbus"}, "nimbus", true},
+		{"own string", lighthouse, lighthouse.String(), true},
+		{"other client", lighthouse, "Prysm/v5.1.2/bb2d0ad8fa76b3abb2ebe27bba21d6ba7c1eeb0c", false},
+		{"other version", lighthouse, "Lighthouse/v5.2.1-9e12c21/x86_64-linux", false},
+		{"missing version", lighthouse, "Lighthouse", false},
+		{"empty user agent", lighthouse, "", false},
+		{"name as suffix", lighthouse, "NotLighthouse/v5.3.0", false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.client.MatchesUserAgent(c.userAgent); got != c.expected {
+				t.Fatalf("got %v, expected %v", got, c.expected)
+			}
+		})
+	}
+}
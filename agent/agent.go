@@ -0,0 +1,135 @@
+// Package agent parses libp2p user agents (identify AgentVersion) of consensus clients.
+package agent
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Unknown is the name of clients that are not in the KnownClients table
+const Unknown = "unknown"
+
+// Client is the implementation a peer runs, as parsed from its user agent.
+// All fields but Name may be empty if the user agent does not include them.
+type Client struct {
+	// Name is the canonical client name from KnownClients, or Unknown
+	Name string `json:"name"`
+	// Version is the semantic version, without "v" prefix, e.g. "5.3.0" or "1.0.0-rc.1"
+	Version string `json:"version,omitempty"`
+	// Commit is the (abbreviated) git commit hash of the build
+	Commit string `json:"commit,omitempty"`
+	OS     string `json:"os,omitempty"`
+	Arch   string `json:"arch,omitempty"`
+}
+
+// Is returns true if the client has the given canonical name, compared case-insensitive.
+func (c *Client) Is(name string) bool {
+	return strings.EqualFold(c.Name, name)
+}
+
+func (c *Client) String() string {
+	out := c.Name
+	if c.Version != "" {
+		out += " v" + c.Version
+	}
+	if c.Commit != "" {
+		out += " (" + c.Commit + ")"
+	}
+	return out
+}
+
+// KnownClient maps the product names a client uses in its user agent to a canonical name.
+type KnownClient struct {
+	Name     string
+	Products []string
+}
+
+// KnownClients is the table of consensus clients recognized by Parse
+var KnownClients = []KnownClient{
+	{Name: "Lighthouse", Products: []string{"lighthouse"}},
+	{Name: "Prysm", Products: []string{"prysm"}},
+	{Name: "Teku", Products: []string{"teku"}},
+	{Name: "Nimbus", Products: []string{"nimbus"}},
+	{Name: "Lodestar", Products: []string{"lodestar"}},
+	{Name: "Grandine", Products: []string{"grandine"}},
+	{Name: "Erigon-Caplin", Products: []string{"erigon", "caplin"}},
+}
+
+var (
+	versionPattern = regexp.MustCompile(`^v?(\d+\.\d+(?:\.\d+)?)(?:-(.+))?$`)
+	commitPattern  = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+)
+
+var knownOS = map[string]string{
+	"linux":   "linux",
+	"windows": "windows",
+	"win":     "windows",
+	"macos":   "macos",
+	"darwin":  "macos",
+	"osx":     "macos",
+	"freebsd": "freebsd",
+}
+
+var knownArch = map[string]string{
+	"x86_64":  "x86_64",
+	"amd64":   "x86_64",
+	"x64":     "x86_64",
+	"aarch64": "aarch64",
+	"arm64":   "aarch64",
+	"arm":     "arm",
+	"i386":    "x86",
+	"386":     "x86",
+	"x86":     "x86",
+}
+
+func lookupProduct(product string) string {
+	product = strings.ToLower(product)
+	for _, c := range KnownClients {
+		for _, p := range c.Products {
+			if product == p {
+				return c.Name
+			}
+		}
+	}
+	return Unknown
+}
+
+// Parse parses a user agent like "Lighthouse/v5.3.0-d6ba8c3/x86_64-linux" or "teku/v24.10.0/linux-x86_64/...".
+// The first segment is the product, looked up in KnownClients.
+// The other segments are recognized as version (optionally with commit suffix), commit, or OS/arch pair,
+// and unrecognized segments are ignored.
+func Parse(userAgent string) Client {
+	parts := strings.Split(strings.TrimSpace(userAgent), "/")
+	out := Client{Name: lookupProduct(parts[0])}
+	for _, part := range parts[1:] {
+		lower := strings.ToLower(part)
+		if out.Version == "" {
+			if m := versionPattern.FindStringSubmatch(lower); m != nil {
+				out.Version = m[1]
+				if suffix := m[2]; suffix != "" {
+					// the suffix is either a commit, or a pre-release with an optional commit
+					if i := strings.LastIndex(suffix, "-"); commitPattern.MatchString(suffix[i+1:]) {
+						out.Commit = suffix[i+1:]
+						suffix = strings.TrimSuffix(suffix[:i+1], "-")
+					}
+					if suffix != "" {
+						out.Version += "-" + suffix
+					}
+				}
+				continue
+			}
+		}
+		if out.Commit == "" && commitPattern.MatchString(lower) {
+			out.Commit = lower
+			continue
+		}
+		for _, token := range strings.Split(lower, "-") {
+			if os, ok := knownOS[token]; ok && out.OS == "" {
+				out.OS = os
+			} else if arch, ok := knownArch[token]; ok && out.Arch == "" {
+				out.Arch = arch
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,86 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		userAgent string
+		expected  Client
+	}{
+		{"Lighthouse/v5.3.0-d6ba8c3/x86_64-linux",
+			Client{Name: "Lighthouse", Version: "5.3.0", Commit: "d6ba8c3", OS: "linux", Arch: "x86_64"}},
+		{"Lighthouse/v6.0.0-rc.1-1d1e2b4/aarch64-macos",
+			Client{Name: "Lighthouse", Version: "6.0.0-rc.1", Commit: "1d1e2b4", OS: "macos", Arch: "aarch64"}},
+		{"Prysm/v5.1.2/bb2d0ad8fa76b3abb2ebe27bba21d6ba7c1eeb0c",
+			Client{Name: "Prysm", Version: "5.1.2", Commit: "bb2d0ad8fa76b3abb2ebe27bba21d6ba7c1eeb0c"}},
+		{"teku/teku/v24.10.3/linux-x86_64/-eclipseadoptium-openjdk64bitservervm-java-21",
+			Client{Name: "Teku", Version: "24.10.3", OS: "linux", Arch: "x86_64"}},
+		{"teku/teku/v24.12.0-rc.1/windows-amd64/-eclipseadoptium-openjdk64bitservervm-java-21",
+			Client{Name: "Teku", Version: "24.12.0-rc.1", OS: "windows", Arch: "x86_64"}},
+		{"nimbus", Client{Name: "Nimbus"}},
+		{"lodestar/v1.22.0/f1ff5f5", Client{Name: "Lodestar", Version: "1.22.0", Commit: "f1ff5f5"}},
+		{"lodestar/v1.23.0-rc.0/d1b5c4a/linux-arm64",
+			Client{Name: "Lodestar", Version: "1.23.0-rc.0", Commit: "d1b5c4a", OS: "linux", Arch: "aarch64"}},
+		{"Grandine/1.0.0-a5f7b13/x86_64-linux",
+			Client{Name: "Grandine", Version: "1.0.0", Commit: "a5f7b13", OS: "linux", Arch: "x86_64"}},
+		{"erigon/caplin", Client{Name: "Erigon-Caplin"}},
+		{"  Lighthouse/v5.3.0-d6ba8c3/x86_64-linux  ",
+			Client{Name: "Lighthouse", Version: "5.3.0", Commit: "d6ba8c3", OS: "linux", Arch: "x86_64"}},
+		{"", Client{Name: Unknown}},
+		{"rust-libp2p/0.53.2", Client{Name: Unknown, Version: "0.53.2"}},
+		{"Mozilla/5.0 (X11; Linux x86_64)", Client{Name: Unknown}},
+		{"Lighthouse/unknown/x86_64-linux", Client{Name: "Lighthouse", OS: "linux", Arch: "x86_64"}},
+	}
+	for _, c := range cases {
+		t.Run(c.userAgent, func(t *testing.T) {
+			if got := Parse(c.userAgent); got != c.expected {
+				t.Fatalf("got %+v, expected %+v", got, c.expected)
+			}
+		})
+	}
+}
+
+func TestKnownClients(t *testing.T) {
+	names := make(map[string]bool)
+	products := make(map[string]bool)
+	for _, c := range KnownClients {
+		if c.Name == "" || c.Name == Unknown || names[c.Name] {
+			t.Fatalf("invalid or duplicate client name %q", c.Name)
+		}
+		names[c.Name] = true
+		for _, p := range c.Products {
+			if p != strings.ToLower(p) {
+				t.Fatalf("product %q of %s is not lower case", p, c.Name)
+			}
+			if products[p] {
+				t.Fatalf("product %q of %s is listed more than once", p, c.Name)
+			}
+			products[p] = true
+			if got := Parse(strings.ToUpper(p) + "/v1.0.0"); got.Name != c.Name || got.Version != "1.0.0" {
+				t.Fatalf("product %q parsed as %+v, expected %s v1.0.0", p, got, c.Name)
+			}
+		}
+	}
+}
+
+func TestClientString(t *testing.T) {
+	cases := []struct {
+		client   Client
+		expected string
+	}{
+		{Client{Name: "Lighthouse", Version: "5.3.0", Commit: "d6ba8c3", OS: "linux"}, "Lighthouse v5.3.0 (d6ba8c3)"},
+		{Client{Name: "Teku", Version: "24.10.3"}, "Teku v24.10.3"},
+		{Client{Name: Unknown}, Unknown},
+	}
+	for _, c := range cases {
+		if got := c.client.String(); got != c.expected {
+			t.Fatalf("got %q, expected %q", got, c.expected)
+		}
+		if !c.client.Is(strings.ToUpper(c.client.Name)) {
+			t.Fatalf("client %s does not match its upper case name", c.client.Name)
+		}
+	}
+}
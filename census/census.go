@@ -0,0 +1,200 @@
+// Package census aggregates the peers of an eth2 peerstore, e.g. for client diversity statistics.
+package census
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+	"github.com/protolambda/go-eth2-peerstore"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/ztyp/bitfields"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Unknown is the key peers are counted under if the aggregated data is not known
+const Unknown = "unknown"
+
+// Counts maps a key, e.g. a client name, to a number of peers
+type Counts map[string]int
+
+// Census is an aggregate view of all eth2 peers in a peerstore.
+type Census struct {
+	// When the census was taken
+	Time time.Time `json:"time"`
+	// Total number of eth2 peers
+	Peers int `json:"peers"`
+	// Peers by client name, see agent.KnownClients
+	Clients Counts `json:"clients"`
+	// Peers by client version, per client name
+	ClientVersions map[string]Counts `json:"client_versions"`
+	// Peers by fork digest, from the ENR, or else from the status
+	ForkDigests Counts `json:"fork_digests"`
+	// Peers by next fork, formatted as "<next fork version>@<next fork epoch>" from the ENR,
+	// or "none" if no next fork is scheduled
+	NextForks Counts `json:"next_forks"`
+	// Peers by number of attestation subnets subscribed to, from the metadata, or else from the ENR
+	Attnets Counts `json:"attnets"`
+	// Peers by IP family of their addresses: "ip4", "ip6", "dual", or Unknown
+	IPFamilies Counts `json:"ip_families"`
+}
+
+func (c *Census) add(data *eth2peerstore.PeerAllData) {
+	c.Peers += 1
+
+	client, version := Unknown, Unknown
+	if data.Client != nil {
+		client = data.Client.Name
+		if data.Client.Version != "" {
+			version = data.Client.Version
+		}
+	}
+	c.Clients[client] += 1
+	if c.ClientVersions[client] == nil {
+		c.ClientVersions[client] = make(Counts)
+	}
+	c.ClientVersions[client][version] += 1
+
+	digest := Unknown
+	if data.ForkDigest != nil {
+		digest = data.ForkDigest.String()
+	} else if data.Status != nil {
+		digest = data.Status.ForkDigest.String()
+	}
+	c.ForkDigests[digest] += 1
+
+	nextFork := Unknown
+	if data.NextForkVersion != nil && data.NextForkEpoch != nil {
+		if *data.NextForkEpoch == ^common.Epoch(0) {
+			nextFork = "none"
+		} else {
+			nextFork = fmt.Sprintf("%s@%d", data.NextForkVersion, *data.NextForkEpoch)
+		}
+	}
+	c.NextForks[nextFork] += 1
+
+	attnets := Unknown
+	var bits *common.AttnetBits
+	if data.MetaData != nil {
+		bits = &data.MetaData.Attnets
+	} else if data.Attnets != nil {
+		bits = data.Attnets
+	}
+	if bits != nil {
+		count := 0
+		for i := uint64(0); i < common.ATTESTATION_SUBNET_COUNT; i++ {
+			if bitfields.GetBit(bits[:], i) {
+				count += 1
+			}
+		}
+		attnets = strconv.Itoa(count)
+	}
+	c.Attnets[attnets] += 1
+
+	ip4, ip6 := false, false
+	for _, addr := range data.Addrs {
+		ip4 = ip4 || strings.HasPrefix(addr, "/ip4/")
+		ip6 = ip6 || strings.HasPrefix(addr, "/ip6/")
+	}
+	if data.ENR != nil {
+		var enrIP4 enr.IPv4
+		var enrIP6 enr.IPv6
+		ip4 = ip4 || data.ENR.Load(&enrIP4) == nil
+		ip6 = ip6 || data.ENR.Load(&enrIP6) == nil
+	}
+	family := Unknown
+	switch {
+	case ip4 && ip6:
+		family = "dual"
+	case ip4:
+		family = "ip4"
+	case ip6:
+		family = "ip6"
+	}
+	c.IPFamilies[family] += 1
+}
+
+// Take scans all eth2 peers of the peerstore, and aggregates them into a census taken at the given time.
+func Take(ctx context.Context, ps eth2peerstore.PeerIterator, now time.Time) (*Census, error) {
+	c := &Census{
+		Time:           now,
+		Clients:        make(Counts),
+		ClientVersions: make(map[string]Counts),
+		ForkDigests:    make(Counts),
+		NextForks:      make(Counts),
+		Attnets:        make(Counts),
+		IPFamilies:     make(Counts),
+	}
+	_, err := ps.IteratePeers(ctx, "", func(data *eth2peerstore.PeerAllData) bool {
+		c.add(data)
+		return ctx.Err() == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Sorted returns the keys of the counts, highest count first, ties sorted by key.
+func (c Counts) Sorted() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if c[keys[i]] != c[keys[j]] {
+			return c[keys[i]] > c[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// JSON renders the census as indented JSON
+func (c *Census) JSON() ([]byte, error) {
+	return json.MarshalIndent(c, "", "  ")
+}
+
+// Markdown renders the census as a Markdown document, with a table per aggregate
+func (c *Census) Markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Network census\n\n")
+	fmt.Fprintf(&b, "Taken at %s, %d peers.\n", c.Time.UTC().Format(time.RFC3339), c.Peers)
+	share := func(n int) string {
+		if c.Peers == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.1f%%", float64(n)*100/float64(c.Peers))
+	}
+	table := func(title string, column string, counts Counts) {
+		fmt.Fprintf(&b, "\n## %s\n\n", title)
+		fmt.Fprintf(&b, "| %s | Peers | Share |\n", column)
+		fmt.Fprintf(&b, "|---|---:|---:|\n")
+		for _, k := range counts.Sorted() {
+			fmt.Fprintf(&b, "| %s | %d | %s |\n", k, counts[k], share(counts[k]))
+		}
+	}
+	table("Clients", "Client", c.Clients)
+
+	fmt.Fprintf(&b, "\n## Client versions\n\n")
+	fmt.Fprintf(&b, "| Client | Version | Peers | Share |\n")
+	fmt.Fprintf(&b, "|---|---|---:|---:|\n")
+	for _, client := range c.Clients.Sorted() {
+		versions := c.ClientVersions[client]
+		for _, v := range versions.Sorted() {
+			fmt.Fprintf(&b, "| %s | %s | %d | %s |\n", client, v, versions[v], share(versions[v]))
+		}
+	}
+
+	table("Fork digests", "Fork digest", c.ForkDigests)
+	table("Next fork", "Next fork version@epoch", c.NextForks)
+	table("Attestation subnets", "Subscribed attnets", c.Attnets)
+	table("IP families", "IP family", c.IPFamilies)
+	return b.String()
+}
@@ -0,0 +1,224 @@
+package census
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	gcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/protolambda/go-eth2-peerstore"
+	"github.com/protolambda/go-eth2-peerstore/agent"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+// sliceIterator iterates over the data of a fixed list of peers
+type sliceIterator []*eth2peerstore.PeerAllData
+
+func (s sliceIterator) Eth2Peers(ctx context.Context, after eth2peerstore.PeerCursor, limit int) ([]peer.ID, eth2peerstore.PeerCursor, error) {
+	ids := make([]peer.ID, 0, len(s))
+	for _, d := range s {
+		ids = append(ids, d.PeerID)
+	}
+	return ids, "", nil
+}
+
+func (s sliceIterator) IteratePeers(ctx context.Context, after eth2peerstore.PeerCursor, fn func(data *eth2peerstore.PeerAllData) bool) (eth2peerstore.PeerCursor, error) {
+	for _, d := range s {
+		if !fn(d) {
+			return eth2peerstore.PeerCursor(d.PeerID), nil
+		}
+	}
+	return "", nil
+}
+
+func ip6ENR(t *testing.T) *enode.Node {
+	t.Helper()
+	k, err := gcrypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec enr.Record
+	rec.Set(enr.IPv6(net.ParseIP("2001:db8::1")))
+	if err := enode.SignV4(&rec, k); err != nil {
+		t.Fatal(err)
+	}
+	n, err := enode.New(enode.ValidSchemes, &rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func testPeers(t *testing.T) sliceIterator {
+	digest := common.ForkDigest{0x6a, 0x95, 0xa1, 0xa9}
+	statusDigest := common.ForkDigest{0xbb, 0xa4, 0xda, 0x96}
+	fulu := common.Version{0x06, 0x00, 0x00, 0x00}
+	fuluEpoch := common.Epoch(411392)
+	farFuture := ^common.Epoch(0)
+	attnets := common.AttnetBits{0x03}
+	return sliceIterator{
+		{
+			PeerID: "a", Client: &agent.Client{Name: "Lighthouse", Version: "5.3.0"},
+			ForkDigest: &digest, NextForkVersion: &fulu, NextForkEpoch: &fuluEpoch,
+			MetaData: &common.MetaData{Attnets: common.AttnetBits{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+			Addrs:    []string{"/ip4/1.2.3.4/tcp/9000", "/ip6/2001:db8::1/tcp/9000"},
+		},
+		{
+			PeerID: "b", Client: &agent.Client{Name: "Lighthouse", Version: "5.3.0"},
+			ForkDigest: &digest, NextForkVersion: &fulu, NextForkEpoch: &farFuture,
+			// the metadata takes precedence over the ENR attnets
+			MetaData: &common.MetaData{Attnets: common.AttnetBits{0x01}}, Attnets: &attnets,
+			Addrs: []string{"/ip4/1.2.3.5/tcp/9000"},
+		},
+		{
+			PeerID: "c", Client: &agent.Client{Name: "Teku"},
+			Status:  &common.Status{ForkDigest: statusDigest},
+			Attnets: &attnets,
+			ENR:     ip6ENR(t),
+		},
+		{PeerID: "d"},
+	}
+}
+
+func TestTake(t *testing.T) {
+	now := time.Date(2025, 12, 3, 21, 49, 11, 0, time.UTC)
+	c, err := Take(context.Background(), testPeers(t), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Census{
+		Time:  now,
+		Peers: 4,
+		Clients: Counts{
+			"Lighthouse": 2, "Teku": 1, Unknown: 1,
+		},
+		ClientVersions: map[string]Counts{
+			"Lighthouse": {"5.3.0": 2},
+			"Teku":       {Unknown: 1},
+			Unknown:      {Unknown: 1},
+		},
+		ForkDigests: Counts{"0x6a95a1a9": 2, "0xbba4da96": 1, Unknown: 1},
+		NextForks:   Counts{"0x06000000@411392": 1, "none": 1, Unknown: 2},
+		Attnets:     Counts{"64": 1, "1": 1, "2": 1, Unknown: 1},
+		IPFamilies:  Counts{"dual": 1, "ip4": 1, "ip6": 1, Unknown: 1},
+	}
+	if !reflect.DeepEqual(c, expected) {
+		t.Fatalf("got census %+v, expected %+v", c, expected)
+	}
+}
+
+func TestTakeCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Take(ctx, testPeers(t), time.Now()); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context canceled error, got %v", err)
+	}
+}
+
+func TestCountsSorted(t *testing.T) {
+	c := Counts{"b": 2, "a": 2, "c": 5, "d": 1}
+	if got, expected := c.Sorted(), []string{"c", "a", "b", "d"}; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("got %v, expected %v", got, expected)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	now := time.Date(2025, 12, 3, 21, 49, 11, 0, time.UTC)
+	c, err := Take(context.Background(), testPeers(t), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := c.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"time", "peers", "clients", "client_versions", "fork_digests", "next_forks", "attnets", "ip_families"} {
+		if _, ok := fields[key]; !ok {
+			t.Fatalf("JSON has no %q field: %s", key, data)
+		}
+	}
+	var decoded Census
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&decoded, c) {
+		t.Fatalf("decoded census %+v differs from %+v", decoded, c)
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	c := &Census{
+		Time:           time.Date(2025, 12, 3, 22, 49, 11, 0, time.FixedZone("CET", 3600)),
+		Peers:          4,
+		Clients:        Counts{"Lighthouse": 3, "Teku": 1},
+		ClientVersions: map[string]Counts{"Lighthouse": {"5.3.0": 2, "5.2.1": 1}, "Teku": {Unknown: 1}},
+		ForkDigests:    Counts{"0x6a95a1a9": 4},
+		NextForks:      Counts{"none": 4},
+		Attnets:        Counts{"2": 4},
+		IPFamilies:     Counts{"ip4": 3, "ip6": 1},
+	}
+	expected := `# Network census
+
+Taken at 2025-12-03T21:49:11Z, 4 peers.
+
+## Clients
+
+| Client | Peers | Share |
+|---|---:|---:|
+| Lighthouse | 3 | 75.0% |
+| Teku | 1 | 25.0% |
+
+## Client versions
+
+| Client | Version | Peers | Share |
+|---|---|---:|---:|
+| Lighthouse | 5.3.0 | 2 | 50.0% |
+| Lighthouse | 5.2.1 | 1 | 25.0% |
+| Teku | unknown | 1 | 25.0% |
+
+## Fork digests
+
+| Fork digest | Peers | Share |
+|---|---:|---:|
+| 0x6a95a1a9 | 4 | 100.0% |
+
+## Next fork
+
+| Next fork version@epoch | Peers | Share |
+|---|---:|---:|
+| none | 4 | 100.0% |
+
+## Attestation subnets
+
+| Subscribed attnets | Peers | Share |
+|---|---:|---:|
+| 2 | 4 | 100.0% |
+
+## IP families
+
+| IP family | Peers | Share |
+|---|---:|---:|
+| ip4 | 3 | 75.0% |
+| ip6 | 1 | 25.0% |
+`
+	if got := c.Markdown(); got != expected {
+		t.Fatalf("got markdown:\n%s\nexpected:\n%s", got, expected)
+	}
+	// without peers, shares are not computed
+	empty := &Census{Time: c.Time, Clients: Counts{"Lighthouse": 0}}
+	if got := empty.Markdown(); !strings.Contains(got, "| Lighthouse | 0 | - |\n") {
+		t.Fatalf("unexpected empty census markdown:\n%s", got)
+	}
+}
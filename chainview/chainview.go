@@ -0,0 +1,335 @@
+// Package chainview groups peers by the chain they follow, as reported in their status,
+// to detect chain splits and which clients are on which branch.
+package chainview
+
+import (
+	"bytes"
+	"context"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/protolambda/go-eth2-peerstore"
+	"github.com/protolambda/go-eth2-peerstore/agent"
+	"github.com/protolambda/go-eth2-peerstore/dstee"
+	"github.com/protolambda/go-eth2-peerstore/dstee/translate"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Unknown is the client and user agent that peers are counted under if they are not known
+const Unknown = "unknown"
+
+// Counts maps a key, e.g. a client name, to a number of peers
+type Counts map[string]int
+
+type peerView struct {
+	status    *common.Status
+	userAgent string
+	enrClient string
+}
+
+func (v *peerView) client() string {
+	if v.userAgent != "" {
+		return agent.Parse(v.userAgent).Name
+	}
+	if v.enrClient != "" {
+		return agent.Parse(v.enrClient).Name
+	}
+	return Unknown
+}
+
+func (v *peerView) agent() string {
+	if v.userAgent != "" {
+		return v.userAgent
+	}
+	return Unknown
+}
+
+// Analyzer tracks the status of peers, to group them by finalized checkpoint and head.
+// It can load the stored peers offline, see Load, and is a dstee.Tee to follow status updates incrementally.
+type Analyzer struct {
+	// Accept is optional, to only track statuses with an accepted fork digest, e.g. those of a single network.
+	Accept func(digest common.ForkDigest) bool
+
+	mu    sync.Mutex
+	peers map[peer.ID]*peerView
+}
+
+var _ dstee.Tee = (*Analyzer)(nil)
+
+func NewAnalyzer() *Analyzer {
+	return &Analyzer{peers: make(map[peer.ID]*peerView)}
+}
+
+func (a *Analyzer) view(id peer.ID) *peerView {
+	v, ok := a.peers[id]
+	if !ok {
+		v = &peerView{}
+		a.peers[id] = v
+	}
+	return v
+}
+
+func (a *Analyzer) gc(id peer.ID) {
+	if v, ok := a.peers[id]; ok && v.status == nil && v.userAgent == "" && v.enrClient == "" {
+		delete(a.peers, id)
+	}
+}
+
+func (a *Analyzer) setStatus(id peer.ID, status *common.Status) {
+	if status != nil && a.Accept != nil && !a.Accept(status.ForkDigest) {
+		status = nil
+	}
+	a.view(id).status = status
+	a.gc(id)
+}
+
+// UpdateStatus sets the status of the peer, or removes it if nil.
+func (a *Analyzer) UpdateStatus(id peer.ID, status *common.Status) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.setStatus(id, status)
+}
+
+// UpdateUserAgent sets the user agent of the peer, to attribute it to a client.
+func (a *Analyzer) UpdateUserAgent(id peer.ID, userAgent string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.view(id).userAgent = userAgent
+	a.gc(id)
+}
+
+// Remove stops tracking the peer
+func (a *Analyzer) Remove(id peer.ID) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.peers, id)
+}
+
+// Load tracks the status and client of all eth2 peers of the peerstore.
+func (a *Analyzer) Load(ctx context.Context, ps eth2peerstore.PeerIterator) error {
+	_, err := ps.IteratePeers(ctx, "", func(data *eth2peerstore.PeerAllData) bool {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		v := a.view(data.PeerID)
+		v.userAgent = data.UserAgent
+		if data.ENRClient != nil {
+			v.enrClient = data.ENRClient.String()
+		}
+		a.setStatus(data.PeerID, data.Status)
+		return ctx.Err() == nil
+	})
+	if err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+func (a *Analyzer) String() string {
+	return "Chain view analyzer"
+}
+
+func (a *Analyzer) onPut(key ds.Key, value []byte) {
+	id, p, err := translate.KeyToPath(key)
+	if err != nil {
+		return
+	}
+	switch p {
+	case "eth2/status", "eth2/enr", "user_agent":
+	default:
+		return
+	}
+	_, entry, err := translate.ItemToEntry(key, value)
+	if err != nil {
+		return
+	}
+	switch p {
+	case "eth2/status":
+		if entry.Eth2 != nil && entry.Eth2.Status != nil {
+			a.setStatus(id, &entry.Eth2.Status.Status)
+		}
+	case "eth2/enr":
+		v := a.view(id)
+		v.enrClient = ""
+		if entry.Eth2 != nil && entry.Eth2.ENR != nil && entry.Eth2.ENR.Client != nil {
+			v.enrClient = entry.Eth2.ENR.Client.String()
+		}
+		a.gc(id)
+	case "user_agent":
+		a.view(id).userAgent = entry.UserAgent
+		a.gc(id)
+	}
+}
+
+func (a *Analyzer) onDelete(key ds.Key) {
+	id, p, err := translate.KeyToPath(key)
+	if err != nil {
+		return
+	}
+	if _, ok := a.peers[id]; !ok {
+		return
+	}
+	switch p {
+	case "eth2/status":
+		a.peers[id].status = nil
+	case "eth2/enr":
+		a.peers[id].enrClient = ""
+	case "user_agent":
+		a.peers[id].userAgent = ""
+	}
+	a.gc(id)
+}
+
+func (a *Analyzer) OnPut(key ds.Key, value []byte) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.onPut(key, value)
+}
+
+func (a *Analyzer) OnDelete(key ds.Key) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.onDelete(key)
+}
+
+func (a *Analyzer) OnBatch(puts []dstee.BatchItem, deletes []ds.Key) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, p := range puts {
+		a.onPut(p.Key, p.Value)
+	}
+	for _, d := range deletes {
+		a.onDelete(d)
+	}
+}
+
+// Head is a group of peers with the same head
+type Head struct {
+	Root common.Root `json:"root"`
+	Slot common.Slot `json:"slot"`
+	// Number of peers with this head, by client name, and by user agent
+	Peers      int    `json:"peers"`
+	Clients    Counts `json:"clients"`
+	UserAgents Counts `json:"user_agents"`
+}
+
+// Branch is a group of peers with the same finalized checkpoint
+type Branch struct {
+	Finalized common.Checkpoint `json:"finalized"`
+	// Number of peers with this finalized checkpoint, by client name, by user agent, and by status fork digest
+	Peers       int    `json:"peers"`
+	Clients     Counts `json:"clients"`
+	UserAgents  Counts `json:"user_agents"`
+	ForkDigests Counts `json:"fork_digests"`
+	// Heads of the peers, most peers first
+	Heads []*Head `json:"heads"`
+}
+
+// Conflict is a set of competing finalized checkpoints: different roots finalized at the same epoch.
+// Either a chain split, or peers that follow a long non-finality fork.
+type Conflict struct {
+	Epoch common.Epoch `json:"epoch"`
+	// Number of peers per finalized root
+	Roots Counts `json:"roots"`
+}
+
+// Report is the chain view of all tracked peers with a status
+type Report struct {
+	Time time.Time `json:"time"`
+	// Number of peers with a status
+	Peers int `json:"peers"`
+	// Branches by finalized checkpoint, most peers first
+	Branches []*Branch `json:"branches"`
+	// Competing finalized checkpoints, latest epoch first
+	Conflicts []*Conflict `json:"conflicts"`
+}
+
+// Report groups the tracked peers by finalized checkpoint and head, and detects competing finalized checkpoints.
+// The report is timestamped with the given time.
+func (a *Analyzer) Report(now time.Time) *Report {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	r := &Report{Time: now, Branches: []*Branch{}, Conflicts: []*Conflict{}}
+	branches := make(map[common.Checkpoint]*Branch)
+	heads := make(map[common.Checkpoint]map[common.Root]*Head)
+	for _, v := range a.peers {
+		if v.status == nil {
+			continue
+		}
+		r.Peers += 1
+		client, userAgent := v.client(), v.agent()
+		cp := common.Checkpoint{Epoch: v.status.FinalizedEpoch, Root: v.status.FinalizedRoot}
+		b, ok := branches[cp]
+		if !ok {
+			b = &Branch{Finalized: cp, Clients: make(Counts), UserAgents: make(Counts), ForkDigests: make(Counts)}
+			branches[cp] = b
+			heads[cp] = make(map[common.Root]*Head)
+		}
+		b.Peers += 1
+		b.Clients[client] += 1
+		b.UserAgents[userAgent] += 1
+		b.ForkDigests[v.status.ForkDigest.String()] += 1
+		h, ok := heads[cp][v.status.HeadRoot]
+		if !ok {
+			h = &Head{Root: v.status.HeadRoot, Clients: make(Counts), UserAgents: make(Counts)}
+			heads[cp][v.status.HeadRoot] = h
+			b.Heads = append(b.Heads, h)
+		}
+		// peers may report different slots for the same head root, if the head is an empty slot
+		if v.status.HeadSlot > h.Slot {
+			h.Slot = v.status.HeadSlot
+		}
+		h.Peers += 1
+		h.Clients[client] += 1
+		h.UserAgents[userAgent] += 1
+	}
+	byEpoch := make(map[common.Epoch][]*Branch)
+	for cp, b := range branches {
+		sort.Slice(b.Heads, func(i, j int) bool {
+			if b.Heads[i].Peers != b.Heads[j].Peers {
+				return b.Heads[i].Peers > b.Heads[j].Peers
+			}
+			if b.Heads[i].Slot != b.Heads[j].Slot {
+				return b.Heads[i].Slot > b.Heads[j].Slot
+			}
+			return bytes.Compare(b.Heads[i].Root[:], b.Heads[j].Root[:]) < 0
+		})
+		r.Branches = append(r.Branches, b)
+		byEpoch[cp.Epoch] = append(byEpoch[cp.Epoch], b)
+	}
+	sort.Slice(r.Branches, func(i, j int) bool {
+		if r.Branches[i].Peers != r.Branches[j].Peers {
+			return r.Branches[i].Peers > r.Branches[j].Peers
+		}
+		if r.Branches[i].Finalized.Epoch != r.Branches[j].Finalized.Epoch {
+			return r.Branches[i].Finalized.Epoch > r.Branches[j].Finalized.Epoch
+		}
+		return bytes.Compare(r.Branches[i].Finalized.Root[:], r.Branches[j].Finalized.Root[:]) < 0
+	})
+	for epoch, bs := range byEpoch {
+		if len(bs) < 2 {
+			continue
+		}
+		c := &Conflict{Epoch: epoch, Roots: make(Counts)}
+		for _, b := range bs {
+			c.Roots[b.Finalized.Root.String()] = b.Peers
+		}
+		r.Conflicts = append(r.Conflicts, c)
+	}
+	sort.Slice(r.Conflicts, func(i, j int) bool {
+		return r.Conflicts[i].Epoch > r.Conflicts[j].Epoch
+	})
+	return r
+}
+
+// Branch returns the branch of the peer: the finalized checkpoint of its status, or false if the status is unknown.
+func (a *Analyzer) Branch(id peer.ID) (common.Checkpoint, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	v, ok := a.peers[id]
+	if !ok || v.status == nil {
+		return common.Checkpoint{}, false
+	}
+	return common.Checkpoint{Epoch: v.status.FinalizedEpoch, Root: v.status.FinalizedRoot}, true
+}
@@ -0,0 +1,236 @@
+package chainview
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	gcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-peerstore/pstoreds"
+	"github.com/protolambda/go-eth2-peerstore"
+	"github.com/protolambda/go-eth2-peerstore/addrutil"
+	"github.com/protolambda/go-eth2-peerstore/dstrack"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+const (
+	lighthouseUA = "Lighthouse/v5.3.0-d6ba8c3/x86_64-linux"
+	tekuUA       = "teku/teku/v24.10.3/linux-x86_64/-eclipseadoptium-openjdk64bitservervm-java-21"
+)
+
+// newTestPeerstore returns a peerstore with the analyzer as tee
+func newTestPeerstore(t *testing.T, a *Analyzer) eth2peerstore.ExtendedPeerstore {
+	t.Helper()
+	ps, err := dstrack.NewExtendedPeerstore(context.Background(), dssync.MutexWrap(ds.NewMapDatastore()), pstoreds.DefaultOpts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = ps.Close()
+	})
+	ps.AddTee(a)
+	return ps
+}
+
+type testPeer struct {
+	id   peer.ID
+	node *enode.Node
+}
+
+// newTestPeer creates a peer with an ENR, with the EIP-7636 client entry if client is not empty
+func newTestPeer(t *testing.T, client string) testPeer {
+	t.Helper()
+	k, err := gcrypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec enr.Record
+	if client != "" {
+		rec.Set(addrutil.ClientENREntry{Name: client, Version: "1.0.0"})
+	}
+	if err := enode.SignV4(&rec, k); err != nil {
+		t.Fatal(err)
+	}
+	n, err := enode.New(enode.ValidSchemes, &rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testPeer{id: addrutil.PeerIDFromPubkey(&k.PublicKey), node: n}
+}
+
+func status(finalizedEpoch common.Epoch, finalizedRoot byte, headRoot byte, headSlot common.Slot) common.Status {
+	return common.Status{
+		ForkDigest:     common.ForkDigest{0x6a, 0x95, 0xa1, 0xa9},
+		FinalizedEpoch: finalizedEpoch,
+		FinalizedRoot:  common.Root{finalizedRoot},
+		HeadRoot:       common.Root{headRoot},
+		HeadSlot:       headSlot,
+	}
+}
+
+func TestReport(t *testing.T) {
+	ctx := context.Background()
+	a := NewAnalyzer()
+	ps := newTestPeerstore(t, a)
+	peers := []testPeer{newTestPeer(t, ""), newTestPeer(t, ""), newTestPeer(t, ""), newTestPeer(t, "Nimbus")}
+	userAgents := []string{lighthouseUA, lighthouseUA, tekuUA, ""}
+	statuses := []common.Status{
+		status(10, 1, 0xa, 400),
+		// same head root, reported with an earlier slot
+		status(10, 1, 0xa, 399),
+		// competing finalized checkpoint at the same epoch
+		status(10, 2, 0xb, 401),
+		status(9, 3, 0xc, 390),
+	}
+	for i, p := range peers {
+		if userAgents[i] != "" {
+			if err := ps.Put(p.id, "AgentVersion", userAgents[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := ps.UpdateENRMaybe(ctx, p.id, p.node); err != nil {
+			t.Fatal(err)
+		}
+		if err := ps.RegisterStatus(ctx, p.id, statuses[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	digest := statuses[0].ForkDigest.String()
+	now := time.Date(2025, 12, 3, 21, 49, 11, 0, time.UTC)
+	expected := &Report{
+		Time:  now,
+		Peers: 4,
+		Branches: []*Branch{
+			{
+				Finalized:   common.Checkpoint{Epoch: 10, Root: common.Root{1}},
+				Peers:       2,
+				Clients:     Counts{"Lighthouse": 2},
+				UserAgents:  Counts{lighthouseUA: 2},
+				ForkDigests: Counts{digest: 2},
+				Heads: []*Head{{Root: common.Root{0xa}, Slot: 400, Peers: 2,
+					Clients: Counts{"Lighthouse": 2}, UserAgents: Counts{lighthouseUA: 2}}},
+			},
+			{
+				Finalized:   common.Checkpoint{Epoch: 10, Root: common.Root{2}},
+				Peers:       1,
+				Clients:     Counts{"Teku": 1},
+				UserAgents:  Counts{tekuUA: 1},
+				ForkDigests: Counts{digest: 1},
+				Heads: []*Head{{Root: common.Root{0xb}, Slot: 401, Peers: 1,
+					Clients: Counts{"Teku": 1}, UserAgents: Counts{tekuUA: 1}}},
+			},
+			{
+				// attributed to a client by its ENR
+				Finalized:   common.Checkpoint{Epoch: 9, Root: common.Root{3}},
+				Peers:       1,
+				Clients:     Counts{"Nimbus": 1},
+				UserAgents:  Counts{Unknown: 1},
+				ForkDigests: Counts{digest: 1},
+				Heads: []*Head{{Root: common.Root{0xc}, Slot: 390, Peers: 1,
+					Clients: Counts{"Nimbus": 1}, UserAgents: Counts{Unknown: 1}}},
+			},
+		},
+		Conflicts: []*Conflict{
+			{Epoch: 10, Roots: Counts{common.Root{1}.String(): 2, common.Root{2}.String(): 1}},
+		},
+	}
+	if got := a.Report(now); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("got report %+v, expected %+v", got, expected)
+	}
+
+	// the analyzer loaded from the peerstore reports the same as the one following the updates
+	loaded := NewAnalyzer()
+	if err := loaded.Load(ctx, ps); err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.Report(now); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("loaded analyzer reports %+v, expected %+v", got, expected)
+	}
+
+	// removing the status of the competing peer resolves the conflict
+	if err := ps.RemoveStatus(ctx, peers[2].id); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := a.Branch(peers[2].id); ok {
+		t.Fatal("peer without status has a branch")
+	}
+	r := a.Report(now)
+	if r.Peers != 3 || len(r.Branches) != 2 || len(r.Conflicts) != 0 {
+		t.Fatalf("unexpected report after status removal: %+v", r)
+	}
+
+	// a status update moves the peer to another branch
+	if err := ps.RegisterStatus(ctx, peers[3].id, status(11, 4, 0xd, 420)); err != nil {
+		t.Fatal(err)
+	}
+	if cp, ok := a.Branch(peers[3].id); !ok || cp != (common.Checkpoint{Epoch: 11, Root: common.Root{4}}) {
+		t.Fatalf("got branch %v, %v, expected the updated checkpoint", cp, ok)
+	}
+	r = a.Report(now)
+	if len(r.Branches) != 2 || r.Branches[1].Finalized.Epoch != 11 || r.Branches[1].Clients["Nimbus"] != 1 {
+		t.Fatalf("unexpected branches after status update: %+v", r.Branches)
+	}
+
+	// removing all peer data stops tracking the peer
+	if err := ps.RemovePeerData(ctx, peers[0].id); err != nil {
+		t.Fatal(err)
+	}
+	r = a.Report(now)
+	// single peer branches are sorted by latest finalized epoch first
+	if r.Peers != 2 || len(r.Branches) != 2 || r.Branches[1].Peers != 1 || r.Branches[1].Heads[0].Slot != 399 {
+		t.Fatalf("unexpected report after peer removal: %+v", r)
+	}
+}
+
+func TestAccept(t *testing.T) {
+	ctx := context.Background()
+	accepted := common.ForkDigest{0x6a, 0x95, 0xa1, 0xa9}
+	a := NewAnalyzer()
+	a.Accept = func(digest common.ForkDigest) bool {
+		return digest == accepted
+	}
+	ps := newTestPeerstore(t, a)
+	onNetwork, foreign := newTestPeer(t, ""), newTestPeer(t, "")
+	if err := ps.RegisterStatus(ctx, onNetwork.id, status(10, 1, 0xa, 400)); err != nil {
+		t.Fatal(err)
+	}
+	other := status(10, 2, 0xb, 400)
+	other.ForkDigest = common.ForkDigest{0xde, 0xad}
+	if err := ps.RegisterStatus(ctx, foreign.id, other); err != nil {
+		t.Fatal(err)
+	}
+	r := a.Report(time.Time{})
+	if r.Peers != 1 || len(r.Branches) != 1 || len(r.Conflicts) != 0 {
+		t.Fatalf("foreign status was tracked: %+v", r)
+	}
+	if _, ok := a.Branch(foreign.id); ok {
+		t.Fatal("foreign peer has a branch")
+	}
+	// a status update to a foreign digest stops tracking the status of the peer
+	if err := ps.RegisterStatus(ctx, onNetwork.id, other); err != nil {
+		t.Fatal(err)
+	}
+	if r := a.Report(time.Time{}); r.Peers != 0 {
+		t.Fatalf("peer with foreign status is still tracked: %+v", r)
+	}
+}
+
+func TestEmptyReport(t *testing.T) {
+	now := time.Date(2025, 12, 3, 21, 49, 11, 0, time.UTC)
+	a := NewAnalyzer()
+	a.UpdateUserAgent("no status", lighthouseUA)
+	expected := &Report{Time: now, Branches: []*Branch{}, Conflicts: []*Conflict{}}
+	if got := a.Report(now); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("got report %+v, expected %+v", got, expected)
+	}
+	a.UpdateUserAgent("no status", "")
+	if len(a.peers) != 0 {
+		t.Fatalf("peer without data is still tracked: %v", a.peers)
+	}
+}
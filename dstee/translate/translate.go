@@ -16,8 +16,8 @@ import (
 	pstore_pb "github.com/libp2p/go-libp2p-peerstore/pb"
 	"github.com/multiformats/go-base32"
 	"github.com/protolambda/go-eth2-peerstore/addrutil"
+	"github.com/protolambda/go-eth2-peerstore/types"
 	"github.com/protolambda/zrnt/eth2/beacon/common"
-	"github.com/protolambda/ztyp/codec"
 	"net"
 	"sort"
 	"strconv"
@@ -29,9 +29,12 @@ Peerstore layout, partially due to libp2p legacy:
 	/peers
 		- /eth2
 		 	- /<peer-id>
-				- /metadata           <- ssz encoded
+				- /metadata           <- version byte + ssz encoded (untagged ssz if stored before versioning)
 				- /metadata_claim     <- ssz encoded
-				- /status             <- ssz encoded
+				- /status             <- version byte + ssz encoded (untagged ssz if stored before versioning)
+				- /status_relevance   <- single byte label of the status, see eth2peerstore.Relevance
+				- /status_history     <- bounded history of tagged statuses, oldest first, see dstrack
+				- /enr_history        <- bounded history of ENRs in text representation, oldest first, see dstrack
 				- /enr                <- stored in raw base64 enr presentation. Then expanded into subfields when reading:
 				  - /raw              <- base64 enr representation
                   - /other            <- map of unrecognized key/value pairs. Values encoded as hex bytes by us.
@@ -41,7 +44,11 @@ Peerstore layout, partially due to libp2p legacy:
                   - /ip               <- IP (v4 or v6)
                   - /udp              <- UDP port
                   - /tcp              <- TCP port
+				- /first_seen         <- little-endian uint64 unix milliseconds, when any eth2 data was first stored
+				- /updated            <- little-endian uint64 unix milliseconds, when any eth2 data was last updated
+				- /<data>_updated     <- little-endian uint64 unix milliseconds, when the specific data was last updated
 
+		- /eth2-idx                   <- secondary indexes of the eth2 data, not translated. See dstrack.
 		- /addrs
 			- /<peer-id>              <- no subkeys. Encoded as `pstore_pb.AddrBookRecord` protobuf
 		- /metadata
@@ -62,10 +69,16 @@ type ENRData struct {
 	Other    map[string]string  `json:"other,omitempty"`
 	Eth2Data *common.Eth2Data   `json:"eth2_data,omitempty"`
 	Attnets  *common.AttnetBits `json:"attnets,omitempty"`
+	Syncnets *types.SyncnetBits `json:"syncnets,omitempty"`
 	Seq      uint64             `json:"seq,omitempty"`
 	IP       net.IP             `json:"ip,omitempty"`
 	TCP      uint16             `json:"tcp,omitempty"`
 	UDP      uint16             `json:"udp,omitempty"`
+	// PeerDAS custody group count
+	CustodyGroupCount *uint64            `json:"cgc,omitempty"`
+	NextForkDigest    *common.ForkDigest `json:"nfd,omitempty"`
+	// EIP-7636 client identification
+	Client *addrutil.ClientENREntry `json:"client,omitempty"`
 }
 
 type AddrBookRecord struct {
@@ -92,10 +105,31 @@ type AddrBookRecord_CertifiedRecord struct {
 }
 
 type Eth2Data struct {
-	Metadata      *common.MetaData `json:"metadata,omitempty"`
-	MetadataClaim common.SeqNr     `json:"metadata_claim,omitempty"`
-	Status        *common.Status   `json:"status,omitempty"`
-	ENR           *ENRData         `json:"enr,omitempty"`
+	Metadata      *types.VersionedMetaData `json:"metadata,omitempty"`
+	MetadataClaim common.SeqNr             `json:"metadata_claim,omitempty"`
+	Status        *types.VersionedStatus   `json:"status,omitempty"`
+	ENR           *ENRData                 `json:"enr,omitempty"`
+	// Unix milliseconds of update events, keyed by their datastore key name, e.g. "status_updated"
+	Times map[string]uint64 `json:"times_ms,omitempty"`
+	// StatusRelevance is the numeric label of the status, see eth2peerstore.Relevance
+	StatusRelevance *uint8 `json:"status_relevance,omitempty"`
+	// StatusHistory is the complete bounded status history, oldest first
+	StatusHistory []StatusHistoryEntry `json:"status_history,omitempty"`
+	// ENRHistory is the complete bounded ENR history, oldest first
+	ENRHistory []ENRHistoryEntry `json:"enr_history,omitempty"`
+}
+
+type ENRHistoryEntry struct {
+	// Unix milliseconds of when the ENR was registered
+	TimeMs uint64 `json:"time_ms"`
+	// Text representation of the ENR
+	Raw string `json:"raw"`
+}
+
+type StatusHistoryEntry struct {
+	// Unix milliseconds of when the status was registered
+	TimeMs uint64                 `json:"time_ms"`
+	Status *types.VersionedStatus `json:"status"`
 }
 
 type PartialPeerstoreEntry struct {
@@ -122,15 +156,34 @@ func (p *PartialPeerstoreEntry) Merge(other *PartialPeerstoreEntry) {
 			if other.Eth2.Status != nil {
 				p.Eth2.Status = other.Eth2.Status
 			}
+			if other.Eth2.StatusRelevance != nil {
+				p.Eth2.StatusRelevance = other.Eth2.StatusRelevance
+			}
+			if other.Eth2.StatusHistory != nil {
+				p.Eth2.StatusHistory = other.Eth2.StatusHistory
+			}
+			if other.Eth2.ENRHistory != nil {
+				p.Eth2.ENRHistory = other.Eth2.ENRHistory
+			}
 			if other.Eth2.MetadataClaim > p.Eth2.MetadataClaim {
 				p.Eth2.MetadataClaim = other.Eth2.MetadataClaim
 			}
 			if other.Eth2.Metadata != nil {
 				// only ever update metadata forwards
-				if p.Eth2.Metadata == nil || other.Eth2.Metadata.SeqNumber > p.Eth2.Metadata.SeqNumber {
+				if p.Eth2.Metadata == nil || other.Eth2.Metadata.SeqNumber > p.Eth2.Metadata.SeqNumber ||
+					(other.Eth2.Metadata.SeqNumber == p.Eth2.Metadata.SeqNumber && other.Eth2.Metadata.Version > p.Eth2.Metadata.Version) {
 					p.Eth2.Metadata = other.Eth2.Metadata
 				}
 			}
+			for k, v := range other.Eth2.Times {
+				if p.Eth2.Times == nil {
+					p.Eth2.Times = make(map[string]uint64)
+				}
+				// only ever update times forwards
+				if v > p.Eth2.Times[k] {
+					p.Eth2.Times[k] = v
+				}
+			}
 		}
 	}
 	if other.AddrRecords != nil {
@@ -173,6 +226,18 @@ func (p *PartialPeerstoreEntry) ToCSV(prefixFields ...string) (out [][]string) {
 			if p.Eth2.ENR.Attnets != nil {
 				entry("eth2/enr/attnets", p.Eth2.ENR.Attnets.String())
 			}
+			if p.Eth2.ENR.Client != nil {
+				entry("eth2/enr/client", p.Eth2.ENR.Client.String())
+			}
+			if p.Eth2.ENR.Syncnets != nil {
+				entry("eth2/enr/syncnets", p.Eth2.ENR.Syncnets.String())
+			}
+			if p.Eth2.ENR.CustodyGroupCount != nil {
+				entry("eth2/enr/cgc", strconv.FormatUint(*p.Eth2.ENR.CustodyGroupCount, 10))
+			}
+			if p.Eth2.ENR.NextForkDigest != nil {
+				entry("eth2/enr/nfd", p.Eth2.ENR.NextForkDigest.String())
+			}
 			for k, v := range p.Eth2.ENR.Other {
 				entry("eth2/enr/"+k, v)
 			}
@@ -186,6 +251,13 @@ func (p *PartialPeerstoreEntry) ToCSV(prefixFields ...string) (out [][]string) {
 			entry("eth2/status/head_root", p.Eth2.Status.HeadRoot.String())
 			entry("eth2/status/head_slot", strconv.FormatUint(uint64(p.Eth2.Status.HeadSlot), 10))
 			entry("eth2/status/fork_digest", p.Eth2.Status.ForkDigest.String())
+			entry("eth2/status/version", strconv.FormatUint(uint64(p.Eth2.Status.Version), 10))
+			if p.Eth2.Status.EarliestAvailableSlot != nil {
+				entry("eth2/status/earliest_available_slot", strconv.FormatUint(uint64(*p.Eth2.Status.EarliestAvailableSlot), 10))
+			}
+		}
+		if p.Eth2.StatusRelevance != nil {
+			entry("eth2/status_relevance", strconv.FormatUint(uint64(*p.Eth2.StatusRelevance), 10))
 		}
 		if p.Eth2.MetadataClaim > p.Eth2.MetadataClaim {
 			entry("eth2/metadata_claim", strconv.FormatUint(uint64(p.Eth2.MetadataClaim), 10))
@@ -193,6 +265,16 @@ func (p *PartialPeerstoreEntry) ToCSV(prefixFields ...string) (out [][]string) {
 		if p.Eth2.Metadata != nil {
 			entry("eth2/metadata/seq_number", strconv.FormatUint(uint64(p.Eth2.Metadata.SeqNumber), 10))
 			entry("eth2/metadata/attnets", p.Eth2.Metadata.Attnets.String())
+			entry("eth2/metadata/version", strconv.FormatUint(uint64(p.Eth2.Metadata.Version), 10))
+			if p.Eth2.Metadata.Syncnets != nil {
+				entry("eth2/metadata/syncnets", p.Eth2.Metadata.Syncnets.String())
+			}
+			if p.Eth2.Metadata.CustodyGroupCount != nil {
+				entry("eth2/metadata/custody_group_count", strconv.FormatUint(uint64(*p.Eth2.Metadata.CustodyGroupCount), 10))
+			}
+		}
+		for k, v := range p.Eth2.Times {
+			entry("eth2/times/"+k, strconv.FormatUint(v, 10))
 		}
 	}
 	if p.AddrRecords != nil {
@@ -271,8 +353,16 @@ func KeyToPath(k ds.Key) (id peer.ID, p string, err error) {
 				p = "eth2/metadata_claim"
 			case "status":
 				p = "eth2/status"
+			case "status_relevance":
+				p = "eth2/status_relevance"
+			case "status_history":
+				p = "eth2/status_history"
+			case "enr_history":
+				p = "eth2/enr_history"
 			case "enr":
 				p = "eth2/enr"
+			case "first_seen", "updated", "status_updated", "metadata_updated", "metadata_claim_updated", "enr_updated":
+				p = "eth2/times/" + parts[3]
 			default:
 				err = fmt.Errorf("%w key: %s", UnknownKey, k)
 			}
@@ -298,6 +388,8 @@ func KeyToPath(k ds.Key) (id peer.ID, p string, err error) {
 			default:
 				err = fmt.Errorf("%w key: %s", UnknownKey, k)
 			}
+		default:
+			err = fmt.Errorf("%w key: %s", UnknownKey, k)
 		}
 	default:
 		err = fmt.Errorf("%w key: %s", UnknownKey, k)
@@ -322,9 +414,8 @@ func ItemToEntry(k ds.Key, v []byte) (id peer.ID, out PartialPeerstoreEntry, err
 			out.Eth2 = &Eth2Data{}
 			switch parts[3] {
 			case "metadata":
-				var md common.MetaData
-				if e := md.Deserialize(codec.NewDecodingReader(bytes.NewReader(v), uint64(len(v)))); e == nil {
-					out.Eth2.Metadata = &md
+				if md, e := types.DecodeTaggedMetaData(v); e == nil {
+					out.Eth2.Metadata = md
 				} else {
 					err = fmt.Errorf("bad metadata in peerstore: %v", e)
 					return
@@ -337,13 +428,45 @@ func ItemToEntry(k ds.Key, v []byte) (id peer.ID, out PartialPeerstoreEntry, err
 					return
 				}
 			case "status":
-				var st common.Status
-				if e := st.Deserialize(codec.NewDecodingReader(bytes.NewReader(v), uint64(len(v)))); e == nil {
-					out.Eth2.Status = &st
+				if st, e := types.DecodeTaggedStatus(v); e == nil {
+					out.Eth2.Status = st
 				} else {
 					err = fmt.Errorf("bad status in peerstore: %v", e)
 					return
 				}
+			case "status_relevance":
+				if len(v) == 1 {
+					r := v[0]
+					out.Eth2.StatusRelevance = &r
+				} else {
+					err = fmt.Errorf("bad status_relevance in peerstore, wrong length: relevance bytes: %x", v)
+					return
+				}
+			case "status_history":
+				items, e := types.DecodeHistory(v)
+				if e != nil {
+					err = fmt.Errorf("bad status_history in peerstore: %v", e)
+					return
+				}
+				out.Eth2.StatusHistory = make([]StatusHistoryEntry, 0, len(items))
+				for _, item := range items {
+					st, e := types.DecodeTaggedStatus(item.Data)
+					if e != nil {
+						err = fmt.Errorf("bad status in status_history in peerstore: %v", e)
+						return
+					}
+					out.Eth2.StatusHistory = append(out.Eth2.StatusHistory, StatusHistoryEntry{TimeMs: item.TimeMs, Status: st})
+				}
+			case "enr_history":
+				items, e := types.DecodeHistory(v)
+				if e != nil {
+					err = fmt.Errorf("bad enr_history in peerstore: %v", e)
+					return
+				}
+				out.Eth2.ENRHistory = make([]ENRHistoryEntry, 0, len(items))
+				for _, item := range items {
+					out.Eth2.ENRHistory = append(out.Eth2.ENRHistory, ENRHistoryEntry{TimeMs: item.TimeMs, Raw: string(item.Data)})
+				}
 			case "enr":
 				out.Eth2.ENR = &ENRData{}
 				out.Eth2.ENR.Raw = string(v)
@@ -357,6 +480,18 @@ func ItemToEntry(k ds.Key, v []byte) (id peer.ID, out PartialPeerstoreEntry, err
 						if attnets, ok, err := addrutil.ParseEnrAttnets(n); err != nil && ok {
 							out.Eth2.ENR.Attnets = attnets
 						}
+						if client, ok, err := addrutil.ParseEnrClient(n); err == nil && ok {
+							out.Eth2.ENR.Client = client
+						}
+						if syncnets, ok, err := addrutil.ParseEnrSyncnets(n); err == nil && ok {
+							out.Eth2.ENR.Syncnets = syncnets
+						}
+						if cgc, ok, err := addrutil.ParseEnrCustodyGroupCount(n); err == nil && ok {
+							out.Eth2.ENR.CustodyGroupCount = &cgc
+						}
+						if nfd, ok, err := addrutil.ParseEnrNextForkDigest(n); err == nil && ok {
+							out.Eth2.ENR.NextForkDigest = nfd
+						}
 						out.Eth2.ENR.Seq = n.Seq()
 						out.Eth2.ENR.IP = n.IP()
 						out.Eth2.ENR.TCP = uint16(n.TCP())
@@ -379,7 +514,7 @@ func ItemToEntry(k ds.Key, v []byte) (id peer.ID, out PartialPeerstoreEntry, err
 							// if these cannot be parsed, then fine, add the raw form on failure (see above)
 							// Otherwise, don't duplicat the data.
 							if key == "eth2" || key == "attnets" || key == "ip" ||
-								key == "ip6" || key == "udp" || key == "tcp" {
+								key == "ip6" || key == "udp" || key == "tcp" || key == "syncnets" || key == "client" || key == "cgc" || key == "nfd" {
 								continue
 							}
 							enrKV[key] = getValueStr()
@@ -387,6 +522,13 @@ func ItemToEntry(k ds.Key, v []byte) (id peer.ID, out PartialPeerstoreEntry, err
 					}
 					out.Eth2.ENR.Other = enrKV
 				}
+			case "first_seen", "updated", "status_updated", "metadata_updated", "metadata_claim_updated", "enr_updated":
+				if len(v) == 8 {
+					out.Eth2.Times = map[string]uint64{parts[3]: binary.LittleEndian.Uint64(v)}
+				} else {
+					err = fmt.Errorf("bad %s time in peerstore, wrong length: time bytes: %x", parts[3], v)
+					return
+				}
 			default:
 				err = fmt.Errorf("%w key: %s", UnknownKey, k)
 			}
@@ -442,6 +584,8 @@ func ItemToEntry(k ds.Key, v []byte) (id peer.ID, out PartialPeerstoreEntry, err
 			default:
 				err = fmt.Errorf("%w key: %s", UnknownKey, k)
 			}
+		default:
+			err = fmt.Errorf("%w key: %s", UnknownKey, k)
 		}
 	default:
 		err = fmt.Errorf("%w key: %s", UnknownKey, k)
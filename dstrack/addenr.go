@@ -0,0 +1,149 @@
+package dstrack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	ds "github.com/ipfs/go-datastore"
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/protolambda/go-eth2-peerstore/addrutil"
+	"time"
+)
+
+// ErrENRAddrs is returned by AddENR when the address book did not take the addresses of the ENR
+var ErrENRAddrs = errors.New("failed to add ENR addresses")
+
+// AddENR registers a discovered node: the peer ID and pubkey are derived from the ENR,
+// the ENR is stored if it has a higher seq nr, and the pubkey is added to the key book.
+// The TCP and QUIC addresses of the ENR are added to the address book with the given TTL,
+// unless the ENR is older than the one already known.
+//
+// The key and address books cache their records, so they cannot be written in the same batch as the ENR.
+// Instead, the pubkey is added first, since it belongs to the peer ID regardless of the ENR,
+// and the stored ENR is reverted if the address book does not take the addresses.
+func (ep *dsExtendedPeerstore) AddENR(ctx context.Context, n *enode.Node, ttl time.Duration) (id peer.ID, updated bool, err error) {
+	pub := n.Pubkey()
+	if pub == nil {
+		return "", false, ErrENRNoPubkey
+	}
+	id = addrutil.PeerIDFromPubkey(pub)
+	if !ep.dsENRBook.lenient {
+		if err := verifyEnr(id, n); err != nil {
+			return id, false, err
+		}
+	}
+	addrs, err := addrutil.EnodeToMultiAddrs(n, addrutil.MultiAddrOptions{})
+	if err != nil {
+		return id, false, fmt.Errorf("failed to convert ENR to multiaddrs: %w", err)
+	}
+	if err := ep.AddPubKey(id, (*ic.Secp256k1PublicKey)(pub)); err != nil {
+		return id, false, fmt.Errorf("failed to add pubkey of peer %s: %w", id.Pretty(), err)
+	}
+
+	ep.dsENRBook.updateLock.Lock()
+	defer ep.dsENRBook.updateLock.Unlock()
+	old, err := ep.loadEnr(ctx, id)
+	updated = err != nil || old.Seq() < n.Seq()
+	if !updated && old.Seq() > n.Seq() {
+		return id, false, nil
+	}
+	undo := newUndoWrite(ep.store)
+	if updated {
+		if err := writeBatch(ctx, ep.store, func(w ds.Write) error {
+			undo.Write = w
+			return ep.updateEnr(ctx, undo, id, old, n)
+		}); err != nil {
+			return id, false, fmt.Errorf("failed to add ENR of peer %s: %w", id.Pretty(), err)
+		}
+	}
+	ep.AddAddrs(id, addrs, ttl)
+	// the address book logs rather than returns its errors, check that it took the addresses instead
+	if missing := missingAddrs(ep.Addrs(id), addrs); ttl > 0 && len(missing) > 0 {
+		err := fmt.Errorf("%w: peer %s is missing %v", ErrENRAddrs, id.Pretty(), missing)
+		if revertErr := writeBatch(ctx, ep.store, func(w ds.Write) error {
+			return undo.revert(ctx, w)
+		}); revertErr != nil {
+			return id, updated, fmt.Errorf("%v, and failed to revert ENR: %w", err, revertErr)
+		}
+		return id, false, err
+	}
+	return id, updated, nil
+}
+
+func missingAddrs(have []ma.Multiaddr, want []ma.Multiaddr) (missing []ma.Multiaddr) {
+	for _, w := range want {
+		found := false
+		for _, h := range have {
+			if h.Equal(w) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, w)
+		}
+	}
+	return missing
+}
+
+// undoWrite records the value every key had before it was first written through it,
+// to revert the writes with later.
+type undoWrite struct {
+	ds.Write
+	store ds.Read
+	keys  []ds.Key
+	prev  map[ds.Key][]byte
+	// keys that did not exist before
+	added map[ds.Key]bool
+}
+
+func newUndoWrite(store ds.Read) *undoWrite {
+	return &undoWrite{store: store, prev: make(map[ds.Key][]byte), added: make(map[ds.Key]bool)}
+}
+
+func (u *undoWrite) record(ctx context.Context, key ds.Key) error {
+	if _, ok := u.prev[key]; ok || u.added[key] {
+		return nil
+	}
+	value, err := u.store.Get(ctx, key)
+	if errors.Is(err, ds.ErrNotFound) {
+		u.added[key] = true
+	} else if err != nil {
+		return fmt.Errorf("failed to read %s to undo writes with: %w", key, err)
+	} else {
+		u.prev[key] = value
+	}
+	u.keys = append(u.keys, key)
+	return nil
+}
+
+func (u *undoWrite) Put(ctx context.Context, key ds.Key, value []byte) error {
+	if err := u.record(ctx, key); err != nil {
+		return err
+	}
+	return u.Write.Put(ctx, key, value)
+}
+
+func (u *undoWrite) Delete(ctx context.Context, key ds.Key) error {
+	if err := u.record(ctx, key); err != nil {
+		return err
+	}
+	return u.Write.Delete(ctx, key)
+}
+
+// revert restores every recorded key to its previous value, or deletes it if it did not exist.
+func (u *undoWrite) revert(ctx context.Context, w ds.Write) error {
+	for _, key := range u.keys {
+		if u.added[key] {
+			if err := w.Delete(ctx, key); err != nil {
+				return err
+			}
+		} else if err := w.Put(ctx, key, u.prev[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
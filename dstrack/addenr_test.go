@@ -0,0 +1,156 @@
+package dstrack
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/protolambda/go-eth2-peerstore/addrutil"
+)
+
+func TestAddENR(t *testing.T) {
+	ctx := context.Background()
+	ep := newTestPeerstore(t)
+	k, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedID := addrutil.PeerIDFromPubkey(&k.PublicKey)
+	node := func(seq uint64, port int) *enode.Node {
+		var rec enr.Record
+		rec.SetSeq(seq)
+		rec.Set(enr.IPv4(net.IPv4(1, 2, 3, 4)))
+		rec.Set(enr.TCP(port))
+		if err := enode.SignV4(&rec, k); err != nil {
+			t.Fatal(err)
+		}
+		n, err := enode.New(enode.ValidSchemes, &rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	steps := []struct {
+		name       string
+		seq        uint64
+		port       int
+		clearAddrs bool
+		updated    bool
+		addrsAdded bool
+		storedSeq  uint64
+	}{
+		{"new ENR", 1, 9000, false, true, true, 1},
+		{"higher seq", 3, 9001, false, true, true, 3},
+		// addresses of the known ENR are added again, e.g. to extend their TTL
+		{"equal seq", 3, 9001, true, false, true, 3},
+		{"lower seq", 2, 9002, false, false, false, 3},
+	}
+	for _, s := range steps {
+		if s.clearAddrs {
+			ep.ClearAddrs(expectedID)
+		}
+		id, updated, err := ep.AddENR(ctx, node(s.seq, s.port), time.Hour)
+		if err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		if id != expectedID {
+			t.Fatalf("%s: derived peer ID %s, expected %s", s.name, id, expectedID)
+		}
+		if updated != s.updated {
+			t.Fatalf("%s: got updated %v, expected %v", s.name, updated, s.updated)
+		}
+		pub := ep.PubKey(id)
+		if pub == nil || !pub.Equals((*ic.Secp256k1PublicKey)(&k.PublicKey)) {
+			t.Fatalf("%s: pubkey of the ENR is not in the key book", s.name)
+		}
+		addr := ma.StringCast(fmt.Sprintf("/ip4/1.2.3.4/tcp/%d", s.port))
+		if added := len(missingAddrs(ep.Addrs(id), []ma.Multiaddr{addr})) == 0; added != s.addrsAdded {
+			t.Fatalf("%s: got address added %v, expected %v", s.name, added, s.addrsAdded)
+		}
+		n, err := ep.LatestENR(ctx, id)
+		if err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		if n.Seq() != s.storedSeq {
+			t.Fatalf("%s: stored ENR has seq %d, expected %d", s.name, n.Seq(), s.storedSeq)
+		}
+	}
+}
+
+func TestAddENRNoPubkey(t *testing.T) {
+	ep := newTestPeerstore(t)
+	var rec enr.Record
+	rec.Set(enr.IPv4(net.IPv4(1, 2, 3, 4)))
+	rec.Set(enr.TCP(9000))
+	n := enode.SignNull(&rec, enode.ID{1})
+	if _, _, err := ep.AddENR(context.Background(), n, time.Hour); !errors.Is(err, ErrENRNoPubkey) {
+		t.Fatalf("got error %v, expected %v", err, ErrENRNoPubkey)
+	}
+	if len(ep.Peers()) != 0 {
+		t.Fatalf("peers were added for an ENR without pubkey: %v", ep.Peers())
+	}
+}
+
+func TestUndoWrite(t *testing.T) {
+	ctx := context.Background()
+	store := dssync.MutexWrap(ds.NewMapDatastore())
+	before := map[string][]byte{"/a": []byte("a"), "/b": []byte("b"), "/empty": {}}
+	for k, v := range before {
+		if err := store.Put(ctx, ds.NewKey(k), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	undo := newUndoWrite(store)
+	undo.Write = store
+	writes := []struct {
+		key    string
+		value  []byte
+		delete bool
+	}{
+		{key: "/a", value: []byte("a2")},
+		{key: "/a", value: []byte("a3")},
+		{key: "/b", delete: true},
+		{key: "/empty", value: []byte("x")},
+		{key: "/c", value: []byte("c")},
+		{key: "/d", delete: true},
+	}
+	for _, w := range writes {
+		var err error
+		if w.delete {
+			err = undo.Delete(ctx, ds.NewKey(w.key))
+		} else {
+			err = undo.Put(ctx, ds.NewKey(w.key), w.value)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := undo.revert(ctx, store); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range before {
+		got, err := store.Get(ctx, ds.NewKey(k))
+		if err != nil {
+			t.Fatalf("%s: %v", k, err)
+		}
+		if !bytes.Equal(got, v) {
+			t.Fatalf("%s: got %q, expected %q", k, got, v)
+		}
+	}
+	for _, k := range []string{"/c", "/d"} {
+		if has, err := store.Has(ctx, ds.NewKey(k)); err != nil || has {
+			t.Fatalf("%s: expected key to not exist after revert, err: %v", k, err)
+		}
+	}
+}
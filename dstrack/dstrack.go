@@ -15,14 +15,18 @@ import (
 	"github.com/multiformats/go-base32"
 	"github.com/protolambda/go-eth2-peerstore"
 	"github.com/protolambda/go-eth2-peerstore/addrutil"
+	"github.com/protolambda/go-eth2-peerstore/agent"
 	"github.com/protolambda/go-eth2-peerstore/dstee"
-	"github.com/protolambda/zrnt/eth2/beacon/common"
 	"io"
 	"sync"
+	"time"
 )
 
 var eth2Base = ds.NewKey("/peers/eth2")
 
+// ErrCorruptData is returned when stored data exists, but cannot be decoded
+var ErrCorruptData = errors.New("corrupt peerstore data")
+
 func peerIdToKey(base ds.Key, p peer.ID) ds.Key {
 	return base.ChildString(base32.RawStdEncoding.EncodeToString([]byte(p)))
 }
@@ -35,9 +39,18 @@ type dsExtendedPeerstore struct {
 	*dsStatusBook
 	*dsMetadataBook
 	*dsENRBook
+	// stops the background garbage collection
+	gcCancel context.CancelFunc
+	gcDone   sync.WaitGroup
+	clock    Clock
 }
 
-func NewExtendedPeerstore(ctx context.Context, store ds.Batching, opts pstoreds.Options) (eth2peerstore.ExtendedPeerstore, error) {
+func NewExtendedPeerstore(ctx context.Context, store ds.Batching, opts pstoreds.Options, extOpts ...Option) (eth2peerstore.ExtendedPeerstore, error) {
+	o := options{clock: time.Now}
+	for _, opt := range extOpts {
+		opt(&o)
+	}
+
 	mul := dstee.MultiTee{}
 	store = &dstee.DSTee{
 		Batching: store,
@@ -60,14 +73,31 @@ func NewExtendedPeerstore(ctx context.Context, store ds.Batching, opts pstoreds.
 		return nil, err
 	}
 
-	return &dsExtendedPeerstore{
+	sb.clock = o.clock
+	sb.history = o.statusHistory
+	sb.classifier = o.classifier
+	mb.clock = o.clock
+	eb.clock = o.clock
+	eb.history = o.enrHistory
+	eb.lenient = o.lenientENRs
+	eb.verifyOnLoad = o.verifyENRs
+
+	ep := &dsExtendedPeerstore{
 		multiTee:       mul,
 		store:          store,
 		Peerstore:      ps,
 		dsStatusBook:   sb,
 		dsMetadataBook: mb,
 		dsENRBook:      eb,
-	}, nil
+		clock:          o.clock,
+	}
+	if o.gc.Interval > 0 {
+		gcCtx, cancel := context.WithCancel(context.Background())
+		ep.gcCancel = cancel
+		ep.gcDone.Add(1)
+		go ep.runGC(gcCtx, o.gc)
+	}
+	return ep, nil
 }
 
 var _ eth2peerstore.IdentifyBook = (*dsExtendedPeerstore)(nil)
@@ -129,11 +159,45 @@ func (ep *dsExtendedPeerstore) UserAgent(ctx context.Context, id peer.ID) (strin
 	return v, nil
 }
 
+// RemovePeer removes all libp2p and eth2 data of the peer, see RemovePeerData.
+// Errors are ignored, to comply with the libp2p peerstore interface.
+func (ep *dsExtendedPeerstore) RemovePeer(id peer.ID) {
+	_ = ep.RemovePeerData(context.Background(), id)
+}
+
+// RemovePeerData removes all eth2 data of the peer in a single datastore batch,
+// and then the libp2p data with the RemovePeer of the libp2p peerstore, which is not part of the batch.
+func (ep *dsExtendedPeerstore) RemovePeerData(ctx context.Context, id peer.ID) error {
+	ep.dsStatusBook.updateLock.Lock()
+	defer ep.dsStatusBook.updateLock.Unlock()
+	ep.dsENRBook.updateLock.Lock()
+	defer ep.dsENRBook.updateLock.Unlock()
+	ep.dsMetadataBook.Lock()
+	defer ep.dsMetadataBook.Unlock()
+	if err := writeBatch(ctx, ep.store, func(w ds.Write) error {
+		if err := ep.removeStatus(ctx, w, id); err != nil {
+			return err
+		}
+		if err := ep.removeMetadata(ctx, w, id); err != nil {
+			return err
+		}
+		if err := ep.removeENR(ctx, w, id); err != nil {
+			return err
+		}
+		return removeTimes(ctx, w, id, updatedSuffix, firstSeenSuffix)
+	}); err != nil {
+		return fmt.Errorf("failed to remove peer %s: %w", id.Pretty(), err)
+	}
+	ep.dsStatusBook.data.Delete(id)
+	ep.dsMetadataBook.forget(id)
+	ep.Peerstore.RemovePeer(id)
+	return nil
+}
+
 type Flusher interface {
 	flush() error
 }
 
-// TODO: start this as a background service, similar to the GC of addrs in the peerstore.
 func (ep *dsExtendedPeerstore) flush() error {
 	var errs []error
 	weakFlush := func(name string, c interface{}) {
@@ -154,6 +218,10 @@ func (ep *dsExtendedPeerstore) flush() error {
 }
 
 func (ep *dsExtendedPeerstore) Close() error {
+	if ep.gcCancel != nil {
+		ep.gcCancel()
+		ep.gcDone.Wait()
+	}
 	var errs []error
 	weakClose := func(name string, c interface{}) {
 		if cl, ok := c.(io.Closer); ok {
@@ -173,81 +241,149 @@ func (ep *dsExtendedPeerstore) Close() error {
 	return nil
 }
 
+// isMissing checks if the error indicates the absence of data, rather than a failure to retrieve it.
+func isMissing(err error) bool {
+	return errors.Is(err, ErrNoStatus) || errors.Is(err, ErrNoMetadata) || errors.Is(err, ErrNoClaim) ||
+		errors.Is(err, ErrNoENR) || errors.Is(err, ErrNoStatusRelevance) || errors.Is(err, ErrNoTime) ||
+		errors.Is(err, peerstore.ErrNotFound) || errors.Is(err, ds.ErrNotFound)
+}
+
 func (ep *dsExtendedPeerstore) GetAllData(ctx context.Context, id peer.ID) (*eth2peerstore.PeerAllData, error) {
-	pub := ep.PubKey(id)
-	secpKey := (pub).(*ic.Secp256k1PublicKey)
-	keyBytes, err := secpKey.Raw()
-	pubStr := ""
-	if err == nil {
-		pubStr = hex.EncodeToString(keyBytes[:])
+	out := &eth2peerstore.PeerAllData{
+		PeerID:  id,
+		Latency: ep.LatencyEWMA(id),
+	}
+	report := func(field string, err error) {
+		if isMissing(err) {
+			out.Missing = append(out.Missing, field)
+		} else {
+			if out.Errors == nil {
+				out.Errors = make(map[string]string)
+			}
+			out.Errors[field] = err.Error()
+		}
 	}
 
-	nodeID := enode.PubkeyToIDV4((*ecdsa.PublicKey)(secpKey))
-	protocols, err := ep.GetProtocols(id)
-	if err != nil {
-		return nil, fmt.Errorf("couldn't get protocols: %v\n", err)
+	if pub := ep.PubKey(id); pub == nil {
+		out.Missing = append(out.Missing, "pubkey", "node_id")
+	} else if secpKey, ok := pub.(*ic.Secp256k1PublicKey); !ok {
+		report("pubkey", fmt.Errorf("unsupported pubkey type: %T", pub))
+	} else {
+		if keyBytes, err := secpKey.Raw(); err == nil {
+			out.Pubkey = hex.EncodeToString(keyBytes[:])
+		} else {
+			report("pubkey", err)
+		}
+		out.NodeID = enode.PubkeyToIDV4((*ecdsa.PublicKey)(secpKey))
 	}
-	userAgent, err := ep.UserAgent(ctx, id)
-	if err != nil {
-		return nil, fmt.Errorf("couldn't get user agent: %v\n", err)
+
+	if protocols, err := ep.GetProtocols(id); err != nil {
+		report("protocols", fmt.Errorf("couldn't get protocols: %w", err))
+	} else {
+		out.Protocols = protocols
 	}
-	protVersion, err := ep.ProtocolVersion(ctx, id)
-	if err != nil {
-		return nil, fmt.Errorf("couldn't get protocol version: %v\n", err)
+	if userAgent, err := ep.UserAgent(ctx, id); err != nil {
+		report("user_agent", fmt.Errorf("couldn't get user agent: %w", err))
+	} else {
+		out.UserAgent = userAgent
 	}
-	seq, err := ep.ClaimedSeq(ctx, id)
-	if err != nil {
-		return nil, fmt.Errorf("couldn't get claimed seq nr: %v\n", err)
+	if protVersion, err := ep.ProtocolVersion(ctx, id); err != nil {
+		report("protocol_version", fmt.Errorf("couldn't get protocol version: %w", err))
+	} else {
+		out.ProtocolVersion = protVersion
+	}
+	if seq, err := ep.ClaimedSeq(ctx, id); err != nil {
+		report("claimed_seq", fmt.Errorf("couldn't get claimed seq nr: %w", err))
+	} else {
+		out.ClaimedSeq = seq
 	}
 
-	var multiAddrs []string
 	for _, addr := range ep.Addrs(id) {
-		multiAddrs = append(multiAddrs, addr.String())
+		out.Addrs = append(out.Addrs, addr.String())
 	}
-	var enrAttnets *common.AttnetBits
 
-	var forkDigest *common.ForkDigest
-	var nextForkVersion *common.Version
-	var nextForkEpoch *common.Epoch
-
-	en, err := ep.LatestENR(ctx, id)
-	if err != nil {
-		return nil, fmt.Errorf("couldn't get latest ENR: %v\n", err)
-	}
-	if en != nil {
-		if dat, exists, err := addrutil.ParseEnrEth2Data(en); err == nil && exists {
-			forkDigest = &dat.ForkDigest
-			nextForkVersion = &dat.NextForkVersion
-			nextForkEpoch = &dat.NextForkEpoch
+	if en, err := ep.LatestENR(ctx, id); err != nil {
+		report("enr", fmt.Errorf("couldn't get latest ENR: %w", err))
+	} else {
+		out.ENR = en
+		if dat, exists, err := addrutil.ParseEnrEth2Data(en); err != nil {
+			report("enr_fork_digest", err)
+		} else if exists {
+			out.ForkDigest = &dat.ForkDigest
+			out.NextForkVersion = &dat.NextForkVersion
+			out.NextForkEpoch = &dat.NextForkEpoch
+		}
+		if dat, exists, err := addrutil.ParseEnrAttnets(en); err != nil {
+			report("enr_attnets", err)
+		} else if exists {
+			out.Attnets = dat
+		}
+		if dat, exists, err := addrutil.ParseEnrClient(en); err != nil {
+			report("enr_client", err)
+		} else if exists {
+			out.ENRClient = dat
+			out.ENRClientMismatch = out.UserAgent != "" && !dat.MatchesUserAgent(out.UserAgent)
 		}
-		if dat, exists, err := addrutil.ParseEnrAttnets(en); err == nil && exists {
-			enrAttnets = dat
+		if dat, exists, err := addrutil.ParseEnrSyncnets(en); err != nil {
+			report("enr_syncnets", err)
+		} else if exists {
+			out.Syncnets = dat
+		}
+		if dat, exists, err := addrutil.ParseEnrCustodyGroupCount(en); err != nil {
+			report("enr_cgc", err)
+		} else if exists {
+			out.CustodyGroupCount = &dat
+		}
+		if dat, exists, err := addrutil.ParseEnrNextForkDigest(en); err != nil {
+			report("enr_next_fork_digest", err)
+		} else if exists {
+			out.NextForkDigest = dat
 		}
 	}
-	metadata, err := ep.Metadata(ctx, id)
-	if err != nil {
-		return nil, fmt.Errorf("couldn't get metadata: %v\n", err)
+	if metadata, err := ep.VersionedMetadata(ctx, id); err != nil {
+		report("metadata", fmt.Errorf("couldn't get metadata: %w", err))
+	} else {
+		md := metadata.V1()
+		out.MetaData = &md
+		out.MetaDataVersion = metadata.Version
+		out.MetaDataSyncnets = metadata.Syncnets
+		out.MetaDataCustodyGroupCount = metadata.CustodyGroupCount
 	}
-	status, err := ep.Status(ctx, id)
-	if err != nil {
-		return nil, fmt.Errorf("couldn't get status: %v\n", err)
-	}
-	return &eth2peerstore.PeerAllData{
-		PeerID:          id,
-		NodeID:          nodeID,
-		Pubkey:          pubStr,
-		Addrs:           multiAddrs,
-		Protocols:       protocols,
-		Latency:         ep.LatencyEWMA(id),
-		UserAgent:       userAgent,
-		ProtocolVersion: protVersion,
-		ForkDigest:      forkDigest,
-		NextForkVersion: nextForkVersion,
-		NextForkEpoch:   nextForkEpoch,
-		Attnets:         enrAttnets,
-		MetaData:        metadata,
-		ClaimedSeq:      seq,
-		Status:          status,
-		ENR:             en,
-	}, nil
+	if status, err := ep.VersionedStatus(ctx, id); err != nil {
+		report("status", fmt.Errorf("couldn't get status: %w", err))
+	} else {
+		st := status.V1()
+		out.Status = &st
+		out.StatusVersion = status.Version
+		out.EarliestAvailableSlot = status.EarliestAvailableSlot
+		if r, err := ep.StatusRelevance(ctx, id); err != nil {
+			report("status_relevance", fmt.Errorf("couldn't get status relevance: %w", err))
+		} else {
+			out.StatusRelevance = r
+		}
+	}
+
+	if out.UserAgent != "" {
+		client := agent.Parse(out.UserAgent)
+		out.Client = &client
+	} else if out.ENRClient != nil {
+		client := agent.Parse(out.ENRClient.String())
+		out.Client = &client
+	}
+
+	timeField := func(field string, get func(ctx context.Context, id peer.ID) (time.Time, error)) *time.Time {
+		t, err := get(ctx, id)
+		if err != nil {
+			report(field, err)
+			return nil
+		}
+		return &t
+	}
+	out.FirstSeen = timeField("first_seen", ep.FirstSeen)
+	out.LastUpdated = timeField("last_updated", ep.LastUpdated)
+	out.StatusUpdated = timeField("status_updated", ep.StatusUpdated)
+	out.MetadataUpdated = timeField("metadata_updated", ep.MetadataUpdated)
+	out.ClaimUpdated = timeField("claimed_seq_updated", ep.ClaimUpdated)
+	out.ENRUpdated = timeField("enr_updated", ep.ENRUpdated)
+	return out, nil
 }
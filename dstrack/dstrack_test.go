@@ -0,0 +1,50 @@
+package dstrack
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"testing"
+
+	gcrypto "github.com/ethereum/go-ethereum/crypto"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-peerstore/pstoreds"
+	"github.com/protolambda/go-eth2-peerstore/addrutil"
+)
+
+func newTestPeerstore(t *testing.T, opts ...Option) *dsExtendedPeerstore {
+	t.Helper()
+	ps, err := NewExtendedPeerstore(context.Background(), dssync.MutexWrap(ds.NewMapDatastore()), pstoreds.DefaultOpts(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = ps.Close()
+	})
+	return ps.(*dsExtendedPeerstore)
+}
+
+func newTestPeerID(t *testing.T) peer.ID {
+	t.Helper()
+	_, pub, err := crypto.GenerateSecp256k1Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func newTestPeerWithKey(t *testing.T) (peer.ID, *ecdsa.PrivateKey) {
+	t.Helper()
+	k, err := gcrypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return addrutil.PeerIDFromPubkey(&k.PublicKey), k
+}
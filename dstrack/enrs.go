@@ -2,6 +2,7 @@ package dstrack
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/ethereum/go-ethereum/p2p/enode"
 	"github.com/ethereum/go-ethereum/p2p/enr"
@@ -9,10 +10,26 @@ import (
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/protolambda/go-eth2-peerstore"
 	"github.com/protolambda/go-eth2-peerstore/addrutil"
+	"sync"
+	"time"
 )
 
 // enrs are stored under the /eth2/<peer id>/enr path, and stored in string representation
-var enrSuffix = ds.NewKey("/enr")
+var (
+	enrSuffix        = ds.NewKey("/enr")
+	enrHistorySuffix = ds.NewKey("/enr_history")
+)
+
+var (
+	// ErrNoENR is returned when no ENR was ever registered for the peer
+	ErrNoENR = errors.New("no ENR known")
+	// ErrENRSignature is returned when an ENR is not signed with a valid identity scheme
+	ErrENRSignature = errors.New("invalid ENR signature")
+	// ErrENRNoPubkey is returned when an ENR does not have a secp256k1 pubkey to derive a peer ID from
+	ErrENRNoPubkey = errors.New("ENR has no secp256k1 pubkey")
+	// ErrENRPeerMismatch is returned when the ENR pubkey does not derive the peer ID it is registered for
+	ErrENRPeerMismatch = errors.New("ENR pubkey does not match peer ID")
+)
 
 var validSchemesForDB = enr.SchemeMap{
 	"v4":   enode.V4ID{},
@@ -21,30 +38,72 @@ var validSchemesForDB = enr.SchemeMap{
 
 type dsENRBook struct {
 	ds ds.Datastore
+	// timestamps updates
+	clock Clock
+	// bounds the ENR history, disabled by default
+	history historyLimits
+	// if lenient, ENRs are not verified to be signed by the peer they are registered for
+	lenient bool
+	// if verifyOnLoad, stored ENRs are verified again when loaded, unless lenient
+	verifyOnLoad bool
+	// serializes the read-modify-write of ENR updates and removals, to keep the indexes consistent
+	updateLock sync.Mutex
 }
 
 var _ eth2peerstore.ENRBook = (*dsENRBook)(nil)
 
 func NewENRBook(store ds.Datastore) (*dsENRBook, error) {
-	return &dsENRBook{ds: store}, nil
+	return &dsENRBook{ds: store, clock: time.Now}, nil
+}
+
+// verifyEnr checks that the ENR is validly signed, by the key of the given peer.
+func verifyEnr(id peer.ID, n *enode.Node) error {
+	if err := n.Record().VerifySignature(enode.ValidSchemes); err != nil {
+		return fmt.Errorf("%w: %v", ErrENRSignature, err)
+	}
+	pub := n.Pubkey()
+	if pub == nil {
+		return ErrENRNoPubkey
+	}
+	if derived := addrutil.PeerIDFromPubkey(pub); derived != id {
+		return fmt.Errorf("%w: ENR of %s registered for %s", ErrENRPeerMismatch, derived.Pretty(), id.Pretty())
+	}
+	return nil
+}
+
+// decodeEnr decodes a stored ENR. ENRs are verified when they are registered,
+// and only verified again if the book is configured to verify on load.
+func (eb *dsENRBook) decodeEnr(id peer.ID, value []byte) (*enode.Node, error) {
+	rec, err := addrutil.ParseEnr(string(value))
+	if err != nil {
+		return nil, fmt.Errorf("%w: retrieved enr could not be parsed: %v", ErrCorruptData, err)
+	}
+	n, err := enode.New(validSchemesForDB, rec)
+	if err != nil {
+		return nil, fmt.Errorf("%w: retrieved enr is invalid: %v", ErrCorruptData, err)
+	}
+	if eb.verifyOnLoad && !eb.lenient {
+		if err := verifyEnr(id, n); err != nil {
+			return nil, fmt.Errorf("%w: retrieved enr does not belong to peer: %v", ErrCorruptData, err)
+		}
+	}
+	return n, nil
 }
 
 func (eb *dsENRBook) loadEnr(ctx context.Context, p peer.ID) (*enode.Node, error) {
 	key := peerIdToKey(eth2Base, p).Child(enrSuffix)
 	value, err := eb.ds.Get(ctx, key)
-	if err != nil {
+	if errors.Is(err, ds.ErrNotFound) {
+		return nil, fmt.Errorf("%w for peer %s", ErrNoENR, p.Pretty())
+	} else if err != nil {
 		return nil, fmt.Errorf("error while fetching enr from datastore for peer %s: %s\n", p.Pretty(), err)
 	}
-	rec, err := addrutil.ParseEnr(string(value))
-	if err != nil {
-		return nil, fmt.Errorf("retrieved enr could not be parsed: %v", err)
-	}
-	return enode.New(validSchemesForDB, rec)
+	return eb.decodeEnr(p, value)
 }
 
-func (eb *dsENRBook) storeEnr(ctx context.Context, p peer.ID, n *enode.Node) error {
+func (eb *dsENRBook) storeEnr(ctx context.Context, w ds.Write, p peer.ID, n *enode.Node) error {
 	key := peerIdToKey(eth2Base, p).Child(enrSuffix)
-	if err := eb.ds.Put(ctx, key, []byte(n.String())); err != nil {
+	if err := w.Put(ctx, key, []byte(n.String())); err != nil {
 		return fmt.Errorf("failed to store enr: %v", err)
 	}
 	return nil
@@ -52,11 +111,21 @@ func (eb *dsENRBook) storeEnr(ctx context.Context, p peer.ID, n *enode.Node) err
 
 // Update the record tracking of the peer,
 // return updated=true if the node is new, or it overrides a previously seen node (by higher seq nr).
-// and return eth2 and attnet data, if any.
+// Unless the book is lenient, the node must be validly signed by the peer,
+// or ErrENRSignature, ErrENRNoPubkey or ErrENRPeerMismatch is returned.
 func (eb *dsENRBook) UpdateENRMaybe(ctx context.Context, id peer.ID, n *enode.Node) (updated bool, err error) {
+	if !eb.lenient {
+		if err := verifyEnr(id, n); err != nil {
+			return false, err
+		}
+	}
+	eb.updateLock.Lock()
+	defer eb.updateLock.Unlock()
 	old, err := eb.loadEnr(ctx, id)
 	if err != nil || old.Seq() < n.Seq() {
-		if err := eb.storeEnr(ctx, id, n); err != nil {
+		if err := writeBatch(ctx, eb.ds, func(w ds.Write) error {
+			return eb.updateEnr(ctx, w, id, old, n)
+		}); err != nil {
 			return false, err
 		}
 		return true, nil
@@ -64,6 +133,74 @@ func (eb *dsENRBook) UpdateENRMaybe(ctx context.Context, id peer.ID, n *enode.No
 	return false, nil
 }
 
+// updateEnr stores the ENR that replaces the old ENR (nil if none), with its indexes, update time and history.
+func (eb *dsENRBook) updateEnr(ctx context.Context, w ds.Write, id peer.ID, old *enode.Node, n *enode.Node) error {
+	if err := eb.storeEnr(ctx, w, id, n); err != nil {
+		return err
+	}
+	now := eb.clock()
+	if err := markUpdated(ctx, eb.ds, w, id, enrUpdatedSuffix, now); err != nil {
+		return err
+	}
+	if eb.history.enabled() {
+		key := peerIdToKey(eth2Base, id).Child(enrHistorySuffix)
+		if err := appendHistory(ctx, eb.ds, w, key, eb.history, historyEntry{time: now, data: []byte(n.String())}); err != nil {
+			return err
+		}
+	}
+	return updateENRIndexes(ctx, w, id, old, n)
+}
+
 func (eb *dsENRBook) LatestENR(ctx context.Context, id peer.ID) (n *enode.Node, err error) {
 	return eb.loadEnr(ctx, id)
 }
+
+// ENRUpdated returns when a newer ENR of the peer was last registered
+func (eb *dsENRBook) ENRUpdated(ctx context.Context, id peer.ID) (time.Time, error) {
+	return loadTime(ctx, eb.ds, id, enrUpdatedSuffix)
+}
+
+// ENRHistory returns up to limit of the latest ENRs of the peer, newest first. No limit if limit <= 0.
+// The history is empty if it is not enabled.
+func (eb *dsENRBook) ENRHistory(ctx context.Context, id peer.ID, limit int) ([]eth2peerstore.ENRSnapshot, error) {
+	entries, err := loadHistory(ctx, eb.ds, peerIdToKey(eth2Base, id).Child(enrHistorySuffix))
+	if err != nil {
+		return nil, err
+	}
+	entries = latestHistory(eb.history.trim(entries, eb.clock()), limit)
+	out := make([]eth2peerstore.ENRSnapshot, 0, len(entries))
+	for _, e := range entries {
+		n, err := eb.decodeEnr(id, e.data)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, eth2peerstore.ENRSnapshot{Time: e.time, ENR: n})
+	}
+	return out, nil
+}
+
+// RemoveENR removes the ENR of the peer, if any
+func (eb *dsENRBook) RemoveENR(ctx context.Context, id peer.ID) error {
+	eb.updateLock.Lock()
+	defer eb.updateLock.Unlock()
+	return writeBatch(ctx, eb.ds, func(w ds.Write) error {
+		return eb.removeENR(ctx, w, id)
+	})
+}
+
+func (eb *dsENRBook) removeENR(ctx context.Context, w ds.Write, id peer.ID) error {
+	old, _ := eb.loadEnr(ctx, id)
+	if err := w.Delete(ctx, peerIdToKey(eth2Base, id).Child(enrSuffix)); err != nil {
+		return fmt.Errorf("failed to remove enr: %v", err)
+	}
+	if err := w.Delete(ctx, peerIdToKey(eth2Base, id).Child(enrHistorySuffix)); err != nil {
+		return fmt.Errorf("failed to remove enr history: %v", err)
+	}
+	if err := removeTimes(ctx, w, id, enrUpdatedSuffix); err != nil {
+		return err
+	}
+	if err := removePeerTimesIfEmpty(ctx, eb.ds, w, id, enrSuffix); err != nil {
+		return err
+	}
+	return updateENRIndexes(ctx, w, id, old, nil)
+}
@@ -0,0 +1,175 @@
+package dstrack
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+// acceptAnyV4 is the v4 identity scheme without signature check, to construct nodes with a tampered signature.
+type acceptAnyV4 struct {
+	enode.V4ID
+}
+
+func (acceptAnyV4) Verify(r *enr.Record, sig []byte) error { return nil }
+
+// tamperedENR returns an ENR signed by k, with the TCP port changed after signing.
+func tamperedENR(t *testing.T, k *ecdsa.PrivateKey) *enode.Node {
+	t.Helper()
+	var rec enr.Record
+	rec.Set(enr.IPv4(net.IPv4(1, 2, 3, 4)))
+	rec.Set(enr.TCP(9000))
+	if err := enode.SignV4(&rec, k); err != nil {
+		t.Fatal(err)
+	}
+	sig := rec.Signature()
+	rec.Set(enr.TCP(9001))
+	if err := rec.SetSig(acceptAnyV4{}, sig); err != nil {
+		t.Fatal(err)
+	}
+	n, err := enode.New(enr.SchemeMap{"v4": acceptAnyV4{}}, &rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// nullENR returns an ENR with the "null" identity scheme, which has no signature or pubkey.
+func nullENR(t *testing.T) *enode.Node {
+	t.Helper()
+	var rec enr.Record
+	rec.Set(enr.IPv4(net.IPv4(1, 2, 3, 4)))
+	return enode.SignNull(&rec, enode.ID{1})
+}
+
+func TestVerifyEnr(t *testing.T) {
+	id, k := newTestPeerWithKey(t)
+	other, _ := newTestPeerWithKey(t)
+	cases := []struct {
+		name     string
+		id       peer.ID
+		node     *enode.Node
+		expected error
+	}{
+		{"valid", id, testENR(t, k, 1, common.AttnetBits{}, common.ForkDigest{}), nil},
+		{"tampered signature", id, tamperedENR(t, k), ErrENRSignature},
+		{"null scheme", id, nullENR(t), ErrENRSignature},
+		{"other peer", other, testENR(t, k, 1, common.AttnetBits{}, common.ForkDigest{}), ErrENRPeerMismatch},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := verifyEnr(c.id, c.node)
+			if c.expected == nil && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if c.expected != nil && !errors.Is(err, c.expected) {
+				t.Fatalf("got error %v, expected %v", err, c.expected)
+			}
+		})
+	}
+}
+
+func TestUpdateENRVerification(t *testing.T) {
+	id, k := newTestPeerWithKey(t)
+	other, _ := newTestPeerWithKey(t)
+	cases := []struct {
+		name string
+		id   peer.ID
+		node *enode.Node
+		// expected error of a strict book, a lenient book accepts all cases
+		strictErr error
+	}{
+		{"valid", id, testENR(t, k, 1, common.AttnetBits{}, common.ForkDigest{}), nil},
+		{"null scheme", id, nullENR(t), ErrENRSignature},
+		{"other peer", other, testENR(t, k, 1, common.AttnetBits{}, common.ForkDigest{}), ErrENRPeerMismatch},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			strict := newTestPeerstore(t)
+			updated, err := strict.UpdateENRMaybe(ctx, c.id, c.node)
+			if c.strictErr == nil {
+				if err != nil || !updated {
+					t.Fatalf("expected update, got %v, %v", updated, err)
+				}
+			} else {
+				if !errors.Is(err, c.strictErr) || updated {
+					t.Fatalf("got %v, %v, expected error %v", updated, err, c.strictErr)
+				}
+				if _, err := strict.LatestENR(ctx, c.id); !errors.Is(err, ErrNoENR) {
+					t.Fatalf("rejected ENR was stored: %v", err)
+				}
+			}
+
+			lenient := newTestPeerstore(t, WithLenientENRs())
+			if updated, err := lenient.UpdateENRMaybe(ctx, c.id, c.node); err != nil || !updated {
+				t.Fatalf("lenient book rejected ENR: %v, %v", updated, err)
+			}
+			n, err := lenient.LatestENR(ctx, c.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n.Seq() != c.node.Seq() || n.ID() != c.node.ID() {
+				t.Fatalf("loaded ENR %s, expected %s", n, c.node)
+			}
+		})
+	}
+	// a lenient book stores a tampered ENR as-is, but cannot load it
+	lenient := newTestPeerstore(t, WithLenientENRs())
+	if _, err := lenient.UpdateENRMaybe(context.Background(), id, tamperedENR(t, k)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lenient.LatestENR(context.Background(), id); !errors.Is(err, ErrCorruptData) {
+		t.Fatalf("expected corrupt data error, got %v", err)
+	}
+}
+
+func TestENRVerificationOnLoad(t *testing.T) {
+	id, k := newTestPeerWithKey(t)
+	_, otherKey := newTestPeerWithKey(t)
+	cases := []struct {
+		name string
+		// stored in the datastore for the peer, bypassing the registration checks
+		stored  *enode.Node
+		opts    []Option
+		corrupt bool
+	}{
+		{"valid", testENR(t, k, 1, common.AttnetBits{}, common.ForkDigest{}), []Option{WithENRVerificationOnLoad()}, false},
+		{"other peer", testENR(t, otherKey, 1, common.AttnetBits{}, common.ForkDigest{}), nil, false},
+		{"other peer verified", testENR(t, otherKey, 1, common.AttnetBits{}, common.ForkDigest{}), []Option{WithENRVerificationOnLoad()}, true},
+		{"other peer verified lenient", testENR(t, otherKey, 1, common.AttnetBits{}, common.ForkDigest{}), []Option{WithENRVerificationOnLoad(), WithLenientENRs()}, false},
+		{"null scheme", nullENR(t), nil, false},
+		{"null scheme verified", nullENR(t), []Option{WithENRVerificationOnLoad()}, true},
+		{"tampered signature", tamperedENR(t, k), nil, true},
+		{"tampered signature lenient", tamperedENR(t, k), []Option{WithLenientENRs()}, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			ep := newTestPeerstore(t, c.opts...)
+			if err := ep.store.Put(ctx, peerIdToKey(eth2Base, id).Child(enrSuffix), []byte(c.stored.String())); err != nil {
+				t.Fatal(err)
+			}
+			n, err := ep.LatestENR(ctx, id)
+			if c.corrupt {
+				if !errors.Is(err, ErrCorruptData) {
+					t.Fatalf("expected corrupt data error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n.ID() != c.stored.ID() {
+				t.Fatalf("loaded ENR %s, expected %s", n, c.stored)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package dstrack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"sort"
+	"time"
+)
+
+// GCOptions configures the removal of stale peers.
+type GCOptions struct {
+	// Interval between garbage collection runs. Disabled if 0.
+	Interval time.Duration
+	// MaxAge since the last update of the eth2 data and addresses of a peer, after which it is removed.
+	// No age limit if 0.
+	MaxAge time.Duration
+	// MaxPeers to keep, not counting protected peers. The least recently updated peers are removed first.
+	// No limit if 0.
+	MaxPeers int
+	// Protected peers are never removed.
+	Protected []peer.ID
+	// OnRemoved is optional, and called after every run with the removed peers,
+	// and the error that stopped the run early, if any.
+	OnRemoved func(removed []peer.ID, err error)
+}
+
+// addrsUpdated returns now if the address book has any unexpired addresses of the peer, or the zero time otherwise.
+// The address book does not expose when addresses were set, but expires them by their TTL:
+// a peer with addresses is fresh until its last address TTL runs out, regardless of the GC max age.
+func (ep *dsExtendedPeerstore) addrsUpdated(id peer.ID, now time.Time) time.Time {
+	if len(ep.Addrs(id)) > 0 {
+		return now
+	}
+	return time.Time{}
+}
+
+// collectGarbage removes the peers that are stale, or exceed the max peer count, and returns them.
+func (ep *dsExtendedPeerstore) collectGarbage(ctx context.Context, opts *GCOptions) (removed []peer.ID, err error) {
+	now := ep.clock()
+	protected := make(map[peer.ID]struct{}, len(opts.Protected))
+	for _, id := range opts.Protected {
+		protected[id] = struct{}{}
+	}
+
+	eth2Peers, _, err := ep.Eth2Peers(ctx, "", 0)
+	if err != nil {
+		return nil, err
+	}
+	// true for peers with eth2 data
+	candidates := make(map[peer.ID]bool)
+	for _, id := range ep.Peers() {
+		candidates[id] = false
+	}
+	for _, id := range eth2Peers {
+		candidates[id] = true
+	}
+
+	type peerAge struct {
+		id      peer.ID
+		updated time.Time
+	}
+	var keep []peerAge
+	for id, isEth2 := range candidates {
+		if err := ctx.Err(); err != nil {
+			return removed, err
+		}
+		if _, ok := protected[id]; ok {
+			continue
+		}
+		updated, err := ep.LastUpdated(ctx, id)
+		if errors.Is(err, ErrNoTime) {
+			// eth2 data stored before update times were recorded is stamped on first sight,
+			// to age out from now on, rather than be removed immediately.
+			if isEth2 {
+				if err := ep.store.Put(ctx, peerIdToKey(eth2Base, id).Child(updatedSuffix), encodeTime(now)); err != nil {
+					return removed, fmt.Errorf("failed to stamp peer %s: %w", id.Pretty(), err)
+				}
+				updated = now
+			} else {
+				updated = time.Time{}
+			}
+		} else if err != nil {
+			return removed, fmt.Errorf("failed to check peer %s: %w", id.Pretty(), err)
+		}
+		if addrsUpdated := ep.addrsUpdated(id, now); addrsUpdated.After(updated) {
+			updated = addrsUpdated
+		}
+		// peers without any known update time are never considered stale
+		if updated.IsZero() {
+			updated = now
+		}
+		if opts.MaxAge > 0 && now.Sub(updated) > opts.MaxAge {
+			if err := ep.removeStalePeer(ctx, id); err != nil {
+				return removed, err
+			}
+			removed = append(removed, id)
+		} else {
+			keep = append(keep, peerAge{id: id, updated: updated})
+		}
+	}
+
+	if opts.MaxPeers > 0 && len(keep) > opts.MaxPeers {
+		// most recently updated first
+		sort.Slice(keep, func(i, j int) bool {
+			return keep[i].updated.After(keep[j].updated)
+		})
+		for _, p := range keep[opts.MaxPeers:] {
+			if err := ep.removeStalePeer(ctx, p.id); err != nil {
+				return removed, err
+			}
+			removed = append(removed, p.id)
+		}
+	}
+	return removed, nil
+}
+
+// removeStalePeer removes the eth2 data and the addresses of the peer.
+// The removal is not atomic: the peer may be updated concurrently, after its eth2 data is removed,
+// and before its addresses are cleared. Any data that remains is removed by a later run, once stale again.
+func (ep *dsExtendedPeerstore) removeStalePeer(ctx context.Context, id peer.ID) error {
+	if err := ep.RemovePeerData(ctx, id); err != nil {
+		return err
+	}
+	ep.ClearAddrs(id)
+	return nil
+}
+
+// runGC runs the garbage collection at the configured interval, until the context is canceled.
+// Removals are visible to tees as datastore deletions.
+func (ep *dsExtendedPeerstore) runGC(ctx context.Context, opts GCOptions) {
+	defer ep.gcDone.Done()
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// errors are retried in the next run
+			removed, err := ep.collectGarbage(ctx, &opts)
+			if opts.OnRemoved != nil {
+				opts.OnRemoved(removed, err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package dstrack
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+type gcPeer struct {
+	name string
+	// age of the eth2 data at the time of the GC run
+	age time.Duration
+	// legacy peers have eth2 data, but no update time
+	legacy    bool
+	protected bool
+	// addresses are added at the time of the GC run
+	addrs bool
+	// addresses are added, but expired by the time of the GC run
+	expiredAddrs bool
+}
+
+// testClock is a settable clock for the peerstore
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+// setupGC creates a peerstore with the given peers, and returns the clock, the peer IDs by name,
+// and the GC options with the protected peers.
+func setupGC(t *testing.T, peers []gcPeer) (*dsExtendedPeerstore, *testClock, map[peer.ID]string, GCOptions) {
+	ctx := context.Background()
+	// pstoreds timestamps addresses with the system time
+	now := time.Now()
+	clock := &testClock{}
+	ep := newTestPeerstore(t, WithClock(clock.Now))
+	names := make(map[peer.ID]string)
+	var opts GCOptions
+	for _, p := range peers {
+		id := newTestPeerID(t)
+		names[id] = p.name
+		clock.now = now.Add(-p.age)
+		if err := ep.RegisterStatus(ctx, id, common.Status{HeadSlot: 1}); err != nil {
+			t.Fatal(err)
+		}
+		if p.legacy {
+			if err := ep.store.Delete(ctx, peerIdToKey(eth2Base, id).Child(updatedSuffix)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if p.protected {
+			opts.Protected = append(opts.Protected, id)
+		}
+		if p.addrs {
+			ep.AddAddrs(id, []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.4/tcp/9000")}, time.Hour)
+		}
+		if p.expiredAddrs {
+			ep.AddAddrs(id, []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.5/tcp/9000")}, time.Nanosecond)
+		}
+	}
+	clock.now = now
+	return ep, clock, names, opts
+}
+
+func removedNames(names map[peer.ID]string, removed []peer.ID) []string {
+	out := make([]string, 0, len(removed))
+	for _, id := range removed {
+		out = append(out, names[id])
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestCollectGarbage(t *testing.T) {
+	cases := []struct {
+		name     string
+		peers    []gcPeer
+		maxAge   time.Duration
+		maxPeers int
+		removed  []string
+	}{
+		{"no limits", []gcPeer{
+			{name: "fresh", age: time.Minute},
+			{name: "old", age: 24 * time.Hour},
+		}, 0, 0, []string{}},
+		{"max age", []gcPeer{
+			{name: "fresh", age: 10 * time.Minute},
+			{name: "stale", age: 2 * time.Hour},
+			{name: "legacy", legacy: true},
+			{name: "protected", age: 2 * time.Hour, protected: true},
+			{name: "readdressed", age: 2 * time.Hour, addrs: true},
+			{name: "expired addresses", age: 2 * time.Hour, expiredAddrs: true},
+		}, time.Hour, 0, []string{"expired addresses", "stale"}},
+		{"max peers", []gcPeer{
+			{name: "a", age: 10 * time.Minute},
+			{name: "b", age: 20 * time.Minute},
+			{name: "c", age: 30 * time.Minute},
+			{name: "d", age: 40 * time.Minute},
+			{name: "protected", age: 50 * time.Minute, protected: true},
+		}, 0, 2, []string{"c", "d"}},
+		{"max age and max peers", []gcPeer{
+			{name: "a", age: 10 * time.Minute},
+			{name: "b", age: 20 * time.Minute},
+			{name: "stale", age: 2 * time.Hour},
+			{name: "c", age: 30 * time.Minute},
+		}, time.Hour, 2, []string{"c", "stale"}},
+		// legacy peers are stamped with the time of the run, and are the most recently updated
+		{"legacy peers within max peers", []gcPeer{
+			{name: "legacy", legacy: true},
+			{name: "a", age: 10 * time.Minute},
+			{name: "b", age: 20 * time.Minute},
+		}, 0, 2, []string{"b"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ep, _, names, opts := setupGC(t, c.peers)
+			opts.MaxAge = c.maxAge
+			opts.MaxPeers = c.maxPeers
+			removed, err := ep.collectGarbage(context.Background(), &opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := removedNames(names, removed); !reflect.DeepEqual(got, c.removed) {
+				t.Fatalf("removed %v, expected %v", got, c.removed)
+			}
+			for _, id := range removed {
+				if _, err := ep.Status(context.Background(), id); err == nil {
+					t.Fatalf("status of removed peer %s is still known", names[id])
+				}
+			}
+		})
+	}
+}
+
+func TestCollectGarbageLegacyPeer(t *testing.T) {
+	ctx := context.Background()
+	ep, clock, names, opts := setupGC(t, []gcPeer{{name: "legacy", legacy: true}})
+	opts.MaxAge = time.Hour
+	removed, err := ep.collectGarbage(ctx, &opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 0 {
+		t.Fatalf("removed legacy peer on first sight: %v", removedNames(names, removed))
+	}
+	clock.now = clock.now.Add(2 * time.Hour)
+	removed, err = ep.collectGarbage(ctx, &opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := removedNames(names, removed); !reflect.DeepEqual(got, []string{"legacy"}) {
+		t.Fatalf("removed %v, expected the legacy peer to age out", got)
+	}
+}
+
+func TestGCOnRemoved(t *testing.T) {
+	type run struct {
+		removed []peer.ID
+		err     error
+	}
+	runs := make(chan run, 1)
+	clock := &testClock{now: time.Now().Add(-2 * time.Hour)}
+	ep := newTestPeerstore(t, WithClock(clock.Now))
+	id := newTestPeerID(t)
+	if err := ep.RegisterStatus(context.Background(), id, common.Status{}); err != nil {
+		t.Fatal(err)
+	}
+	clock.now = time.Now()
+	ep.gcDone.Add(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		ep.gcDone.Wait()
+	}()
+	go ep.runGC(ctx, GCOptions{Interval: time.Millisecond, MaxAge: time.Hour, OnRemoved: func(removed []peer.ID, err error) {
+		select {
+		case runs <- run{removed, err}:
+		default:
+		}
+	}})
+	select {
+	case r := <-runs:
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		if !reflect.DeepEqual(r.removed, []peer.ID{id}) {
+			t.Fatalf("got removed %v, expected %v", r.removed, []peer.ID{id})
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnRemoved was not called")
+	}
+}
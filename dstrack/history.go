@@ -0,0 +1,97 @@
+package dstrack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/protolambda/go-eth2-peerstore/types"
+	"time"
+)
+
+// historyEntry is a timestamped item of a bounded history, stored as types.HistoryEntry.
+type historyEntry struct {
+	time time.Time
+	data []byte
+}
+
+func encodeHistory(entries []historyEntry) []byte {
+	out := make([]types.HistoryEntry, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, types.HistoryEntry{TimeMs: timeToMs(e.time), Data: e.data})
+	}
+	return types.EncodeHistory(out)
+}
+
+func decodeHistory(v []byte) ([]historyEntry, error) {
+	items, err := types.DecodeHistory(v)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptData, err)
+	}
+	out := make([]historyEntry, 0, len(items))
+	for _, item := range items {
+		out = append(out, historyEntry{time: msToTime(item.TimeMs), data: item.Data})
+	}
+	return out, nil
+}
+
+func loadHistory(ctx context.Context, store ds.Read, key ds.Key) ([]historyEntry, error) {
+	value, err := store.Get(ctx, key)
+	if errors.Is(err, ds.ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get history: %w", err)
+	}
+	return decodeHistory(value)
+}
+
+// historyLimits bounds a history by the amount of entries and/or the age of entries. Disabled when both are 0.
+type historyLimits struct {
+	depth  int
+	maxAge time.Duration
+}
+
+func (l historyLimits) enabled() bool {
+	return l.depth > 0 || l.maxAge > 0
+}
+
+// trim drops the oldest entries that exceed the limits.
+func (l historyLimits) trim(entries []historyEntry, now time.Time) []historyEntry {
+	if l.maxAge > 0 {
+		i := 0
+		for i < len(entries) && now.Sub(entries[i].time) > l.maxAge {
+			i++
+		}
+		entries = entries[i:]
+	}
+	if l.depth > 0 && len(entries) > l.depth {
+		entries = entries[len(entries)-l.depth:]
+	}
+	return entries
+}
+
+// appendHistory adds the entry to the history stored under the key, and trims it to the limits.
+func appendHistory(ctx context.Context, store ds.Read, w ds.Write, key ds.Key, limits historyLimits, entry historyEntry) error {
+	entries, err := loadHistory(ctx, store, key)
+	if err != nil {
+		// start over, rather than being stuck with a corrupt history
+		entries = nil
+	}
+	entries = limits.trim(append(entries, entry), entry.time)
+	if err := w.Put(ctx, key, encodeHistory(entries)); err != nil {
+		return fmt.Errorf("failed to store history: %v", err)
+	}
+	return nil
+}
+
+// latestHistory returns up to limit entries of the history, newest first. No limit if limit <= 0.
+func latestHistory(entries []historyEntry, limit int) []historyEntry {
+	if limit <= 0 || limit > len(entries) {
+		limit = len(entries)
+	}
+	out := make([]historyEntry, 0, limit)
+	for i := len(entries) - 1; i >= len(entries)-limit; i-- {
+		out = append(out, entries[i])
+	}
+	return out
+}
@@ -0,0 +1,338 @@
+package dstrack
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/protolambda/go-eth2-peerstore"
+	"github.com/protolambda/go-eth2-peerstore/addrutil"
+	"github.com/protolambda/go-eth2-peerstore/types"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/ztyp/bitfields"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+Secondary indexes, maintained in the same batch as the data they index:
+
+	/peers/eth2-idx
+		- /attnet/<subnet>/<peer-id>/<source>  <- empty value, source is "enr" or "metadata"
+		- /syncnet/<subnet>/<peer-id>/<source> <- empty value, source is "enr" or "metadata"
+		- /digest/<digest>/<peer-id>/<source>  <- empty value, source is "enr" or "status"
+		- /nodeid/<node-id>                    <- raw peer ID bytes
+*/
+
+var (
+	eth2IndexBase = ds.NewKey("/peers/eth2-idx")
+	attnetIndex   = eth2IndexBase.ChildString("attnet")
+	syncnetIndex  = eth2IndexBase.ChildString("syncnet")
+	digestIndex   = eth2IndexBase.ChildString("digest")
+	nodeIDIndex   = eth2IndexBase.ChildString("nodeid")
+)
+
+const (
+	indexSourceENR      = "enr"
+	indexSourceMetadata = "metadata"
+	indexSourceStatus   = "status"
+)
+
+// ErrUnknownNodeID is returned when no peer is indexed for a node ID
+var ErrUnknownNodeID = errors.New("unknown node ID")
+
+// writeBatch applies the writes of fn atomically, if the datastore supports batching.
+func writeBatch(ctx context.Context, store ds.Datastore, fn func(w ds.Write) error) error {
+	batching, ok := store.(ds.Batching)
+	if !ok {
+		return fn(store)
+	}
+	b, err := batching.Batch(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start batch: %w", err)
+	}
+	if err := fn(b); err != nil {
+		return err
+	}
+	if err := b.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit batch: %w", err)
+	}
+	return nil
+}
+
+func updateIndexKey(ctx context.Context, w ds.Write, key ds.Key, had bool, has bool) error {
+	if had == has {
+		return nil
+	}
+	if has {
+		if err := w.Put(ctx, key, []byte{}); err != nil {
+			return fmt.Errorf("failed to add index entry %s: %w", key, err)
+		}
+	} else {
+		if err := w.Delete(ctx, key); err != nil {
+			return fmt.Errorf("failed to remove index entry %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// updateAttnetIndex updates the attnet index entries of the source, based on the previous and next attnets.
+func updateAttnetIndex(ctx context.Context, w ds.Write, id peer.ID, source string, prev *common.AttnetBits, next *common.AttnetBits) error {
+	for i := uint64(0); i < common.ATTESTATION_SUBNET_COUNT; i++ {
+		had := prev != nil && bitfields.GetBit(prev[:], i)
+		has := next != nil && bitfields.GetBit(next[:], i)
+		key := peerIdToKey(attnetIndex.ChildString(strconv.FormatUint(i, 10)), id).ChildString(source)
+		if err := updateIndexKey(ctx, w, key, had, has); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateSyncnetIndex updates the syncnet index entries of the source, based on the previous and next syncnets.
+func updateSyncnetIndex(ctx context.Context, w ds.Write, id peer.ID, source string, prev *types.SyncnetBits, next *types.SyncnetBits) error {
+	for i := uint64(0); i < common.SYNC_COMMITTEE_SUBNET_COUNT; i++ {
+		had := prev != nil && bitfields.GetBit(prev[:], i)
+		has := next != nil && bitfields.GetBit(next[:], i)
+		key := peerIdToKey(syncnetIndex.ChildString(strconv.FormatUint(i, 10)), id).ChildString(source)
+		if err := updateIndexKey(ctx, w, key, had, has); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateDigestIndex updates the fork digest index entry of the source, based on the previous and next digest.
+func updateDigestIndex(ctx context.Context, w ds.Write, id peer.ID, source string, prev *common.ForkDigest, next *common.ForkDigest) error {
+	if prev != nil && next != nil && *prev == *next {
+		return nil
+	}
+	if prev != nil {
+		key := peerIdToKey(digestIndex.ChildString(hex.EncodeToString(prev[:])), id).ChildString(source)
+		if err := updateIndexKey(ctx, w, key, true, false); err != nil {
+			return err
+		}
+	}
+	if next != nil {
+		key := peerIdToKey(digestIndex.ChildString(hex.EncodeToString(next[:])), id).ChildString(source)
+		if err := updateIndexKey(ctx, w, key, false, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateNodeIDIndex updates the node ID index entry of the peer, based on the previous and next node ID.
+func updateNodeIDIndex(ctx context.Context, w ds.Write, id peer.ID, prev *enode.ID, next *enode.ID) error {
+	if prev != nil && next != nil && *prev == *next {
+		return nil
+	}
+	if prev != nil {
+		if err := w.Delete(ctx, nodeIDIndex.ChildString(prev.String())); err != nil {
+			return fmt.Errorf("failed to remove node ID index entry: %w", err)
+		}
+	}
+	if next != nil {
+		if err := w.Put(ctx, nodeIDIndex.ChildString(next.String()), []byte(id)); err != nil {
+			return fmt.Errorf("failed to add node ID index entry: %w", err)
+		}
+	}
+	return nil
+}
+
+// updateENRIndexes updates all index entries derived from the ENR, based on the previous and next ENR.
+func updateENRIndexes(ctx context.Context, w ds.Write, id peer.ID, prev *enode.Node, next *enode.Node) error {
+	enrIndexData := func(n *enode.Node) (attnets *common.AttnetBits, syncnets *types.SyncnetBits, digest *common.ForkDigest, nodeID *enode.ID) {
+		if n == nil {
+			return
+		}
+		if dat, exists, err := addrutil.ParseEnrAttnets(n); err == nil && exists {
+			attnets = dat
+		}
+		if dat, exists, err := addrutil.ParseEnrSyncnets(n); err == nil && exists {
+			syncnets = dat
+		}
+		if dat, exists, err := addrutil.ParseEnrEth2Data(n); err == nil && exists {
+			digest = &dat.ForkDigest
+		}
+		nid := n.ID()
+		nodeID = &nid
+		return
+	}
+	prevAttnets, prevSyncnets, prevDigest, prevNodeID := enrIndexData(prev)
+	nextAttnets, nextSyncnets, nextDigest, nextNodeID := enrIndexData(next)
+	if err := updateAttnetIndex(ctx, w, id, indexSourceENR, prevAttnets, nextAttnets); err != nil {
+		return err
+	}
+	if err := updateSyncnetIndex(ctx, w, id, indexSourceENR, prevSyncnets, nextSyncnets); err != nil {
+		return err
+	}
+	if err := updateDigestIndex(ctx, w, id, indexSourceENR, prevDigest, nextDigest); err != nil {
+		return err
+	}
+	return updateNodeIDIndex(ctx, w, id, prevNodeID, nextNodeID)
+}
+
+var _ eth2peerstore.PeerIndex = (*dsExtendedPeerstore)(nil)
+
+func (ep *dsExtendedPeerstore) indexedPeers(ctx context.Context, base ds.Key) (out []peer.ID, err error) {
+	_, err = scanPeers(ctx, ep.store, base, "", func(id peer.ID, cursor eth2peerstore.PeerCursor) bool {
+		out = append(out, id)
+		return true
+	})
+	return out, err
+}
+
+func (ep *dsExtendedPeerstore) PeersOnAttnet(ctx context.Context, subnet uint64) ([]peer.ID, error) {
+	if subnet >= common.ATTESTATION_SUBNET_COUNT {
+		return nil, fmt.Errorf("invalid attestation subnet: %d", subnet)
+	}
+	return ep.indexedPeers(ctx, attnetIndex.ChildString(strconv.FormatUint(subnet, 10)))
+}
+
+func (ep *dsExtendedPeerstore) PeersOnSyncnet(ctx context.Context, subnet uint64) ([]peer.ID, error) {
+	if subnet >= common.SYNC_COMMITTEE_SUBNET_COUNT {
+		return nil, fmt.Errorf("invalid sync committee subnet: %d", subnet)
+	}
+	return ep.indexedPeers(ctx, syncnetIndex.ChildString(strconv.FormatUint(subnet, 10)))
+}
+
+func (ep *dsExtendedPeerstore) PeersWithForkDigest(ctx context.Context, digest common.ForkDigest) ([]peer.ID, error) {
+	return ep.indexedPeers(ctx, digestIndex.ChildString(hex.EncodeToString(digest[:])))
+}
+
+func (ep *dsExtendedPeerstore) PeerByNodeID(ctx context.Context, id enode.ID) (peer.ID, error) {
+	value, err := ep.store.Get(ctx, nodeIDIndex.ChildString(id.String()))
+	if errors.Is(err, ds.ErrNotFound) {
+		return "", fmt.Errorf("%w: %s", ErrUnknownNodeID, id)
+	} else if err != nil {
+		return "", fmt.Errorf("failed to lookup node ID %s: %w", id, err)
+	}
+	return peer.ID(value), nil
+}
+
+// RebuildError is returned by RebuildIndexes, with the peers that could not be indexed
+// because some of their data could not be read. Their other data is still indexed.
+type RebuildError struct {
+	Peers map[peer.ID]error
+}
+
+func (e *RebuildError) Error() string {
+	ids := make([]peer.ID, 0, len(e.Peers))
+	for id := range e.Peers {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	var out strings.Builder
+	fmt.Fprintf(&out, "failed to index %d peers:", len(ids))
+	for _, id := range ids {
+		fmt.Fprintf(&out, " %s (%v)", id.Pretty(), e.Peers[id])
+	}
+	return out.String()
+}
+
+// indexWrite collects the index entries written through it, to compare against the stored indexes.
+type indexWrite map[ds.Key][]byte
+
+func (iw indexWrite) Put(ctx context.Context, key ds.Key, value []byte) error {
+	iw[key] = value
+	return nil
+}
+
+func (iw indexWrite) Delete(ctx context.Context, key ds.Key) error {
+	delete(iw, key)
+	return nil
+}
+
+// RebuildIndexes computes the index entries of every eth2 peer again, and replaces each index family
+// (attnets, syncnets, digests and node IDs) in a single batch, so lookups never see a partial index.
+// Updates are blocked during the rebuild. Peers with data that cannot be read are reported in a *RebuildError.
+func (ep *dsExtendedPeerstore) RebuildIndexes(ctx context.Context) error {
+	ep.dsStatusBook.updateLock.Lock()
+	defer ep.dsStatusBook.updateLock.Unlock()
+	ep.dsENRBook.updateLock.Lock()
+	defer ep.dsENRBook.updateLock.Unlock()
+	ep.dsMetadataBook.Lock()
+	defer ep.dsMetadataBook.Unlock()
+
+	ids, _, err := ep.Eth2Peers(ctx, "", 0)
+	if err != nil {
+		return err
+	}
+	entries := make(indexWrite)
+	failed := make(map[peer.ID]error)
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if st, err := ep.VersionedStatus(ctx, id); err == nil {
+			_ = updateDigestIndex(ctx, entries, id, indexSourceStatus, nil, &st.ForkDigest)
+		} else if !errors.Is(err, ErrNoStatus) {
+			failed[id] = err
+		}
+		if md, err := ep.dsMetadataBook.metadata(ctx, id); err == nil {
+			_ = updateAttnetIndex(ctx, entries, id, indexSourceMetadata, nil, &md.Attnets)
+			_ = updateSyncnetIndex(ctx, entries, id, indexSourceMetadata, nil, md.Syncnets)
+		} else if !errors.Is(err, ErrNoMetadata) {
+			failed[id] = err
+		}
+		if n, err := ep.LatestENR(ctx, id); err == nil {
+			_ = updateENRIndexes(ctx, entries, id, nil, n)
+		} else if !errors.Is(err, ErrNoENR) {
+			failed[id] = err
+		}
+	}
+
+	for _, family := range []ds.Key{attnetIndex, syncnetIndex, digestIndex, nodeIDIndex} {
+		if err := ep.replaceIndex(ctx, family, entries); err != nil {
+			return err
+		}
+	}
+	if len(failed) > 0 {
+		return &RebuildError{Peers: failed}
+	}
+	return nil
+}
+
+// replaceIndex replaces the stored entries under the index family base with the entries under the same base,
+// in a single batch.
+func (ep *dsExtendedPeerstore) replaceIndex(ctx context.Context, base ds.Key, entries indexWrite) error {
+	results, err := ep.store.Query(ctx, query.Query{Prefix: base.String()})
+	if err != nil {
+		return fmt.Errorf("failed to query index %s: %w", base, err)
+	}
+	stored, err := results.Rest()
+	if err != nil {
+		return fmt.Errorf("failed to read index %s: %w", base, err)
+	}
+	return writeBatch(ctx, ep.store, func(w ds.Write) error {
+		existing := make(map[ds.Key]struct{}, len(stored))
+		for _, e := range stored {
+			key := ds.NewKey(e.Key)
+			if value, ok := entries[key]; ok && bytes.Equal(value, e.Value) {
+				existing[key] = struct{}{}
+				continue
+			}
+			if err := w.Delete(ctx, key); err != nil {
+				return fmt.Errorf("failed to remove index entry %s: %w", key, err)
+			}
+		}
+		for key, value := range entries {
+			if _, ok := existing[key]; ok || !base.IsAncestorOf(key) {
+				continue
+			}
+			if err := w.Put(ctx, key, value); err != nil {
+				return fmt.Errorf("failed to add index entry %s: %w", key, err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,357 @@
+package dstrack
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-peerstore/pstoreds"
+	"github.com/protolambda/go-eth2-peerstore/addrutil"
+	"github.com/protolambda/go-eth2-peerstore/types"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+func testENR(t *testing.T, k *ecdsa.PrivateKey, seq uint64, attnets common.AttnetBits, digest common.ForkDigest) *enode.Node {
+	t.Helper()
+	var rec enr.Record
+	rec.SetSeq(seq)
+	rec.Set(addrutil.NewAttnetsENREntry(&attnets))
+	rec.Set(addrutil.NewEth2DataEntry(&common.Eth2Data{ForkDigest: digest}))
+	if err := enode.SignV4(&rec, k); err != nil {
+		t.Fatal(err)
+	}
+	n, err := enode.New(enode.ValidSchemes, &rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// indexState is the index of a single peer: the attnets, syncnets and digests it is listed on,
+// and whether it is listed for its node ID.
+type indexState struct {
+	attnets  []uint64
+	syncnets []uint64
+	digests  []common.ForkDigest
+	nodeID   bool
+}
+
+func TestIndexMaintenance(t *testing.T) {
+	ctx := context.Background()
+	ep := newTestPeerstore(t)
+	k, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeID := enode.PubkeyToIDV4(&k.PublicKey)
+	id := addrutil.PeerIDFromPubkey(&k.PublicKey)
+	digestA := common.ForkDigest{0xa}
+	digestB := common.ForkDigest{0xb}
+
+	steps := []struct {
+		name     string
+		apply    func() error
+		expected indexState
+	}{
+		{"add ENR", func() error {
+			_, _, err := ep.AddENR(ctx, testENR(t, k, 1, common.AttnetBits{0b011}, digestA), time.Hour)
+			return err
+		}, indexState{attnets: []uint64{0, 1}, digests: []common.ForkDigest{digestA}, nodeID: true}},
+		{"add metadata", func() error {
+			_, err := ep.RegisterMetadataV2(ctx, id, types.MetaDataV2{SeqNumber: 1, Attnets: common.AttnetBits{0b110}, Syncnets: types.SyncnetBits{0b10}})
+			return err
+		}, indexState{attnets: []uint64{0, 1, 2}, syncnets: []uint64{1}, digests: []common.ForkDigest{digestA}, nodeID: true}},
+		// attnet 1 is still listed through the metadata, when the ENR drops it
+		{"update ENR", func() error {
+			_, err := ep.UpdateENRMaybe(ctx, id, testENR(t, k, 2, common.AttnetBits{0b100}, digestB))
+			return err
+		}, indexState{attnets: []uint64{1, 2}, syncnets: []uint64{1}, digests: []common.ForkDigest{digestB}, nodeID: true}},
+		{"ignore older ENR", func() error {
+			_, err := ep.UpdateENRMaybe(ctx, id, testENR(t, k, 1, common.AttnetBits{0b1000}, digestA))
+			return err
+		}, indexState{attnets: []uint64{1, 2}, syncnets: []uint64{1}, digests: []common.ForkDigest{digestB}, nodeID: true}},
+		{"add status", func() error {
+			return ep.RegisterStatus(ctx, id, common.Status{ForkDigest: digestA})
+		}, indexState{attnets: []uint64{1, 2}, syncnets: []uint64{1}, digests: []common.ForkDigest{digestA, digestB}, nodeID: true}},
+		{"update metadata", func() error {
+			_, err := ep.RegisterMetadata(ctx, id, common.MetaData{SeqNumber: 2, Attnets: common.AttnetBits{0b1000}})
+			return err
+		}, indexState{attnets: []uint64{2, 3}, digests: []common.ForkDigest{digestA, digestB}, nodeID: true}},
+		{"rebuild indexes", func() error {
+			return ep.RebuildIndexes(ctx)
+		}, indexState{attnets: []uint64{2, 3}, digests: []common.ForkDigest{digestA, digestB}, nodeID: true}},
+		{"remove ENR", func() error {
+			return ep.RemoveENR(ctx, id)
+		}, indexState{attnets: []uint64{3}, digests: []common.ForkDigest{digestA}}},
+		{"remove status", func() error {
+			return ep.RemoveStatus(ctx, id)
+		}, indexState{attnets: []uint64{3}}},
+		{"add ENR again", func() error {
+			_, _, err := ep.AddENR(ctx, testENR(t, k, 3, common.AttnetBits{0b1}, digestB), time.Hour)
+			return err
+		}, indexState{attnets: []uint64{0, 3}, digests: []common.ForkDigest{digestB}, nodeID: true}},
+		{"remove peer data", func() error {
+			return ep.RemovePeerData(ctx, id)
+		}, indexState{}},
+	}
+	digests := []common.ForkDigest{digestA, digestB}
+	for _, step := range steps {
+		if err := step.apply(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		// the lookups list no peer, or only the single test peer
+		indexed := func(ids []peer.ID, err error) bool {
+			if err != nil {
+				t.Fatalf("%s: %v", step.name, err)
+			}
+			if len(ids) > 1 {
+				t.Fatalf("%s: indexed %d peers, expected at most 1", step.name, len(ids))
+			}
+			return len(ids) == 1
+		}
+		var got indexState
+		for i := uint64(0); i < common.ATTESTATION_SUBNET_COUNT; i++ {
+			if indexed(ep.PeersOnAttnet(ctx, i)) {
+				got.attnets = append(got.attnets, i)
+			}
+		}
+		for i := uint64(0); i < common.SYNC_COMMITTEE_SUBNET_COUNT; i++ {
+			if indexed(ep.PeersOnSyncnet(ctx, i)) {
+				got.syncnets = append(got.syncnets, i)
+			}
+		}
+		for _, d := range digests {
+			if indexed(ep.PeersWithForkDigest(ctx, d)) {
+				got.digests = append(got.digests, d)
+			}
+		}
+		if p, err := ep.PeerByNodeID(ctx, nodeID); err == nil {
+			if p != id {
+				t.Fatalf("%s: node ID indexed for peer %s, expected %s", step.name, p, id)
+			}
+			got.nodeID = true
+		} else if !errors.Is(err, ErrUnknownNodeID) {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if !reflect.DeepEqual(got, step.expected) {
+			t.Fatalf("%s: got index %+v, expected %+v", step.name, got, step.expected)
+		}
+	}
+}
+
+func TestRebuildIndexes(t *testing.T) {
+	ctx := context.Background()
+	ep := newTestPeerstore(t)
+	good := newTestPeerID(t)
+	corrupt := newTestPeerID(t)
+	digest := common.ForkDigest{0xc}
+	for _, id := range []peer.ID{good, corrupt} {
+		if err := ep.RegisterStatus(ctx, id, common.Status{ForkDigest: digest}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ep.RegisterMetadata(ctx, id, common.MetaData{SeqNumber: 1, Attnets: common.AttnetBits{0b1}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// bypass the metadata cache, to find the corrupt metadata on rebuild
+	ep.dsMetadataBook.Lock()
+	delete(ep.dsMetadataBook.metadatas, corrupt)
+	ep.dsMetadataBook.Unlock()
+	if err := ep.store.Put(ctx, peerIdToKey(eth2Base, corrupt).Child(metadataSuffix), []byte{0xff}); err != nil {
+		t.Fatal(err)
+	}
+	// an entry of a peer that does not exist anymore
+	stale := newTestPeerID(t)
+	if err := updateAttnetIndex(ctx, ep.store, stale, indexSourceMetadata, nil, &common.AttnetBits{0b10}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := ep.RebuildIndexes(ctx)
+	var rebuildErr *RebuildError
+	if !errors.As(err, &rebuildErr) {
+		t.Fatalf("expected rebuild error, got %v", err)
+	}
+	if len(rebuildErr.Peers) != 1 || !errors.Is(rebuildErr.Peers[corrupt], ErrCorruptData) {
+		t.Fatalf("expected only the corrupt peer to fail, got %v", rebuildErr)
+	}
+
+	lookups := []struct {
+		name     string
+		lookup   func() ([]peer.ID, error)
+		expected []peer.ID
+	}{
+		{"metadata attnet", func() ([]peer.ID, error) { return ep.PeersOnAttnet(ctx, 0) }, []peer.ID{good}},
+		{"stale attnet", func() ([]peer.ID, error) { return ep.PeersOnAttnet(ctx, 1) }, nil},
+		// the status of the peer with corrupt metadata is still indexed
+		{"status digest", func() ([]peer.ID, error) { return ep.PeersWithForkDigest(ctx, digest) }, []peer.ID{good, corrupt}},
+	}
+	for _, l := range lookups {
+		ids, err := l.lookup()
+		if err != nil {
+			t.Fatalf("%s: %v", l.name, err)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		sort.Slice(l.expected, func(i, j int) bool { return l.expected[i] < l.expected[j] })
+		if !reflect.DeepEqual(ids, l.expected) {
+			t.Fatalf("%s: got %v, expected %v", l.name, ids, l.expected)
+		}
+	}
+}
+
+func TestRebuildIndexesConcurrentUpdates(t *testing.T) {
+	ctx := context.Background()
+	ep := newTestPeerstore(t)
+	ids := make([]peer.ID, 8)
+	for i := range ids {
+		ids[i] = newTestPeerID(t)
+		if err := ep.RegisterStatus(ctx, ids[i], common.Status{ForkDigest: common.ForkDigest{0}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 5; i++ {
+			if err := ep.RebuildIndexes(ctx); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for round := byte(1); round <= 5; round++ {
+		for _, id := range ids {
+			if err := ep.RegisterStatus(ctx, id, common.Status{ForkDigest: common.ForkDigest{round}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	wg.Wait()
+	for round := byte(0); round <= 5; round++ {
+		indexed, err := ep.PeersWithForkDigest(ctx, common.ForkDigest{round})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := 0
+		if round == 5 {
+			expected = len(ids)
+		}
+		if len(indexed) != expected {
+			t.Fatalf("digest %d: indexed %d peers, expected %d", round, len(indexed), expected)
+		}
+	}
+}
+
+var errTestCommit = errors.New("test commit failure")
+
+// failingStore fails to commit batches while fail is set
+type failingStore struct {
+	ds.Batching
+	fail bool
+}
+
+func (fs *failingStore) Batch(ctx context.Context) (ds.Batch, error) {
+	b, err := fs.Batching.Batch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &failingBatch{Batch: b, store: fs}, nil
+}
+
+type failingBatch struct {
+	ds.Batch
+	store *failingStore
+}
+
+func (fb *failingBatch) Commit(ctx context.Context) error {
+	if fb.store.fail {
+		return errTestCommit
+	}
+	return fb.Batch.Commit(ctx)
+}
+
+func TestFailedCommitKeepsCache(t *testing.T) {
+	ctx := context.Background()
+	store := &failingStore{Batching: dssync.MutexWrap(ds.NewMapDatastore())}
+	ps, err := NewExtendedPeerstore(ctx, store, pstoreds.DefaultOpts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep := ps.(*dsExtendedPeerstore)
+	id := newTestPeerID(t)
+	digestA := common.ForkDigest{0xa}
+	digestB := common.ForkDigest{0xb}
+	if err := ep.RegisterStatus(ctx, id, common.Status{ForkDigest: digestA}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ep.RegisterMetadata(ctx, id, common.MetaData{SeqNumber: 1, Attnets: common.AttnetBits{0b1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	store.fail = true
+	failing := []struct {
+		name string
+		op   func() error
+	}{
+		{"register status", func() error {
+			return ep.RegisterStatus(ctx, id, common.Status{ForkDigest: digestB})
+		}},
+		{"remove status", func() error {
+			return ep.RemoveStatus(ctx, id)
+		}},
+		{"register metadata", func() error {
+			_, err := ep.RegisterMetadata(ctx, id, common.MetaData{SeqNumber: 2, Attnets: common.AttnetBits{0b10}})
+			return err
+		}},
+		{"register claim", func() error {
+			_, err := ep.RegisterSeqClaim(ctx, id, 5)
+			return err
+		}},
+		{"remove metadata", func() error {
+			return ep.RemoveMetadata(ctx, id)
+		}},
+		{"remove peer data", func() error {
+			return ep.RemovePeerData(ctx, id)
+		}},
+	}
+	for _, f := range failing {
+		if err := f.op(); !errors.Is(err, errTestCommit) {
+			t.Fatalf("%s: expected commit failure, got %v", f.name, err)
+		}
+		st, err := ep.Status(ctx, id)
+		if err != nil {
+			t.Fatalf("%s: %v", f.name, err)
+		}
+		if st.ForkDigest != digestA {
+			t.Fatalf("%s: cached status has digest %s, expected %s", f.name, st.ForkDigest, digestA)
+		}
+		md, err := ep.Metadata(ctx, id)
+		if err != nil {
+			t.Fatalf("%s: %v", f.name, err)
+		}
+		if md.SeqNumber != 1 {
+			t.Fatalf("%s: cached metadata has seq %d, expected 1", f.name, md.SeqNumber)
+		}
+		if claim, err := ep.ClaimedSeq(ctx, id); err != nil || claim != 1 {
+			t.Fatalf("%s: cached claim is %d (err: %v), expected 1", f.name, claim, err)
+		}
+		if ids, err := ep.PeersWithForkDigest(ctx, digestA); err != nil || len(ids) != 1 {
+			t.Fatalf("%s: status digest is not indexed anymore (err: %v)", f.name, err)
+		}
+	}
+	store.fail = false
+	if err := ep.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,95 @@
+package dstrack
+
+import (
+	"context"
+	"fmt"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-base32"
+	"github.com/protolambda/go-eth2-peerstore"
+	"strings"
+)
+
+var _ eth2peerstore.PeerIterator = (*dsExtendedPeerstore)(nil)
+
+// scanPeers visits every unique peer with keys under the given base, after the cursor.
+// Keys are ordered, and since '/' sorts before any base32 character,
+// all keys of a peer are grouped together, in the order of their encoded peer ID.
+// The query starts past the keys of the cursor peer, and the remaining keys of each visited peer
+// are skipped by prefix, so a page only reads the keys of the peers on it.
+func scanPeers(ctx context.Context, store ds.Datastore, base ds.Key, after eth2peerstore.PeerCursor,
+	fn func(id peer.ID, cursor eth2peerstore.PeerCursor) bool) (next eth2peerstore.PeerCursor, err error) {
+	prefix := base.String() + "/"
+	q := query.Query{
+		Prefix:   base.String(),
+		KeysOnly: true,
+		Orders:   []query.Order{query.OrderByKey{}},
+	}
+	if after != "" {
+		// '0' directly follows '/', and is not a base32 character:
+		// every key of the cursor peer sorts before it, and every key of a later peer after it.
+		q.Filters = []query.Filter{query.FilterKeyCompare{Op: query.GreaterThanOrEqual, Key: prefix + string(after) + "0"}}
+	}
+	results, err := store.Query(ctx, q)
+	if err != nil {
+		return after, fmt.Errorf("failed to query peers: %w", err)
+	}
+	defer results.Close()
+
+	last := after
+	skip := ""
+	for res := range results.Next() {
+		if err := ctx.Err(); err != nil {
+			return last, err
+		}
+		if res.Error != nil {
+			return last, fmt.Errorf("failed to read peer key: %w", res.Error)
+		}
+		if skip != "" && strings.HasPrefix(res.Key, skip) {
+			continue
+		}
+		if !strings.HasPrefix(res.Key, prefix) {
+			continue
+		}
+		enc := res.Key[len(prefix):]
+		if i := strings.IndexByte(enc, '/'); i >= 0 {
+			enc = enc[:i]
+		}
+		skip = prefix + enc + "/"
+		last = eth2peerstore.PeerCursor(enc)
+		raw, err := base32.RawStdEncoding.DecodeString(enc)
+		if err != nil {
+			// not a peer key, ignore it
+			continue
+		}
+		if !fn(peer.ID(raw), last) {
+			return last, nil
+		}
+	}
+	return "", nil
+}
+
+func (ep *dsExtendedPeerstore) Eth2Peers(ctx context.Context, after eth2peerstore.PeerCursor, limit int) (ids []peer.ID, next eth2peerstore.PeerCursor, err error) {
+	next, err = scanPeers(ctx, ep.store, eth2Base, after, func(id peer.ID, cursor eth2peerstore.PeerCursor) bool {
+		ids = append(ids, id)
+		return limit <= 0 || len(ids) < limit
+	})
+	return ids, next, err
+}
+
+func (ep *dsExtendedPeerstore) IteratePeers(ctx context.Context, after eth2peerstore.PeerCursor, fn func(data *eth2peerstore.PeerAllData) bool) (next eth2peerstore.PeerCursor, err error) {
+	var dataErr error
+	next, err = scanPeers(ctx, ep.store, eth2Base, after, func(id peer.ID, cursor eth2peerstore.PeerCursor) bool {
+		data, err := ep.GetAllData(ctx, id)
+		if err != nil {
+			dataErr = fmt.Errorf("failed to get data of peer %s: %w", id.Pretty(), err)
+			return false
+		}
+		return fn(data)
+	})
+	if err == nil && dataErr != nil {
+		err = dataErr
+	}
+	return next, err
+}
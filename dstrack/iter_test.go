@@ -0,0 +1,192 @@
+package dstrack
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-base32"
+	"github.com/protolambda/go-eth2-peerstore"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+// newIterPeerstore registers a status for count peers, and returns them in the order of their datastore keys
+func newIterPeerstore(t *testing.T, count int) (*dsExtendedPeerstore, []peer.ID) {
+	ctx := context.Background()
+	ep := newTestPeerstore(t)
+	ids := make([]peer.ID, count)
+	for i := range ids {
+		ids[i] = newTestPeerID(t)
+		if err := ep.RegisterStatus(ctx, ids[i], common.Status{HeadSlot: common.Slot(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return base32.RawStdEncoding.EncodeToString([]byte(ids[i])) < base32.RawStdEncoding.EncodeToString([]byte(ids[j]))
+	})
+	return ep, ids
+}
+
+func TestEth2PeersCursor(t *testing.T) {
+	ctx := context.Background()
+	ep, ids := newIterPeerstore(t, 7)
+	cases := []struct {
+		name  string
+		limit int
+		pages int
+	}{
+		{"no limit", 0, 1},
+		{"single peer pages", 1, 8},
+		{"uneven pages", 3, 3},
+		// a full last page does not know it is the last, and is followed by an empty page
+		{"exact page", 7, 2},
+		{"limit beyond peers", 10, 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var got []peer.ID
+			var cursor eth2peerstore.PeerCursor
+			pages := 0
+			for {
+				page, next, err := ep.Eth2Peers(ctx, cursor, c.limit)
+				if err != nil {
+					t.Fatal(err)
+				}
+				pages++
+				if c.limit > 0 && len(page) > c.limit {
+					t.Fatalf("page of %d peers exceeds limit %d", len(page), c.limit)
+				}
+				got = append(got, page...)
+				if next == "" {
+					break
+				}
+				if pages > len(ids)+1 {
+					t.Fatal("cursor does not advance")
+				}
+				cursor = next
+			}
+			if pages != c.pages {
+				t.Fatalf("got %d pages, expected %d", pages, c.pages)
+			}
+			if !reflect.DeepEqual(got, ids) {
+				t.Fatalf("got peers %v, expected %v", got, ids)
+			}
+		})
+	}
+}
+
+func TestIteratePeersResume(t *testing.T) {
+	ctx := context.Background()
+	ep, ids := newIterPeerstore(t, 5)
+	cases := []struct {
+		name string
+		stop int
+	}{
+		{"stop at first", 1},
+		{"stop halfway", 3},
+		{"stop at last", 5},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var got []peer.ID
+			visit := func(data *eth2peerstore.PeerAllData) bool {
+				got = append(got, data.PeerID)
+				return len(got) < c.stop
+			}
+			next, err := ep.IteratePeers(ctx, "", visit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if next == "" {
+				t.Fatal("expected cursor to resume from")
+			}
+			if len(got) != c.stop {
+				t.Fatalf("visited %d peers before stopping, expected %d", len(got), c.stop)
+			}
+			next, err = ep.IteratePeers(ctx, next, func(data *eth2peerstore.PeerAllData) bool {
+				got = append(got, data.PeerID)
+				return true
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if next != "" {
+				t.Fatalf("expected end of iteration, got cursor %q", next)
+			}
+			if !reflect.DeepEqual(got, ids) {
+				t.Fatalf("got peers %v, expected %v", got, ids)
+			}
+		})
+	}
+}
+
+// queryRecorder records the keys returned by queries
+type queryRecorder struct {
+	ds.Datastore
+	keys []string
+}
+
+func (r *queryRecorder) Query(ctx context.Context, q query.Query) (query.Results, error) {
+	res, err := r.Datastore.Query(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		r.keys = append(r.keys, e.Key)
+	}
+	return query.ResultsWithEntries(q, entries), nil
+}
+
+func TestScanPeersSkipsCursor(t *testing.T) {
+	ctx := context.Background()
+	// the encoding of the first peer is a prefix of the encoding of the second
+	ids := []peer.ID{"\x00", "\x00\x00", "\x01"}
+	store := &queryRecorder{Datastore: dssync.MutexWrap(ds.NewMapDatastore())}
+	for _, id := range ids {
+		for _, suffix := range []ds.Key{statusSuffix, metadataSuffix, firstSeenSuffix} {
+			if err := store.Put(ctx, peerIdToKey(eth2Base, id).Child(suffix), []byte{1}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	var cursor eth2peerstore.PeerCursor
+	for i, id := range ids {
+		store.keys = nil
+		var got []peer.ID
+		next, err := scanPeers(ctx, store, eth2Base, cursor, func(id peer.ID, cursor eth2peerstore.PeerCursor) bool {
+			got = append(got, id)
+			return false
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, []peer.ID{id}) {
+			t.Fatalf("page %d: got peers %v, expected %v", i, got, id)
+		}
+		for _, key := range store.keys {
+			for _, prev := range ids[:i] {
+				if strings.HasPrefix(key, peerIdToKey(eth2Base, prev).String()+"/") {
+					t.Fatalf("page %d: query returned key %s of earlier peer", i, key)
+				}
+			}
+		}
+		cursor = next
+	}
+	next, err := scanPeers(ctx, store, eth2Base, cursor, func(id peer.ID, cursor eth2peerstore.PeerCursor) bool {
+		t.Fatalf("unexpected peer %v after the last", id)
+		return true
+	})
+	if err != nil || next != "" {
+		t.Fatalf("expected end of scan, got %q, %v", next, err)
+	}
+}
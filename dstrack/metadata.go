@@ -1,16 +1,19 @@
 package dstrack
 
 import (
-	"bytes"
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/protolambda/go-eth2-peerstore"
+	"github.com/protolambda/go-eth2-peerstore/types"
 	"github.com/protolambda/zrnt/eth2/beacon/common"
-	"github.com/protolambda/ztyp/codec"
+	"strings"
 	"sync"
+	"time"
 )
 
 var (
@@ -18,50 +21,66 @@ var (
 	claimSuffix    = ds.NewKey("/metadata_claim")
 )
 
+var (
+	// ErrNoMetadata is returned when no metadata was ever registered for the peer
+	ErrNoMetadata = errors.New("no metadata known")
+	// ErrNoClaim is returned when the peer never claimed any metadata seq nr
+	ErrNoClaim = errors.New("no metadata seq nr claim known")
+	// ErrMetadataVersion is returned when the known metadata of the peer is older than the requested version
+	ErrMetadataVersion = errors.New("metadata version too old")
+)
+
 type dsMetadataBook struct {
 	ds ds.Datastore
 	// cache metadata objects to not load/store them all the time
 	sync.RWMutex
 	// Track metadata with highest sequence number
-	metadatas map[peer.ID]common.MetaData
+	metadatas map[peer.ID]types.VersionedMetaData
 	// highest claimed seq nr, we may not have the actual corresponding metadata yet.
 	claims map[peer.ID]common.SeqNr
 	// Track how many times we have tried to ask them for metadata without getting an answer
 	fetches map[peer.ID]uint64
+	// timestamps updates
+	clock Clock
 }
 
 var _ eth2peerstore.MetadataBook = (*dsMetadataBook)(nil)
+var _ eth2peerstore.VersionedMetadataBook = (*dsMetadataBook)(nil)
 
 func NewMetadataBook(store ds.Datastore) (*dsMetadataBook, error) {
 	return &dsMetadataBook{
 		ds:        store,
-		metadatas: make(map[peer.ID]common.MetaData),
+		metadatas: make(map[peer.ID]types.VersionedMetaData),
 		claims:    make(map[peer.ID]common.SeqNr),
 		fetches:   make(map[peer.ID]uint64),
+		clock:     time.Now,
 	}, nil
 }
 
-func (mb *dsMetadataBook) loadMetadata(ctx context.Context, p peer.ID) (*common.MetaData, error) {
+// metadata is stored with a version byte prefix, see types.VersionedMetaData.EncodeTagged.
+// Metadata stored before versioning is untagged phase0 metadata, and still decoded as version 1.
+func (mb *dsMetadataBook) loadMetadata(ctx context.Context, p peer.ID) (*types.VersionedMetaData, error) {
 	key := peerIdToKey(eth2Base, p).Child(metadataSuffix)
 	value, err := mb.ds.Get(ctx, key)
-	if err != nil {
+	if errors.Is(err, ds.ErrNotFound) {
+		return nil, fmt.Errorf("%w for peer %s", ErrNoMetadata, p.Pretty())
+	} else if err != nil {
 		return nil, fmt.Errorf("error while fetching metadata from datastore for peer %s: %s\n", p.Pretty(), err)
 	}
-	var md common.MetaData
-	if err := md.Deserialize(codec.NewDecodingReader(bytes.NewReader(value), uint64(len(value)))); err != nil {
-		return nil, fmt.Errorf("failed parse metadata bytes from datastore: %v", err)
+	md, err := types.DecodeTaggedMetaData(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed parse metadata bytes from datastore: %v", ErrCorruptData, err)
 	}
-	return &md, nil
+	return md, nil
 }
 
-func (mb *dsMetadataBook) storeMetadata(ctx context.Context, p peer.ID, md *common.MetaData) error {
+func (mb *dsMetadataBook) storeMetadata(ctx context.Context, w ds.Write, p peer.ID, md *types.VersionedMetaData) error {
 	key := peerIdToKey(eth2Base, p).Child(metadataSuffix)
-	size := md.FixedLength()
-	out := bytes.NewBuffer(make([]byte, 0, size))
-	if err := md.Serialize(codec.NewEncodingWriter(out)); err != nil {
+	value, err := md.EncodeTagged()
+	if err != nil {
 		return fmt.Errorf("failed encode metadata bytes for datastore: %v", err)
 	}
-	if err := mb.ds.Put(ctx, key, out.Bytes()); err != nil {
+	if err := w.Put(ctx, key, value); err != nil {
 		return fmt.Errorf("failed to store metadata: %v", err)
 	}
 	return nil
@@ -70,30 +89,80 @@ func (mb *dsMetadataBook) storeMetadata(ctx context.Context, p peer.ID, md *comm
 func (mb *dsMetadataBook) loadClaim(ctx context.Context, p peer.ID) (common.SeqNr, error) {
 	key := peerIdToKey(eth2Base, p).Child(claimSuffix)
 	value, err := mb.ds.Get(ctx, key)
-	if err != nil {
+	if errors.Is(err, ds.ErrNotFound) {
+		return 0, fmt.Errorf("%w for peer %s", ErrNoClaim, p.Pretty())
+	} else if err != nil {
 		return 0, fmt.Errorf("error while fetching claim seq nr from datastore for peer %s: %s\n", p.Pretty(), err)
 	}
+	if len(value) != 8 {
+		return 0, fmt.Errorf("%w: claim seq nr has wrong length: %d", ErrCorruptData, len(value))
+	}
 	claim := common.SeqNr(binary.LittleEndian.Uint64(value))
 	return claim, nil
 }
 
-func (mb *dsMetadataBook) storeClaim(ctx context.Context, p peer.ID, claim common.SeqNr) error {
+func (mb *dsMetadataBook) storeClaim(ctx context.Context, w ds.Write, p peer.ID, claim common.SeqNr) error {
 	key := peerIdToKey(eth2Base, p).Child(claimSuffix)
 	var dat [8]byte
 	binary.LittleEndian.PutUint64(dat[:], uint64(claim))
-	if err := mb.ds.Put(ctx, key, dat[:]); err != nil {
+	if err := w.Put(ctx, key, dat[:]); err != nil {
 		return fmt.Errorf("failed to store claim seq nr: %v", err)
 	}
 	return nil
 }
 
+// Metadata returns the phase0 view of the latest metadata, regardless of its version
 func (mb *dsMetadataBook) Metadata(ctx context.Context, id peer.ID) (*common.MetaData, error) {
+	mb.Lock()
+	defer mb.Unlock()
+	dat, err := mb.metadata(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	md := dat.V1()
+	return &md, nil
+}
+
+// VersionedMetadata returns the latest metadata, in the version it was registered with
+func (mb *dsMetadataBook) VersionedMetadata(ctx context.Context, id peer.ID) (*types.VersionedMetaData, error) {
 	mb.Lock()
 	defer mb.Unlock()
 	return mb.metadata(ctx, id)
 }
 
-func (mb *dsMetadataBook) metadata(ctx context.Context, id peer.ID) (*common.MetaData, error) {
+// MetadataV2 returns the Altair view of the latest metadata,
+// or ErrMetadataVersion if it was registered with version 1.
+func (mb *dsMetadataBook) MetadataV2(ctx context.Context, id peer.ID) (*types.MetaDataV2, error) {
+	mb.Lock()
+	defer mb.Unlock()
+	dat, err := mb.metadata(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	md, ok := dat.V2()
+	if !ok {
+		return nil, fmt.Errorf("%w: peer %s has metadata v%d", ErrMetadataVersion, id.Pretty(), dat.Version)
+	}
+	return &md, nil
+}
+
+// MetadataV3 returns the Fulu view of the latest metadata,
+// or ErrMetadataVersion if it was registered with version 1 or 2.
+func (mb *dsMetadataBook) MetadataV3(ctx context.Context, id peer.ID) (*types.MetaDataV3, error) {
+	mb.Lock()
+	defer mb.Unlock()
+	dat, err := mb.metadata(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	md, ok := dat.V3()
+	if !ok {
+		return nil, fmt.Errorf("%w: peer %s has metadata v%d", ErrMetadataVersion, id.Pretty(), dat.Version)
+	}
+	return &md, nil
+}
+
+func (mb *dsMetadataBook) metadata(ctx context.Context, id peer.ID) (*types.VersionedMetaData, error) {
 	dat, ok := mb.metadatas[id]
 	if !ok {
 		md, err := mb.loadMetadata(ctx, id)
@@ -132,8 +201,16 @@ func (mb *dsMetadataBook) RegisterSeqClaim(ctx context.Context, id peer.ID, seq
 	dat, err := mb.claimedSeq(ctx, id)
 	newer = err != nil || dat < seq
 	if newer {
+		err = writeBatch(ctx, mb.ds, func(w ds.Write) error {
+			if err := mb.storeClaim(ctx, w, id, seq); err != nil {
+				return err
+			}
+			return markUpdated(ctx, mb.ds, w, id, claimUpdatedSuffix, mb.clock())
+		})
+		if err != nil {
+			return false, err
+		}
 		mb.claims[id] = seq
-		err = mb.storeClaim(ctx, id, seq)
 	}
 	return
 }
@@ -151,43 +228,227 @@ func (mb *dsMetadataBook) RegisterMetaFetch(ctx context.Context, id peer.ID) (ui
 
 // RegisterMetadata updates metadata, if newer than previous. Resetting ongoing fetch counter if it's new enough
 func (mb *dsMetadataBook) RegisterMetadata(ctx context.Context, id peer.ID, md common.MetaData) (newer bool, err error) {
+	return mb.registerMetadata(ctx, id, types.MetaDataFromV1(md))
+}
+
+// RegisterMetadataV2 is RegisterMetadata for Altair metadata
+func (mb *dsMetadataBook) RegisterMetadataV2(ctx context.Context, id peer.ID, md types.MetaDataV2) (newer bool, err error) {
+	return mb.registerMetadata(ctx, id, types.MetaDataFromV2(md))
+}
+
+// RegisterMetadataV3 is RegisterMetadata for Fulu metadata
+func (mb *dsMetadataBook) RegisterMetadataV3(ctx context.Context, id peer.ID, md types.MetaDataV3) (newer bool, err error) {
+	return mb.registerMetadata(ctx, id, types.MetaDataFromV3(md))
+}
+
+// registerMetadata updates the metadata if it has a higher seq nr,
+// or the same seq nr with a higher version (the peer may serve multiple versions of the same metadata).
+func (mb *dsMetadataBook) registerMetadata(ctx context.Context, id peer.ID, md types.VersionedMetaData) (newer bool, err error) {
 	mb.Lock()
 	defer mb.Unlock()
 	dat, err := mb.metadata(ctx, id)
-	newer = dat == nil || err != nil || dat.SeqNumber < md.SeqNumber
+	newer = dat == nil || err != nil || dat.SeqNumber < md.SeqNumber ||
+		(dat.SeqNumber == md.SeqNumber && dat.Version < md.Version)
 	if newer {
 		// will 0 if no claim
 		claimed, _ := mb.claims[id]
+		var prevAttnets *common.AttnetBits
+		var prevSyncnets *types.SyncnetBits
+		if dat != nil {
+			prevAttnets = &dat.Attnets
+			prevSyncnets = dat.Syncnets
+		}
+		err := writeBatch(ctx, mb.ds, func(w ds.Write) error {
+			if err := mb.storeMetadata(ctx, w, id, &md); err != nil {
+				return err
+			}
+			now := mb.clock()
+			if err := markUpdated(ctx, mb.ds, w, id, metadataUpdatedSuffix, now); err != nil {
+				return err
+			}
+			if md.SeqNumber > claimed {
+				if err := mb.storeClaim(ctx, w, id, md.SeqNumber); err != nil {
+					return err
+				}
+				if err := markUpdated(ctx, mb.ds, w, id, claimUpdatedSuffix, now); err != nil {
+					return err
+				}
+			}
+			if err := updateAttnetIndex(ctx, w, id, indexSourceMetadata, prevAttnets, &md.Attnets); err != nil {
+				return err
+			}
+			return updateSyncnetIndex(ctx, w, id, indexSourceMetadata, prevSyncnets, md.Syncnets)
+		})
+		if err != nil {
+			return false, err
+		}
+		// only cache it once stored, to keep the cache consistent with the indexes
 		if md.SeqNumber >= claimed {
 			// if it is newer or equal to best, we can reset the ongoing fetches
 			mb.fetches[id] = 0
 		}
 		mb.metadatas[id] = md
-		err := mb.storeMetadata(ctx, id, &md)
-		if err != nil {
-			return true, err
-		}
 		if md.SeqNumber > claimed {
 			mb.claims[id] = md.SeqNumber
-			err := mb.storeClaim(ctx, id, md.SeqNumber)
-			return true, err
 		}
+		return true, nil
 	}
 	return
 }
 
+// migrateMetadataBatch bounds the number of entries MigrateMetadata rewrites per batch
+const migrateMetadataBatch = 1000
+
+// keySuffixFilter selects the entries with a key that ends with the suffix key
+type keySuffixFilter struct {
+	suffix ds.Key
+}
+
+func (f keySuffixFilter) Filter(e query.Entry) bool {
+	return strings.HasSuffix(e.Key, f.suffix.String())
+}
+
+// MigrateMetadata rewrites metadata stored before versioning into the tagged format,
+// returning how many entries were migrated. Untagged metadata can still be read without migration.
+// Only the metadata keys are queried, and the entries are migrated in bounded batches:
+// if a batch fails, the entries of earlier batches stay migrated.
+func (mb *dsMetadataBook) MigrateMetadata(ctx context.Context) (migrated int, err error) {
+	return mb.migrateMetadata(ctx, migrateMetadataBatch)
+}
+
+func (mb *dsMetadataBook) migrateMetadata(ctx context.Context, batchSize int) (migrated int, err error) {
+	mb.Lock()
+	defer mb.Unlock()
+	res, err := mb.ds.Query(ctx, query.Query{
+		Prefix:   eth2Base.String(),
+		KeysOnly: true,
+		Filters:  []query.Filter{keySuffixFilter{metadataSuffix}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer res.Close()
+	pending := make(map[ds.Key][]byte)
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		if err := writeBatch(ctx, mb.ds, func(w ds.Write) error {
+			for key, value := range pending {
+				if err := w.Put(ctx, key, value); err != nil {
+					return fmt.Errorf("failed to store migrated metadata: %v", err)
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		migrated += len(pending)
+		pending = make(map[ds.Key][]byte)
+		return nil
+	}
+	for e := range res.Next() {
+		if e.Error != nil {
+			return migrated, e.Error
+		}
+		key := ds.RawKey(e.Key)
+		value, err := mb.ds.Get(ctx, key)
+		if errors.Is(err, ds.ErrNotFound) {
+			continue
+		} else if err != nil {
+			return migrated, fmt.Errorf("failed to read metadata %s: %w", key, err)
+		}
+		if len(value) != common.MetadataByteLen {
+			continue
+		}
+		md, err := types.DecodeTaggedMetaData(value)
+		if err != nil {
+			return migrated, fmt.Errorf("%w: failed to migrate metadata %s: %v", ErrCorruptData, key, err)
+		}
+		if pending[key], err = md.EncodeTagged(); err != nil {
+			return migrated, err
+		}
+		if len(pending) >= batchSize {
+			if err := flush(); err != nil {
+				return migrated, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return migrated, err
+	}
+	return migrated, nil
+}
+
+// MetadataUpdated returns when newer metadata of the peer was last registered
+func (mb *dsMetadataBook) MetadataUpdated(ctx context.Context, id peer.ID) (time.Time, error) {
+	return loadTime(ctx, mb.ds, id, metadataUpdatedSuffix)
+}
+
+// ClaimUpdated returns when a higher seq nr claim of the peer was last registered
+func (mb *dsMetadataBook) ClaimUpdated(ctx context.Context, id peer.ID) (time.Time, error) {
+	return loadTime(ctx, mb.ds, id, claimUpdatedSuffix)
+}
+
+// RemoveMetadata removes the metadata, claimed seq nr and fetch counter of the peer, if any
+func (mb *dsMetadataBook) RemoveMetadata(ctx context.Context, id peer.ID) error {
+	mb.Lock()
+	defer mb.Unlock()
+	if err := writeBatch(ctx, mb.ds, func(w ds.Write) error {
+		return mb.removeMetadata(ctx, w, id)
+	}); err != nil {
+		return err
+	}
+	mb.forget(id)
+	return nil
+}
+
+// removeMetadata writes the removal of the metadata and claim of the peer, the lock must be held.
+// The cached data must be dropped with forget once the write is committed.
+func (mb *dsMetadataBook) removeMetadata(ctx context.Context, w ds.Write, id peer.ID) error {
+	var prevAttnets *common.AttnetBits
+	var prevSyncnets *types.SyncnetBits
+	if dat, err := mb.metadata(ctx, id); err == nil {
+		prevAttnets = &dat.Attnets
+		prevSyncnets = dat.Syncnets
+	}
+	if err := w.Delete(ctx, peerIdToKey(eth2Base, id).Child(metadataSuffix)); err != nil {
+		return fmt.Errorf("failed to remove metadata: %v", err)
+	}
+	if err := w.Delete(ctx, peerIdToKey(eth2Base, id).Child(claimSuffix)); err != nil {
+		return fmt.Errorf("failed to remove claim seq nr: %v", err)
+	}
+	if err := removeTimes(ctx, w, id, metadataUpdatedSuffix, claimUpdatedSuffix); err != nil {
+		return err
+	}
+	if err := removePeerTimesIfEmpty(ctx, mb.ds, w, id, metadataSuffix, claimSuffix); err != nil {
+		return err
+	}
+	if err := updateAttnetIndex(ctx, w, id, indexSourceMetadata, prevAttnets, nil); err != nil {
+		return err
+	}
+	return updateSyncnetIndex(ctx, w, id, indexSourceMetadata, prevSyncnets, nil)
+}
+
+// forget drops the cached data of the peer, the lock must be held.
+func (mb *dsMetadataBook) forget(id peer.ID) {
+	delete(mb.metadatas, id)
+	delete(mb.claims, id)
+	delete(mb.fetches, id)
+}
+
 func (mb *dsMetadataBook) flush(ctx context.Context) error {
 	mb.RLock()
 	defer mb.RUnlock()
 	// store all claims to datastore before exiting
 	for id, cl := range mb.claims {
-		if err := mb.storeClaim(ctx, id, cl); err != nil {
+		if err := mb.storeClaim(ctx, mb.ds, id, cl); err != nil {
 			return err
 		}
 	}
 	// store all metadatas to datastore before exiting
 	for id, md := range mb.metadatas {
-		if err := mb.storeMetadata(ctx, id, &md); err != nil {
+		if err := mb.storeMetadata(ctx, mb.ds, id, &md); err != nil {
 			return err
 		}
 	}
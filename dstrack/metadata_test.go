@@ -0,0 +1,110 @@
+package dstrack
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/protolambda/go-eth2-peerstore/types"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/ztyp/codec"
+)
+
+func TestMigrateMetadata(t *testing.T) {
+	untagged := func(md common.MetaData) []byte {
+		var buf bytes.Buffer
+		if err := md.Serialize(codec.NewEncodingWriter(&buf)); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	tagged := func(md types.VersionedMetaData) []byte {
+		data, err := md.EncodeTagged()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	v1 := common.MetaData{SeqNumber: 2, Attnets: common.AttnetBits{0x03}}
+	v2 := types.MetaDataV2{SeqNumber: 7, Attnets: common.AttnetBits{0x0c}, Syncnets: types.SyncnetBits{0x01}}
+	cases := []struct {
+		name     string
+		stored   []byte
+		migrated bool
+		expected types.VersionedMetaData
+	}{
+		{"untagged v1", untagged(v1), true, types.MetaDataFromV1(v1)},
+		{"untagged empty v1", untagged(common.MetaData{}), true, types.MetaDataFromV1(common.MetaData{})},
+		{"tagged v1", tagged(types.MetaDataFromV1(v1)), false, types.MetaDataFromV1(v1)},
+		{"tagged v2", tagged(types.MetaDataFromV2(v2)), false, types.MetaDataFromV2(v2)},
+	}
+	for _, batch := range []int{1, 2, migrateMetadataBatch} {
+		t.Run(fmt.Sprintf("batch %d", batch), func(t *testing.T) {
+			ctx := context.Background()
+			store := dssync.MutexWrap(ds.NewMapDatastore())
+			ids := make([]peer.ID, len(cases))
+			expectMigrated := 0
+			for i, c := range cases {
+				ids[i] = peer.ID(c.name)
+				if err := store.Put(ctx, peerIdToKey(eth2Base, ids[i]).Child(metadataSuffix), c.stored); err != nil {
+					t.Fatal(err)
+				}
+				if c.migrated {
+					expectMigrated++
+				}
+			}
+			// other peer data of the same length as untagged metadata is not migrated
+			other := peerIdToKey(eth2Base, "other").ChildString("metadata_other")
+			if err := store.Put(ctx, other, untagged(v1)); err != nil {
+				t.Fatal(err)
+			}
+
+			mb, err := NewMetadataBook(store)
+			if err != nil {
+				t.Fatal(err)
+			}
+			migrated, err := mb.migrateMetadata(ctx, batch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if migrated != expectMigrated {
+				t.Fatalf("migrated %d entries, expected %d", migrated, expectMigrated)
+			}
+			for i, c := range cases {
+				t.Run(c.name, func(t *testing.T) {
+					data, err := store.Get(ctx, peerIdToKey(eth2Base, ids[i]).Child(metadataSuffix))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(data, tagged(c.expected)) {
+						t.Fatalf("stored %x, expected tagged %x", data, tagged(c.expected))
+					}
+					md, err := mb.VersionedMetadata(ctx, ids[i])
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !reflect.DeepEqual(*md, c.expected) {
+						t.Fatalf("got %v, expected %v", md, c.expected)
+					}
+				})
+			}
+			if data, err := store.Get(ctx, other); err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(data, untagged(v1)) {
+				t.Fatalf("unrelated key was changed: %x", data)
+			}
+			migrated, err = mb.migrateMetadata(ctx, batch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if migrated != 0 {
+				t.Fatalf("migrated %d entries again, expected none", migrated)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package dstrack
+
+import (
+	"github.com/protolambda/go-eth2-peerstore"
+	"time"
+)
+
+type options struct {
+	gc            GCOptions
+	clock         Clock
+	statusHistory historyLimits
+	enrHistory    historyLimits
+	lenientENRs   bool
+	verifyENRs    bool
+	classifier    *eth2peerstore.StatusClassifier
+}
+
+// Option configures the extended peerstore
+type Option func(o *options)
+
+// WithGC enables the background removal of stale peers.
+func WithGC(gc GCOptions) Option {
+	return func(o *options) {
+		o.gc = gc
+	}
+}
+
+// WithClock overrides the clock used to timestamp updates with, e.g. for testing.
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+// WithStatusHistory keeps up to depth of the latest statuses of each peer, not older than maxAge.
+// Either limit is disabled if 0, history is not kept if both are 0.
+func WithStatusHistory(depth int, maxAge time.Duration) Option {
+	return func(o *options) {
+		o.statusHistory = historyLimits{depth: depth, maxAge: maxAge}
+	}
+}
+
+// WithENRHistory keeps up to depth of the latest ENRs of each peer, not older than maxAge.
+// Either limit is disabled if 0, history is not kept if both are 0.
+func WithENRHistory(depth int, maxAge time.Duration) Option {
+	return func(o *options) {
+		o.enrHistory = historyLimits{depth: depth, maxAge: maxAge}
+	}
+}
+
+// WithLenientENRs accepts and loads ENRs without verifying their signature,
+// and without checking that they belong to the peer they are registered for.
+func WithLenientENRs() Option {
+	return func(o *options) {
+		o.lenientENRs = true
+	}
+}
+
+// WithENRVerificationOnLoad verifies stored ENRs again when they are loaded, not only when they are registered.
+// ENRs that fail verification are then reported as ErrCorruptData. Ignored if ENRs are lenient.
+func WithENRVerificationOnLoad() Option {
+	return func(o *options) {
+		o.verifyENRs = true
+	}
+}
+
+// WithStatusClassifier labels every registered status with the classifier, see StatusRelevance.
+// Without classifier, the label of a peer is dropped when a new status is registered.
+func WithStatusClassifier(c *eth2peerstore.StatusClassifier) Option {
+	return func(o *options) {
+		o.classifier = c
+	}
+}
@@ -0,0 +1,42 @@
+package dstrack
+
+import (
+	"context"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/protolambda/go-eth2-peerstore"
+	"github.com/protolambda/go-eth2-peerstore/peerdas"
+	"sort"
+)
+
+var _ eth2peerstore.PeerQuerier = (*dsExtendedPeerstore)(nil)
+
+func (ep *dsExtendedPeerstore) QueryPeers(ctx context.Context, q eth2peerstore.PeerQuery) ([]peer.ID, error) {
+	var matches []*eth2peerstore.PeerAllData
+	_, err := ep.IteratePeers(ctx, "", func(data *eth2peerstore.PeerAllData) bool {
+		if q.Filter == nil || q.Filter(data) {
+			matches = append(matches, data)
+		}
+		// without sorting, the first matches are the final results
+		return q.Order != nil || q.Limit <= 0 || len(matches) < q.Limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	if q.Order != nil {
+		sort.SliceStable(matches, func(i, j int) bool {
+			return q.Order(matches[i], matches[j])
+		})
+	}
+	if q.Limit > 0 && len(matches) > q.Limit {
+		matches = matches[:q.Limit]
+	}
+	out := make([]peer.ID, 0, len(matches))
+	for _, m := range matches {
+		out = append(out, m.PeerID)
+	}
+	return out, nil
+}
+
+func (ep *dsExtendedPeerstore) PeersCustodyingColumn(ctx context.Context, column peerdas.ColumnIndex) ([]peer.ID, error) {
+	return ep.QueryPeers(ctx, eth2peerstore.PeerQuery{Filter: eth2peerstore.CustodiesColumn(column)})
+}
@@ -0,0 +1,94 @@
+package dstrack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/protolambda/go-eth2-peerstore"
+)
+
+// the relevance label of the latest status is stored next to it, as a single byte
+var statusRelevanceSuffix = ds.NewKey("/status_relevance")
+
+// ErrNoStatusRelevance is returned when the latest status of the peer was not classified
+var ErrNoStatusRelevance = errors.New("no status relevance known")
+
+var _ eth2peerstore.StatusRelevanceBook = (*dsExtendedPeerstore)(nil)
+
+func (sb *dsStatusBook) StatusRelevance(ctx context.Context, id peer.ID) (eth2peerstore.Relevance, error) {
+	value, err := sb.ds.Get(ctx, peerIdToKey(eth2Base, id).Child(statusRelevanceSuffix))
+	if errors.Is(err, ds.ErrNotFound) {
+		return eth2peerstore.RelevanceUnknown, fmt.Errorf("%w for peer %s", ErrNoStatusRelevance, id.Pretty())
+	} else if err != nil {
+		return eth2peerstore.RelevanceUnknown, fmt.Errorf("failed to get status relevance: %w", err)
+	}
+	if len(value) != 1 {
+		return eth2peerstore.RelevanceUnknown, fmt.Errorf("%w: status relevance has wrong length: %d", ErrCorruptData, len(value))
+	}
+	return eth2peerstore.Relevance(value[0]), nil
+}
+
+func storeRelevance(ctx context.Context, w ds.Write, id peer.ID, r eth2peerstore.Relevance) error {
+	if err := w.Put(ctx, peerIdToKey(eth2Base, id).Child(statusRelevanceSuffix), []byte{byte(r)}); err != nil {
+		return fmt.Errorf("failed to store status relevance: %v", err)
+	}
+	return nil
+}
+
+func removeRelevance(ctx context.Context, w ds.Write, id peer.ID) error {
+	if err := w.Delete(ctx, peerIdToKey(eth2Base, id).Child(statusRelevanceSuffix)); err != nil {
+		return fmt.Errorf("failed to remove status relevance: %v", err)
+	}
+	return nil
+}
+
+func (sb *dsStatusBook) classifyStatus(ctx context.Context, w ds.Write, id peer.ID, c *eth2peerstore.StatusClassifier) (eth2peerstore.Relevance, error) {
+	status, err := sb.Status(ctx, id)
+	if err != nil {
+		return eth2peerstore.RelevanceUnknown, err
+	}
+	r := c.Classify(status)
+	return r, storeRelevance(ctx, w, id, r)
+}
+
+func (sb *dsStatusBook) ClassifyStatus(ctx context.Context, id peer.ID, c *eth2peerstore.StatusClassifier) (eth2peerstore.Relevance, error) {
+	sb.updateLock.Lock()
+	defer sb.updateLock.Unlock()
+	var r eth2peerstore.Relevance
+	err := writeBatch(ctx, sb.ds, func(w ds.Write) (err error) {
+		r, err = sb.classifyStatus(ctx, w, id, c)
+		return err
+	})
+	return r, err
+}
+
+// ClassifyStatuses labels the status of every eth2 peer in a single batch. Peers without status are skipped.
+func (ep *dsExtendedPeerstore) ClassifyStatuses(ctx context.Context, c *eth2peerstore.StatusClassifier) (map[eth2peerstore.Relevance]int, error) {
+	ep.dsStatusBook.updateLock.Lock()
+	defer ep.dsStatusBook.updateLock.Unlock()
+	counts := make(map[eth2peerstore.Relevance]int)
+	err := writeBatch(ctx, ep.store, func(w ds.Write) error {
+		var classifyErr error
+		_, err := scanPeers(ctx, ep.store, eth2Base, "", func(id peer.ID, cursor eth2peerstore.PeerCursor) bool {
+			r, err := ep.classifyStatus(ctx, w, id, c)
+			if errors.Is(err, ErrNoStatus) {
+				return true
+			} else if err != nil {
+				classifyErr = fmt.Errorf("failed to classify status of peer %s: %w", id.Pretty(), err)
+				return false
+			}
+			counts[r] += 1
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		return classifyErr
+	})
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
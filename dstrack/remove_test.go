@@ -0,0 +1,110 @@
+package dstrack
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+func TestRemoveBooks(t *testing.T) {
+	ctx := context.Background()
+	cases := []struct {
+		name string
+		// removals of single books
+		remove []string
+		// whether the peer is still enumerated, with its peer-level times, afterwards
+		listed bool
+	}{
+		{"nothing", nil, true},
+		{"status", []string{"status"}, true},
+		{"status and metadata", []string{"status", "metadata"}, true},
+		{"all books", []string{"status", "metadata", "enr"}, false},
+		{"all books, other order", []string{"enr", "metadata", "status"}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ep := newTestPeerstore(t)
+			id, k := newTestPeerWithKey(t)
+			if err := ep.RegisterStatus(ctx, id, common.Status{HeadSlot: 1}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ep.RegisterMetadata(ctx, id, common.MetaData{SeqNumber: 1}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ep.UpdateENRMaybe(ctx, id, testENR(t, k, 1, common.AttnetBits{}, common.ForkDigest{})); err != nil {
+				t.Fatal(err)
+			}
+			for _, book := range c.remove {
+				var err error
+				switch book {
+				case "status":
+					err = ep.RemoveStatus(ctx, id)
+				case "metadata":
+					err = ep.RemoveMetadata(ctx, id)
+				case "enr":
+					err = ep.RemoveENR(ctx, id)
+				}
+				if err != nil {
+					t.Fatalf("remove %s: %v", book, err)
+				}
+			}
+			ids, _, err := ep.Eth2Peers(ctx, "", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if listed := len(ids) == 1 && ids[0] == id; listed != c.listed {
+				t.Fatalf("got listed %v, expected %v", listed, c.listed)
+			}
+			for _, get := range []func(context.Context, peer.ID) (time.Time, error){ep.FirstSeen, ep.LastUpdated} {
+				if _, err := get(ctx, id); c.listed == errors.Is(err, ErrNoTime) {
+					t.Fatalf("got peer-level time error %v, expected times to be kept: %v", err, c.listed)
+				}
+			}
+		})
+	}
+}
+
+func TestRemovePeerData(t *testing.T) {
+	ctx := context.Background()
+	ep := newTestPeerstore(t)
+	id, k := newTestPeerWithKey(t)
+	if _, _, err := ep.AddENR(ctx, testENR(t, k, 1, common.AttnetBits{0b1}, common.ForkDigest{}), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := ep.RegisterStatus(ctx, id, common.Status{HeadSlot: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ep.Put(id, "AgentVersion", "test/v1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ep.AddProtocols(id, "/test/1"); err != nil {
+		t.Fatal(err)
+	}
+	ep.RecordLatency(id, time.Millisecond)
+
+	if err := ep.RemovePeerData(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if ids, _, err := ep.Eth2Peers(ctx, "", 0); err != nil || len(ids) != 0 {
+		t.Fatalf("peer is still enumerated: %v (err: %v)", ids, err)
+	}
+	if _, err := ep.Status(ctx, id); !errors.Is(err, ErrNoStatus) {
+		t.Fatalf("got status error %v, expected %v", err, ErrNoStatus)
+	}
+	if _, err := ep.LatestENR(ctx, id); !errors.Is(err, ErrNoENR) {
+		t.Fatalf("got ENR error %v, expected %v", err, ErrNoENR)
+	}
+	if _, err := ep.Get(id, "AgentVersion"); err == nil {
+		t.Fatal("libp2p metadata of the peer was not removed")
+	}
+	if protos, err := ep.GetProtocols(id); err != nil || len(protos) != 0 {
+		t.Fatalf("protocols of the peer were not removed: %v (err: %v)", protos, err)
+	}
+	if ep.LatencyEWMA(id) != 0 {
+		t.Fatal("latency metrics of the peer were not removed")
+	}
+}
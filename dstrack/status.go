@@ -1,76 +1,231 @@
 package dstrack
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	ds "github.com/ipfs/go-datastore"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/protolambda/go-eth2-peerstore"
+	"github.com/protolambda/go-eth2-peerstore/types"
 	"github.com/protolambda/zrnt/eth2/beacon/common"
-	"github.com/protolambda/ztyp/codec"
 	"sync"
+	"time"
 )
 
-var statusSuffix = ds.NewKey("/status")
+var (
+	statusSuffix        = ds.NewKey("/status")
+	statusHistorySuffix = ds.NewKey("/status_history")
+)
+
+var (
+	// ErrNoStatus is returned when no status was ever registered for the peer
+	ErrNoStatus = errors.New("no status known")
+	// ErrStatusVersion is returned when the known status of the peer is older than the requested version
+	ErrStatusVersion = errors.New("status version too old")
+)
 
 type dsStatusBook struct {
 	ds ds.Datastore
 	// cache status objects to not load/store them all the time
 	data sync.Map
+	// timestamps updates
+	clock Clock
+	// bounds the status history, disabled by default
+	history historyLimits
+	// serializes the read-modify-write of status updates and removals, to keep the indexes consistent
+	updateLock sync.Mutex
+	// classifies statuses on registration, if not nil
+	classifier *eth2peerstore.StatusClassifier
 }
 
 var _ eth2peerstore.StatusBook = (*dsStatusBook)(nil)
+var _ eth2peerstore.VersionedStatusBook = (*dsStatusBook)(nil)
 
 func NewStatusBook(store ds.Datastore) (*dsStatusBook, error) {
-	return &dsStatusBook{ds: store}, nil
+	return &dsStatusBook{ds: store, clock: time.Now}, nil
 }
 
-func (sb *dsStatusBook) loadStatus(ctx context.Context, p peer.ID) (*common.Status, error) {
+// statuses are stored with a version byte prefix, see types.VersionedStatus.EncodeTagged.
+// Statuses stored before versioning are untagged phase0 statuses, and still decoded as version 1.
+func (sb *dsStatusBook) loadStatus(ctx context.Context, p peer.ID) (*types.VersionedStatus, error) {
 	key := peerIdToKey(eth2Base, p).Child(statusSuffix)
 	value, err := sb.ds.Get(ctx, key)
-	if err != nil {
+	if errors.Is(err, ds.ErrNotFound) {
+		return nil, fmt.Errorf("%w for peer %s", ErrNoStatus, p.Pretty())
+	} else if err != nil {
 		return nil, fmt.Errorf("error while fetching status from datastore for peer %s: %s\n", p.Pretty(), err)
 	}
-	var status common.Status
-	if err := status.Deserialize(codec.NewDecodingReader(bytes.NewReader(value), uint64(len(value)))); err != nil {
-		return nil, fmt.Errorf("failed parse status bytes from datastore: %v", err)
+	status, err := types.DecodeTaggedStatus(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed parse status bytes from datastore: %v", ErrCorruptData, err)
 	}
 	// cache it
-	sb.data.Store(p, &status)
-	return &status, nil
+	sb.data.Store(p, status)
+	return status, nil
 }
 
-func (sb *dsStatusBook) storeStatus(ctx context.Context, p peer.ID, st *common.Status) error {
+func (sb *dsStatusBook) storeStatus(ctx context.Context, w ds.Write, p peer.ID, st *types.VersionedStatus) error {
 	key := peerIdToKey(eth2Base, p).Child(statusSuffix)
-	size := st.FixedLength()
-	out := bytes.NewBuffer(make([]byte, 0, size))
-	if err := st.Serialize(codec.NewEncodingWriter(out)); err != nil {
+	value, err := st.EncodeTagged()
+	if err != nil {
 		return fmt.Errorf("failed encode status bytes for datastore: %v", err)
 	}
-	if err := sb.ds.Put(ctx, key, out.Bytes()); err != nil {
+	if err := w.Put(ctx, key, value); err != nil {
 		return fmt.Errorf("failed to store status: %v", err)
 	}
 	return nil
 }
 
+// Status returns the phase0 view of the latest status, regardless of its version
 func (sb *dsStatusBook) Status(ctx context.Context, id peer.ID) (*common.Status, error) {
+	dat, err := sb.VersionedStatus(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	st := dat.V1()
+	return &st, nil
+}
+
+// VersionedStatus returns the latest status, in the version it was registered with
+func (sb *dsStatusBook) VersionedStatus(ctx context.Context, id peer.ID) (*types.VersionedStatus, error) {
 	dat, loaded := sb.data.Load(id)
 	if loaded {
-		return dat.(*common.Status), nil
+		return dat.(*types.VersionedStatus), nil
 	} else {
 		// lazy-load status into the db
 		return sb.loadStatus(ctx, id)
 	}
 }
 
-// TODO: option to remove Status from the DB?
+// StatusV2 returns the Fulu view of the latest status, or ErrStatusVersion if it was registered with version 1.
+func (sb *dsStatusBook) StatusV2(ctx context.Context, id peer.ID) (*types.StatusV2, error) {
+	dat, err := sb.VersionedStatus(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	st, ok := dat.V2()
+	if !ok {
+		return nil, fmt.Errorf("%w: peer %s has status v%d", ErrStatusVersion, id.Pretty(), dat.Version)
+	}
+	return &st, nil
+}
 
 // RegisterStatus updates latest peer status
 func (sb *dsStatusBook) RegisterStatus(ctx context.Context, id peer.ID, st common.Status) error {
+	return sb.registerStatus(ctx, id, types.StatusFromV1(st))
+}
+
+// RegisterStatusV2 is RegisterStatus for Fulu statuses
+func (sb *dsStatusBook) RegisterStatusV2(ctx context.Context, id peer.ID, st types.StatusV2) error {
+	return sb.registerStatus(ctx, id, types.StatusFromV2(st))
+}
+
+func (sb *dsStatusBook) registerStatus(ctx context.Context, id peer.ID, st types.VersionedStatus) error {
+	sb.updateLock.Lock()
+	defer sb.updateLock.Unlock()
+	var prevDigest *common.ForkDigest
+	if prev, err := sb.VersionedStatus(ctx, id); err == nil {
+		prevDigest = &prev.ForkDigest
+	}
+	// Persist it to the store, together with the index changes,
+	// and only cache it once stored, to keep the cache consistent with the indexes
+	err := writeBatch(ctx, sb.ds, func(w ds.Write) error {
+		if err := sb.storeStatus(ctx, w, id, &st); err != nil {
+			return err
+		}
+		now := sb.clock()
+		if err := markUpdated(ctx, sb.ds, w, id, statusUpdatedSuffix, now); err != nil {
+			return err
+		}
+		if sb.history.enabled() {
+			data, err := st.EncodeTagged()
+			if err != nil {
+				return fmt.Errorf("failed encode status bytes for history: %v", err)
+			}
+			key := peerIdToKey(eth2Base, id).Child(statusHistorySuffix)
+			if err := appendHistory(ctx, sb.ds, w, key, sb.history, historyEntry{time: now, data: data}); err != nil {
+				return err
+			}
+		}
+		// the label of the previous status does not apply to the new status
+		if sb.classifier != nil {
+			if err := storeRelevance(ctx, w, id, sb.classifier.Classify(&st.Status)); err != nil {
+				return err
+			}
+		} else if err := removeRelevance(ctx, w, id); err != nil {
+			return err
+		}
+		return updateDigestIndex(ctx, w, id, indexSourceStatus, prevDigest, &st.ForkDigest)
+	})
+	if err != nil {
+		return err
+	}
 	sb.data.Store(id, &st)
-	// Try persist it to the store
-	return sb.storeStatus(ctx, id, &st)
+	return nil
+}
+
+// StatusUpdated returns when the status of the peer was last registered
+func (sb *dsStatusBook) StatusUpdated(ctx context.Context, id peer.ID) (time.Time, error) {
+	return loadTime(ctx, sb.ds, id, statusUpdatedSuffix)
+}
+
+// StatusHistory returns up to limit of the latest statuses of the peer, newest first. No limit if limit <= 0.
+// The history is empty if it is not enabled.
+func (sb *dsStatusBook) StatusHistory(ctx context.Context, id peer.ID, limit int) ([]eth2peerstore.StatusSnapshot, error) {
+	entries, err := loadHistory(ctx, sb.ds, peerIdToKey(eth2Base, id).Child(statusHistorySuffix))
+	if err != nil {
+		return nil, err
+	}
+	entries = latestHistory(sb.history.trim(entries, sb.clock()), limit)
+	out := make([]eth2peerstore.StatusSnapshot, 0, len(entries))
+	for _, e := range entries {
+		// history entries written before versioning are untagged, and decoded as version 1
+		st, err := types.DecodeTaggedStatus(e.data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed parse status history bytes from datastore: %v", ErrCorruptData, err)
+		}
+		out = append(out, eth2peerstore.StatusSnapshot{Time: e.time, Status: st.V1(), EarliestAvailableSlot: st.EarliestAvailableSlot})
+	}
+	return out, nil
+}
+
+// RemoveStatus removes the status of the peer, if any
+func (sb *dsStatusBook) RemoveStatus(ctx context.Context, id peer.ID) error {
+	sb.updateLock.Lock()
+	defer sb.updateLock.Unlock()
+	if err := writeBatch(ctx, sb.ds, func(w ds.Write) error {
+		return sb.removeStatus(ctx, w, id)
+	}); err != nil {
+		return err
+	}
+	sb.data.Delete(id)
+	return nil
+}
+
+// removeStatus writes the removal of the status of the peer.
+// The cached status must be dropped once the write is committed.
+func (sb *dsStatusBook) removeStatus(ctx context.Context, w ds.Write, id peer.ID) error {
+	var prevDigest *common.ForkDigest
+	if prev, err := sb.VersionedStatus(ctx, id); err == nil {
+		prevDigest = &prev.ForkDigest
+	}
+	if err := w.Delete(ctx, peerIdToKey(eth2Base, id).Child(statusSuffix)); err != nil {
+		return fmt.Errorf("failed to remove status: %v", err)
+	}
+	if err := w.Delete(ctx, peerIdToKey(eth2Base, id).Child(statusHistorySuffix)); err != nil {
+		return fmt.Errorf("failed to remove status history: %v", err)
+	}
+	if err := removeRelevance(ctx, w, id); err != nil {
+		return err
+	}
+	if err := removeTimes(ctx, w, id, statusUpdatedSuffix); err != nil {
+		return err
+	}
+	if err := removePeerTimesIfEmpty(ctx, sb.ds, w, id, statusSuffix); err != nil {
+		return err
+	}
+	return updateDigestIndex(ctx, w, id, indexSourceStatus, prevDigest, nil)
 }
 
 func (sb *dsStatusBook) flush(ctx context.Context) error {
@@ -78,8 +233,8 @@ func (sb *dsStatusBook) flush(ctx context.Context) error {
 	// store all statuses to datastore before exiting
 	sb.data.Range(func(key, value interface{}) bool {
 		id := key.(peer.ID)
-		st := value.(*common.Status)
-		if err := sb.storeStatus(ctx, id, st); err != nil {
+		st := value.(*types.VersionedStatus)
+		if err := sb.storeStatus(ctx, sb.ds, id, st); err != nil {
 			clErr = err
 			return false
 		}
@@ -0,0 +1,138 @@
+package dstrack
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/protolambda/go-eth2-peerstore"
+	"time"
+)
+
+// Update times are stored next to the data, as little-endian uint64 unix milliseconds:
+//
+//	/eth2/<peer id>/first_seen               <- first time any eth2 data of the peer was stored
+//	/eth2/<peer id>/updated                  <- last time any eth2 data of the peer was updated
+//	/eth2/<peer id>/<data suffix>_updated    <- last time the specific data was updated, e.g. status_updated
+var (
+	firstSeenSuffix       = ds.NewKey("/first_seen")
+	updatedSuffix         = ds.NewKey("/updated")
+	statusUpdatedSuffix   = ds.NewKey("/status_updated")
+	metadataUpdatedSuffix = ds.NewKey("/metadata_updated")
+	claimUpdatedSuffix    = ds.NewKey("/metadata_claim_updated")
+	enrUpdatedSuffix      = ds.NewKey("/enr_updated")
+)
+
+// ErrNoTime is returned when the time of an event was not recorded,
+// e.g. for data stored by older versions of the peerstore.
+var ErrNoTime = errors.New("no time known")
+
+// Clock provides the current time, to timestamp updates with.
+type Clock func() time.Time
+
+// Times are stored as unix milliseconds
+func timeToMs(t time.Time) uint64 {
+	return uint64(t.UnixNano() / int64(time.Millisecond))
+}
+
+func msToTime(v uint64) time.Time {
+	ms := int64(v)
+	return time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond))
+}
+
+func encodeTime(t time.Time) []byte {
+	var dat [8]byte
+	binary.LittleEndian.PutUint64(dat[:], timeToMs(t))
+	return dat[:]
+}
+
+func decodeTime(v []byte) (time.Time, error) {
+	if len(v) != 8 {
+		return time.Time{}, fmt.Errorf("%w: time has wrong length: %d", ErrCorruptData, len(v))
+	}
+	return msToTime(binary.LittleEndian.Uint64(v)), nil
+}
+
+func loadTime(ctx context.Context, store ds.Read, id peer.ID, suffix ds.Key) (time.Time, error) {
+	value, err := store.Get(ctx, peerIdToKey(eth2Base, id).Child(suffix))
+	if errors.Is(err, ds.ErrNotFound) {
+		return time.Time{}, fmt.Errorf("%w for %s of peer %s", ErrNoTime, suffix.Name(), id.Pretty())
+	} else if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get %s time: %w", suffix.Name(), err)
+	}
+	return decodeTime(value)
+}
+
+// markUpdated records the update time of the data with the given suffix,
+// as well as the last-updated and (if not yet known) first-seen time of the peer.
+func markUpdated(ctx context.Context, store ds.Read, w ds.Write, id peer.ID, suffix ds.Key, t time.Time) error {
+	base := peerIdToKey(eth2Base, id)
+	dat := encodeTime(t)
+	if err := w.Put(ctx, base.Child(suffix), dat); err != nil {
+		return fmt.Errorf("failed to store %s time: %v", suffix.Name(), err)
+	}
+	if err := w.Put(ctx, base.Child(updatedSuffix), dat); err != nil {
+		return fmt.Errorf("failed to store update time: %v", err)
+	}
+	seen, err := store.Has(ctx, base.Child(firstSeenSuffix))
+	if err != nil {
+		return fmt.Errorf("failed to check first seen time: %v", err)
+	}
+	if !seen {
+		if err := w.Put(ctx, base.Child(firstSeenSuffix), dat); err != nil {
+			return fmt.Errorf("failed to store first seen time: %v", err)
+		}
+	}
+	return nil
+}
+
+func removeTimes(ctx context.Context, w ds.Write, id peer.ID, suffixes ...ds.Key) error {
+	base := peerIdToKey(eth2Base, id)
+	for _, suffix := range suffixes {
+		if err := w.Delete(ctx, base.Child(suffix)); err != nil {
+			return fmt.Errorf("failed to remove %s time: %v", suffix.Name(), err)
+		}
+	}
+	return nil
+}
+
+// bookDataSuffixes are the keys of the data of the books. The peer-level times are kept while any is stored.
+var bookDataSuffixes = []ds.Key{statusSuffix, metadataSuffix, claimSuffix, enrSuffix}
+
+// removePeerTimesIfEmpty removes the first-seen and last-updated times of the peer,
+// if no book data is stored besides the data that is removed.
+func removePeerTimesIfEmpty(ctx context.Context, store ds.Read, w ds.Write, id peer.ID, removed ...ds.Key) error {
+	base := peerIdToKey(eth2Base, id)
+	for _, suffix := range bookDataSuffixes {
+		isRemoved := false
+		for _, r := range removed {
+			if r == suffix {
+				isRemoved = true
+				break
+			}
+		}
+		if isRemoved {
+			continue
+		}
+		has, err := store.Has(ctx, base.Child(suffix))
+		if err != nil {
+			return fmt.Errorf("failed to check %s data: %v", suffix.Name(), err)
+		}
+		if has {
+			return nil
+		}
+	}
+	return removeTimes(ctx, w, id, updatedSuffix, firstSeenSuffix)
+}
+
+var _ eth2peerstore.SeenBook = (*dsExtendedPeerstore)(nil)
+
+func (ep *dsExtendedPeerstore) FirstSeen(ctx context.Context, id peer.ID) (time.Time, error) {
+	return loadTime(ctx, ep.store, id, firstSeenSuffix)
+}
+
+func (ep *dsExtendedPeerstore) LastUpdated(ctx context.Context, id peer.ID) (time.Time, error) {
+	return loadTime(ctx, ep.store, id, updatedSuffix)
+}
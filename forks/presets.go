@@ -0,0 +1,79 @@
+package forks
+
+import (
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+func mustRoot(v string) (out common.Root) {
+	if err := out.UnmarshalText([]byte(v)); err != nil {
+		panic(err)
+	}
+	return
+}
+
+// Mainnet is the fork schedule of Ethereum mainnet
+var Mainnet = &Schedule{
+	Name:                  "mainnet",
+	GenesisValidatorsRoot: mustRoot("0x4b363db94e286120d76eb905340fdd4e54bfe9f06bf33ff6cf5ad27f511bfe95"),
+	Forks: []Fork{
+		{Name: "genesis", Version: common.Version{0x00, 0x00, 0x00, 0x00}, Epoch: 0},
+		{Name: "altair", Version: common.Version{0x01, 0x00, 0x00, 0x00}, Epoch: 74240},
+		{Name: "bellatrix", Version: common.Version{0x02, 0x00, 0x00, 0x00}, Epoch: 144896},
+		{Name: "capella", Version: common.Version{0x03, 0x00, 0x00, 0x00}, Epoch: 194048},
+		{Name: "deneb", Version: common.Version{0x04, 0x00, 0x00, 0x00}, Epoch: 269568},
+		{Name: "electra", Version: common.Version{0x05, 0x00, 0x00, 0x00}, Epoch: 364032},
+		{Name: "fulu", Version: common.Version{0x06, 0x00, 0x00, 0x00}, Epoch: 411392},
+	},
+	BlobSchedule: []BlobParameters{
+		{Epoch: 412672, MaxBlobsPerBlock: 15},
+		{Epoch: 419072, MaxBlobsPerBlock: 21},
+	},
+	MaxBlobsPerBlockElectra: 9,
+}
+
+// Sepolia is the fork schedule of the Sepolia testnet
+var Sepolia = &Schedule{
+	Name:                  "sepolia",
+	GenesisValidatorsRoot: mustRoot("0xd8ea171f3c94aea21ebc42a1ed61052acf3f9209c00e4efbaaddac09ed9b8078"),
+	Forks: []Fork{
+		{Name: "genesis", Version: common.Version{0x90, 0x00, 0x00, 0x69}, Epoch: 0},
+		{Name: "altair", Version: common.Version{0x90, 0x00, 0x00, 0x70}, Epoch: 50},
+		{Name: "bellatrix", Version: common.Version{0x90, 0x00, 0x00, 0x71}, Epoch: 100},
+		{Name: "capella", Version: common.Version{0x90, 0x00, 0x00, 0x72}, Epoch: 56832},
+		{Name: "deneb", Version: common.Version{0x90, 0x00, 0x00, 0x73}, Epoch: 132608},
+		{Name: "electra", Version: common.Version{0x90, 0x00, 0x00, 0x74}, Epoch: 222464},
+		{Name: "fulu", Version: common.Version{0x90, 0x00, 0x00, 0x75}, Epoch: 272640},
+	},
+	BlobSchedule: []BlobParameters{
+		{Epoch: 274176, MaxBlobsPerBlock: 15},
+		{Epoch: 275712, MaxBlobsPerBlock: 21},
+	},
+	MaxBlobsPerBlockElectra: 9,
+}
+
+// Holesky is the fork schedule of the Holesky testnet
+var Holesky = &Schedule{
+	Name:                  "holesky",
+	GenesisValidatorsRoot: mustRoot("0x9143aa7c615a7f7115e2b6aac319c03529df8242ae705fba9df39b79c59fa8b1"),
+	Forks: []Fork{
+		{Name: "genesis", Version: common.Version{0x01, 0x01, 0x70, 0x00}, Epoch: 0},
+		{Name: "altair", Version: common.Version{0x02, 0x01, 0x70, 0x00}, Epoch: 0},
+		{Name: "bellatrix", Version: common.Version{0x03, 0x01, 0x70, 0x00}, Epoch: 0},
+		{Name: "capella", Version: common.Version{0x04, 0x01, 0x70, 0x00}, Epoch: 256},
+		{Name: "deneb", Version: common.Version{0x05, 0x01, 0x70, 0x00}, Epoch: 29696},
+		{Name: "electra", Version: common.Version{0x06, 0x01, 0x70, 0x00}, Epoch: 115968},
+		{Name: "fulu", Version: common.Version{0x07, 0x01, 0x70, 0x00}, Epoch: 165120},
+	},
+	BlobSchedule: []BlobParameters{
+		{Epoch: 166400, MaxBlobsPerBlock: 15},
+		{Epoch: 167936, MaxBlobsPerBlock: 21},
+	},
+	MaxBlobsPerBlockElectra: 9,
+}
+
+// Presets are the built-in fork schedules, by network name
+var Presets = map[string]*Schedule{
+	Mainnet.Name: Mainnet,
+	Sepolia.Name: Sepolia,
+	Holesky.Name: Holesky,
+}
@@ -0,0 +1,133 @@
+package forks
+
+import (
+	"context"
+	"fmt"
+	"github.com/protolambda/go-eth2-peerstore"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+// ReadinessReport summarizes how many peers are ready for the upcoming fork
+type ReadinessReport struct {
+	Network string       `json:"network"`
+	Epoch   common.Epoch `json:"epoch"`
+	// Current digest transition at Epoch, and the next one, if any is scheduled
+	Current string            `json:"current"`
+	Digest  common.ForkDigest `json:"digest"`
+	Next    string            `json:"next,omitempty"`
+	// The ENR eth2 next fork version and epoch that ready peers advertise.
+	// The current fork version and FarFutureEpoch if no fork is scheduled.
+	ExpectedNextForkVersion common.Version `json:"expected_next_fork_version"`
+	ExpectedNextForkEpoch   common.Epoch   `json:"expected_next_fork_epoch"`
+	// The ENR nfd digest that ready peers advertise, if a transition is scheduled
+	ExpectedNextForkDigest *common.ForkDigest `json:"expected_next_fork_digest,omitempty"`
+
+	// Peers with a digest of this network
+	Peers int `json:"peers"`
+	// Peers per digest transition name, see Transition.Name
+	Forks map[string]int `json:"forks"`
+	// Peers with a digest of another network
+	Foreign int `json:"foreign"`
+	// Peers without known digest
+	Unknown int `json:"unknown"`
+
+	// Peers of this network that advertise the expected next fork version and epoch in their ENR
+	Ready int `json:"ready"`
+	// Peers of this network that advertise another next fork version or epoch, by "<version>@<epoch>"
+	NotReady map[string]int `json:"not_ready"`
+	// Peers of this network without eth2 ENR data
+	NoENR int `json:"no_enr"`
+	// Peers of this network that advertise the expected nfd, and peers that advertise another nfd.
+	// Peers without nfd are not counted.
+	NextDigestReady    int `json:"next_digest_ready"`
+	NextDigestNotReady int `json:"next_digest_not_ready"`
+}
+
+// ReadyFraction is the fraction of peers with eth2 ENR data that advertise the expected next fork
+func (rr *ReadinessReport) ReadyFraction() float64 {
+	total := rr.Ready
+	for _, n := range rr.NotReady {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(rr.Ready) / float64(total)
+}
+
+// NewReadinessReport creates an empty report for the epoch, see Add.
+func (r *Resolver) NewReadinessReport(epoch common.Epoch) (*ReadinessReport, error) {
+	current, next, err := r.At(epoch)
+	if err != nil {
+		return nil, err
+	}
+	rr := &ReadinessReport{
+		Network:                 r.schedule.Name,
+		Epoch:                   epoch,
+		Current:                 current.Name(),
+		Digest:                  current.Digest,
+		ExpectedNextForkVersion: current.Fork.Version,
+		ExpectedNextForkEpoch:   FarFutureEpoch,
+		Forks:                   make(map[string]int),
+		NotReady:                make(map[string]int),
+	}
+	if next != nil {
+		rr.Next = next.Name()
+		digest := next.Digest
+		rr.ExpectedNextForkDigest = &digest
+	}
+	if f := r.nextFork(epoch); f != nil {
+		rr.ExpectedNextForkVersion = f.Version
+		rr.ExpectedNextForkEpoch = f.Epoch
+	}
+	return rr, nil
+}
+
+// Add counts the peer in the report
+func (r *Resolver) Add(rr *ReadinessReport, data *eth2peerstore.PeerAllData) {
+	digest, ok := PeerDigest(data)
+	if !ok {
+		rr.Unknown += 1
+		return
+	}
+	t, ok := r.Resolve(digest)
+	if !ok {
+		rr.Foreign += 1
+		return
+	}
+	rr.Peers += 1
+	rr.Forks[t.Name()] += 1
+	if data.NextForkVersion == nil || data.NextForkEpoch == nil {
+		rr.NoENR += 1
+	} else if *data.NextForkVersion == rr.ExpectedNextForkVersion && *data.NextForkEpoch == rr.ExpectedNextForkEpoch {
+		rr.Ready += 1
+	} else {
+		rr.NotReady[fmt.Sprintf("%s@%d", data.NextForkVersion, *data.NextForkEpoch)] += 1
+	}
+	if data.NextForkDigest != nil && rr.ExpectedNextForkDigest != nil {
+		if *data.NextForkDigest == *rr.ExpectedNextForkDigest {
+			rr.NextDigestReady += 1
+		} else {
+			rr.NextDigestNotReady += 1
+		}
+	}
+}
+
+// Readiness scans all eth2 peers of the peerstore, and reports on their readiness for the fork after the epoch.
+func (r *Resolver) Readiness(ctx context.Context, ps eth2peerstore.PeerIterator, epoch common.Epoch) (*ReadinessReport, error) {
+	rr, err := r.NewReadinessReport(epoch)
+	if err != nil {
+		return nil, err
+	}
+	_, err = ps.IteratePeers(ctx, "", func(data *eth2peerstore.PeerAllData) bool {
+		r.Add(rr, data)
+		return ctx.Err() == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return rr, nil
+}
@@ -0,0 +1,114 @@
+package forks
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/protolambda/go-eth2-peerstore"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+// sliceIterator iterates over the data of a fixed list of peers
+type sliceIterator []*eth2peerstore.PeerAllData
+
+func (s sliceIterator) Eth2Peers(ctx context.Context, after eth2peerstore.PeerCursor, limit int) ([]peer.ID, eth2peerstore.PeerCursor, error) {
+	ids := make([]peer.ID, 0, len(s))
+	for _, d := range s {
+		ids = append(ids, d.PeerID)
+	}
+	return ids, "", nil
+}
+
+func (s sliceIterator) IteratePeers(ctx context.Context, after eth2peerstore.PeerCursor, fn func(data *eth2peerstore.PeerAllData) bool) (eth2peerstore.PeerCursor, error) {
+	for _, d := range s {
+		if !fn(d) {
+			return eth2peerstore.PeerCursor(d.PeerID), nil
+		}
+	}
+	return "", nil
+}
+
+func TestReadiness(t *testing.T) {
+	r := newTestResolver(t, Mainnet)
+	electraEpoch := common.Epoch(400000)
+	electra, err := Mainnet.ForkDigest(electraEpoch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deneb, err := Mainnet.ForkDigest(269568)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fulu, err := Mainnet.ForkDigest(411392)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sepolia, err := Sepolia.ForkDigest(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fuluVersion := common.Version{0x06, 0x00, 0x00, 0x00}
+	fuluEpoch := common.Epoch(411392)
+	electraVersion := common.Version{0x05, 0x00, 0x00, 0x00}
+	farFuture := FarFutureEpoch
+	otherDigest := common.ForkDigest{0xde, 0xad}
+	peers := sliceIterator{
+		{PeerID: "ready", ForkDigest: &electra, NextForkVersion: &fuluVersion, NextForkEpoch: &fuluEpoch, NextForkDigest: &fulu},
+		{PeerID: "ready without nfd", ForkDigest: &electra, NextForkVersion: &fuluVersion, NextForkEpoch: &fuluEpoch},
+		{PeerID: "not ready", ForkDigest: &electra, NextForkVersion: &electraVersion, NextForkEpoch: &farFuture, NextForkDigest: &otherDigest},
+		{PeerID: "status only", Status: &common.Status{ForkDigest: electra}},
+		{PeerID: "behind", Status: &common.Status{ForkDigest: deneb}},
+		{PeerID: "foreign", ForkDigest: &sepolia},
+		{PeerID: "unknown"},
+	}
+	rr, err := r.Readiness(context.Background(), peers, electraEpoch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &ReadinessReport{
+		Network:                 "mainnet",
+		Epoch:                   electraEpoch,
+		Current:                 "electra",
+		Digest:                  electra,
+		Next:                    "fulu",
+		ExpectedNextForkVersion: fuluVersion,
+		ExpectedNextForkEpoch:   fuluEpoch,
+		ExpectedNextForkDigest:  &fulu,
+		Peers:                   5,
+		Forks:                   map[string]int{"electra": 4, "deneb": 1},
+		Foreign:                 1,
+		Unknown:                 1,
+		Ready:                   2,
+		NotReady:                map[string]int{"0x05000000@18446744073709551615": 1},
+		NoENR:                   2,
+		NextDigestReady:         1,
+		NextDigestNotReady:      1,
+	}
+	if !reflect.DeepEqual(rr, expected) {
+		t.Fatalf("got report %+v, expected %+v", rr, expected)
+	}
+	if got := rr.ReadyFraction(); got != 2.0/3.0 {
+		t.Fatalf("got ready fraction %v, expected 2/3", got)
+	}
+}
+
+func TestReadinessAfterLastFork(t *testing.T) {
+	r := newTestResolver(t, Mainnet)
+	// after the last blob parameters change, no transition is scheduled,
+	// and ready peers advertise the current fork version with FarFutureEpoch
+	rr, err := r.NewReadinessReport(500000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rr.Current != "fulu+bpo(21)" || rr.Next != "" || rr.ExpectedNextForkDigest != nil {
+		t.Fatalf("unexpected transitions in report %+v", rr)
+	}
+	if rr.ExpectedNextForkVersion != (common.Version{0x06, 0x00, 0x00, 0x00}) || rr.ExpectedNextForkEpoch != FarFutureEpoch {
+		t.Fatalf("unexpected next fork %s@%d", rr.ExpectedNextForkVersion, rr.ExpectedNextForkEpoch)
+	}
+	if got := rr.ReadyFraction(); got != 0 {
+		t.Fatalf("got ready fraction %v of empty report", got)
+	}
+}
@@ -0,0 +1,105 @@
+package forks
+
+import (
+	"fmt"
+	"github.com/protolambda/go-eth2-peerstore"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+// Resolver maps fork digests to the forks of a schedule
+type Resolver struct {
+	schedule    *Schedule
+	transitions []Transition
+	digests     map[common.ForkDigest]Transition
+}
+
+func NewResolver(schedule *Schedule) (*Resolver, error) {
+	transitions, err := schedule.Transitions()
+	if err != nil {
+		return nil, err
+	}
+	r := &Resolver{schedule: schedule, transitions: transitions, digests: make(map[common.ForkDigest]Transition)}
+	for _, t := range transitions {
+		r.digests[t.Digest] = t
+	}
+	// forks that activate at the same epoch as a later fork are never current,
+	// but their digests still belong to the network (e.g. genesis of networks that start in a later fork)
+	for _, f := range schedule.Forks {
+		if f.Epoch == FarFutureEpoch {
+			continue
+		}
+		digest := common.ComputeForkDigest(f.Version, schedule.GenesisValidatorsRoot)
+		if _, ok := r.digests[digest]; !ok {
+			r.digests[digest] = Transition{Fork: f, Epoch: f.Epoch, Digest: digest}
+		}
+	}
+	return r, nil
+}
+
+// Resolve returns the fork of the digest, or false if the digest is not of this network.
+func (r *Resolver) Resolve(digest common.ForkDigest) (Transition, bool) {
+	t, ok := r.digests[digest]
+	return t, ok
+}
+
+// At returns the transition that is current at the epoch, and the next scheduled transition, if any.
+func (r *Resolver) At(epoch common.Epoch) (current Transition, next *Transition, err error) {
+	found := false
+	for i, t := range r.transitions {
+		if t.Epoch <= epoch {
+			current, found = t, true
+		} else {
+			next = &r.transitions[i]
+			break
+		}
+	}
+	if !found {
+		return Transition{}, nil, fmt.Errorf("no fork active at epoch %d", epoch)
+	}
+	return current, next, nil
+}
+
+// nextFork returns the next regular fork after the epoch, excluding blob parameter only changes.
+func (r *Resolver) nextFork(epoch common.Epoch) *Fork {
+	for i, f := range r.schedule.Forks {
+		if f.Epoch > epoch && f.Epoch != FarFutureEpoch {
+			return &r.schedule.Forks[i]
+		}
+	}
+	return nil
+}
+
+// PeerDigest returns the fork digest of the peer: from the ENR, or else from the status.
+func PeerDigest(data *eth2peerstore.PeerAllData) (common.ForkDigest, bool) {
+	if data.ForkDigest != nil {
+		return *data.ForkDigest, true
+	}
+	if data.Status != nil {
+		return data.Status.ForkDigest, true
+	}
+	return common.ForkDigest{}, false
+}
+
+// OnNetwork selects peers with a fork digest of this network, in their ENR or status
+func (r *Resolver) OnNetwork() eth2peerstore.PeerFilter {
+	return func(data *eth2peerstore.PeerAllData) bool {
+		digest, ok := PeerDigest(data)
+		if !ok {
+			return false
+		}
+		_, ok = r.Resolve(digest)
+		return ok
+	}
+}
+
+// Foreign selects peers with a fork digest that is not of this network. Peers without known digest are not selected.
+func (r *Resolver) Foreign() eth2peerstore.PeerFilter {
+	return func(data *eth2peerstore.PeerAllData) bool {
+		digest, ok := PeerDigest(data)
+		if !ok {
+			return false
+		}
+		_, ok = r.Resolve(digest)
+		return !ok
+	}
+}
@@ -0,0 +1,136 @@
+package forks
+
+import (
+	"testing"
+
+	"github.com/protolambda/go-eth2-peerstore"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+func newTestResolver(t *testing.T, s *Schedule) *Resolver {
+	t.Helper()
+	r, err := NewResolver(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestResolve(t *testing.T) {
+	r := newTestResolver(t, Mainnet)
+	transitions, err := Mainnet.Transitions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tr := range transitions {
+		got, ok := r.Resolve(tr.Digest)
+		if !ok || got.Name() != tr.Name() || got.Epoch != tr.Epoch {
+			t.Fatalf("digest %s resolved to %v, %v, expected %s", tr.Digest, got.Name(), ok, tr.Name())
+		}
+	}
+	sepolia, err := Sepolia.ForkDigest(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := r.Resolve(sepolia); ok {
+		t.Fatalf("sepolia digest resolved to mainnet fork %s", got.Name())
+	}
+
+	// holesky starts in bellatrix, the genesis and altair digests are never current, but are of the network
+	holesky := newTestResolver(t, Holesky)
+	for _, f := range Holesky.Forks[:3] {
+		got, ok := holesky.Resolve(common.ComputeForkDigest(f.Version, Holesky.GenesisValidatorsRoot))
+		if !ok || got.Fork.Name != f.Name {
+			t.Fatalf("%s digest resolved to %v, %v", f.Name, got.Fork.Name, ok)
+		}
+	}
+	current, _, err := holesky.At(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.Name() != "bellatrix" {
+		t.Fatalf("holesky starts in %s, expected bellatrix", current.Name())
+	}
+}
+
+func TestAt(t *testing.T) {
+	r := newTestResolver(t, Mainnet)
+	cases := []struct {
+		epoch   common.Epoch
+		current string
+		next    string
+	}{
+		{0, "genesis", "altair"},
+		{74239, "genesis", "altair"},
+		{74240, "altair", "bellatrix"},
+		{364032, "electra", "fulu"},
+		{411392, "fulu", "fulu+bpo(15)"},
+		{412672, "fulu+bpo(15)", "fulu+bpo(21)"},
+		{419072, "fulu+bpo(21)", ""},
+		{FarFutureEpoch - 1, "fulu+bpo(21)", ""},
+	}
+	for _, c := range cases {
+		current, next, err := r.At(c.epoch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nextName := ""
+		if next != nil {
+			nextName = next.Name()
+		}
+		if current.Name() != c.current || nextName != c.next {
+			t.Fatalf("epoch %d: got %s -> %q, expected %s -> %q", c.epoch, current.Name(), nextName, c.current, c.next)
+		}
+	}
+	// a network without genesis fork has no fork at epoch 0
+	late := &Schedule{Name: "late", Forks: []Fork{{Name: "altair", Version: common.Version{1}, Epoch: 10}}}
+	if _, _, err := newTestResolver(t, late).At(0); err == nil {
+		t.Fatal("expected an error before the first fork")
+	}
+}
+
+func TestNextFork(t *testing.T) {
+	r := newTestResolver(t, Mainnet)
+	// blob parameter changes are not regular forks
+	if f := r.nextFork(411392); f != nil {
+		t.Fatalf("got next fork %s after fulu, expected none", f.Name)
+	}
+	if f := r.nextFork(364032); f == nil || f.Name != "fulu" {
+		t.Fatalf("got next fork %v after electra, expected fulu", f)
+	}
+}
+
+func TestNetworkFilters(t *testing.T) {
+	r := newTestResolver(t, Mainnet)
+	mainnet, err := Mainnet.ForkDigest(364032)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sepolia, err := Sepolia.ForkDigest(222464)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name    string
+		data    eth2peerstore.PeerAllData
+		network bool
+		foreign bool
+	}{
+		{"enr digest", eth2peerstore.PeerAllData{ForkDigest: &mainnet}, true, false},
+		{"status digest", eth2peerstore.PeerAllData{Status: &common.Status{ForkDigest: mainnet}}, true, false},
+		// the ENR digest takes precedence over the status
+		{"enr over status", eth2peerstore.PeerAllData{ForkDigest: &sepolia, Status: &common.Status{ForkDigest: mainnet}}, false, true},
+		{"foreign", eth2peerstore.PeerAllData{ForkDigest: &sepolia}, false, true},
+		{"unknown", eth2peerstore.PeerAllData{}, false, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := r.OnNetwork()(&c.data); got != c.network {
+				t.Fatalf("on network: got %v, expected %v", got, c.network)
+			}
+			if got := r.Foreign()(&c.data); got != c.foreign {
+				t.Fatalf("foreign: got %v, expected %v", got, c.foreign)
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+// Package forks resolves fork digests to the forks of a network, and reports on next-fork readiness of peers.
+package forks
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"gopkg.in/yaml.v3"
+	"io"
+	"sort"
+	"strings"
+)
+
+// FarFutureEpoch is the epoch of forks that are not scheduled
+const FarFutureEpoch = ^common.Epoch(0)
+
+// Fork is a fork of the network, activated at Epoch with Version
+type Fork struct {
+	Name    string         `json:"name"`
+	Version common.Version `json:"version"`
+	Epoch   common.Epoch   `json:"epoch"`
+}
+
+// BlobParameters changes the max blobs per block at Epoch, without fork version change (Fulu BPO fork)
+type BlobParameters struct {
+	Epoch            common.Epoch `json:"epoch" yaml:"EPOCH"`
+	MaxBlobsPerBlock uint64       `json:"max_blobs_per_block" yaml:"MAX_BLOBS_PER_BLOCK"`
+}
+
+// Schedule is the fork schedule of a network
+type Schedule struct {
+	Name                  string      `json:"name"`
+	GenesisValidatorsRoot common.Root `json:"genesis_validators_root"`
+	// Forks, sorted by epoch. Forks with FarFutureEpoch are not scheduled.
+	Forks []Fork `json:"forks"`
+	// Blob schedule of Fulu and later. Empty before Fulu.
+	BlobSchedule []BlobParameters `json:"blob_schedule,omitempty"`
+	// Max blobs per block in Electra, used for the Fulu digest if the blob schedule has no earlier entry
+	MaxBlobsPerBlockElectra uint64 `json:"max_blobs_per_block_electra,omitempty"`
+}
+
+// forkNames are the forks, in order, as prefixes of the config keys, e.g. ALTAIR_FORK_VERSION
+var forkNames = []string{"genesis", "altair", "bellatrix", "capella", "deneb", "electra", "fulu", "gloas"}
+
+// fork returns the fork that is active at the epoch, or false if no fork is active yet.
+func (s *Schedule) fork(epoch common.Epoch) (Fork, bool) {
+	var out Fork
+	ok := false
+	for _, f := range s.Forks {
+		if f.Epoch != FarFutureEpoch && f.Epoch <= epoch {
+			out, ok = f, true
+		}
+	}
+	return out, ok
+}
+
+// forkEpoch returns the activation epoch of the named fork, or FarFutureEpoch if not scheduled.
+func (s *Schedule) forkEpoch(name string) common.Epoch {
+	for _, f := range s.Forks {
+		if f.Name == name {
+			return f.Epoch
+		}
+	}
+	return FarFutureEpoch
+}
+
+// blobParameters returns the blob parameters at the epoch, as in get_blob_parameters of the spec.
+func (s *Schedule) blobParameters(epoch common.Epoch) BlobParameters {
+	sorted := append([]BlobParameters(nil), s.BlobSchedule...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Epoch > sorted[j].Epoch
+	})
+	for _, b := range sorted {
+		if epoch >= b.Epoch {
+			return b
+		}
+	}
+	return BlobParameters{Epoch: s.forkEpoch("electra"), MaxBlobsPerBlock: s.MaxBlobsPerBlockElectra}
+}
+
+// ForkDigest computes the fork digest at the epoch, as in compute_fork_digest of the spec:
+// starting at Fulu, the digest is modified by the blob parameters of the epoch.
+func (s *Schedule) ForkDigest(epoch common.Epoch) (common.ForkDigest, error) {
+	f, ok := s.fork(epoch)
+	if !ok {
+		return common.ForkDigest{}, fmt.Errorf("no fork active at epoch %d", epoch)
+	}
+	digest := common.ComputeForkDigest(f.Version, s.GenesisValidatorsRoot)
+	if fulu := s.forkEpoch("fulu"); fulu != FarFutureEpoch && epoch >= fulu {
+		params := s.blobParameters(epoch)
+		var buf [16]byte
+		binary.LittleEndian.PutUint64(buf[:8], uint64(params.Epoch))
+		binary.LittleEndian.PutUint64(buf[8:], params.MaxBlobsPerBlock)
+		h := sha256.Sum256(buf[:])
+		for i := range digest {
+			digest[i] ^= h[i]
+		}
+	}
+	return digest, nil
+}
+
+// Transition is an epoch at which the fork digest changes: either a fork, or a blob parameters change.
+type Transition struct {
+	// Fork that is active after the transition
+	Fork Fork `json:"fork"`
+	// Epoch of the transition, the fork epoch for regular forks
+	Epoch common.Epoch `json:"epoch"`
+	// Blob parameters after the transition, for transitions starting at Fulu
+	Blobs  *BlobParameters   `json:"blobs,omitempty"`
+	Digest common.ForkDigest `json:"digest"`
+}
+
+// Name is the fork name, with the max blobs for blob parameter only changes, e.g. "fulu+bpo(15)".
+func (t *Transition) Name() string {
+	if t.Blobs != nil && t.Epoch != t.Fork.Epoch {
+		return fmt.Sprintf("%s+bpo(%d)", t.Fork.Name, t.Blobs.MaxBlobsPerBlock)
+	}
+	return t.Fork.Name
+}
+
+// Transitions lists all scheduled digest changes, sorted by epoch
+func (s *Schedule) Transitions() ([]Transition, error) {
+	epochs := make(map[common.Epoch]struct{})
+	for _, f := range s.Forks {
+		if f.Epoch != FarFutureEpoch {
+			epochs[f.Epoch] = struct{}{}
+		}
+	}
+	if fulu := s.forkEpoch("fulu"); fulu != FarFutureEpoch {
+		for _, b := range s.BlobSchedule {
+			if b.Epoch >= fulu && b.Epoch != FarFutureEpoch {
+				epochs[b.Epoch] = struct{}{}
+			}
+		}
+	}
+	var out []Transition
+	for epoch := range epochs {
+		f, ok := s.fork(epoch)
+		if !ok {
+			continue
+		}
+		digest, err := s.ForkDigest(epoch)
+		if err != nil {
+			return nil, err
+		}
+		t := Transition{Fork: f, Epoch: epoch, Digest: digest}
+		if fulu := s.forkEpoch("fulu"); fulu != FarFutureEpoch && epoch >= fulu {
+			params := s.blobParameters(epoch)
+			t.Blobs = &params
+		}
+		out = append(out, t)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Epoch < out[j].Epoch
+	})
+	return out, nil
+}
+
+// LaterForksConfig holds the config values of the forks after bellatrix, which the zrnt config does not include.
+// It decodes from the same consensus-specs config YAML as the zrnt config.
+// Forks that are not scheduled have FarFutureEpoch, as in the config.
+type LaterForksConfig struct {
+	CAPELLA_FORK_VERSION common.Version `yaml:"CAPELLA_FORK_VERSION" json:"CAPELLA_FORK_VERSION"`
+	CAPELLA_FORK_EPOCH   common.Epoch   `yaml:"CAPELLA_FORK_EPOCH" json:"CAPELLA_FORK_EPOCH"`
+
+	DENEB_FORK_VERSION common.Version `yaml:"DENEB_FORK_VERSION" json:"DENEB_FORK_VERSION"`
+	DENEB_FORK_EPOCH   common.Epoch   `yaml:"DENEB_FORK_EPOCH" json:"DENEB_FORK_EPOCH"`
+
+	ELECTRA_FORK_VERSION common.Version `yaml:"ELECTRA_FORK_VERSION" json:"ELECTRA_FORK_VERSION"`
+	ELECTRA_FORK_EPOCH   common.Epoch   `yaml:"ELECTRA_FORK_EPOCH" json:"ELECTRA_FORK_EPOCH"`
+
+	FULU_FORK_VERSION common.Version `yaml:"FULU_FORK_VERSION" json:"FULU_FORK_VERSION"`
+	FULU_FORK_EPOCH   common.Epoch   `yaml:"FULU_FORK_EPOCH" json:"FULU_FORK_EPOCH"`
+
+	MAX_BLOBS_PER_BLOCK_ELECTRA uint64           `yaml:"MAX_BLOBS_PER_BLOCK_ELECTRA" json:"MAX_BLOBS_PER_BLOCK_ELECTRA"`
+	BLOB_SCHEDULE               []BlobParameters `yaml:"BLOB_SCHEDULE" json:"BLOB_SCHEDULE"`
+}
+
+// FromConfig creates the schedule of the forks of the zrnt config: genesis, altair and bellatrix,
+// and of the later forks: capella, deneb, electra and fulu, with the blob schedule.
+// The later forks are not scheduled if later is nil. Forks are sorted by epoch, unscheduled forks last.
+func FromConfig(name string, cfg *common.Config, later *LaterForksConfig, genesisValidatorsRoot common.Root) *Schedule {
+	s := &Schedule{
+		Name:                  name,
+		GenesisValidatorsRoot: genesisValidatorsRoot,
+		Forks: []Fork{
+			{Name: "genesis", Version: cfg.GENESIS_FORK_VERSION, Epoch: 0},
+			{Name: "altair", Version: cfg.ALTAIR_FORK_VERSION, Epoch: cfg.ALTAIR_FORK_EPOCH},
+			{Name: "bellatrix", Version: cfg.BELLATRIX_FORK_VERSION, Epoch: cfg.BELLATRIX_FORK_EPOCH},
+		},
+	}
+	if later != nil {
+		s.Forks = append(s.Forks,
+			Fork{Name: "capella", Version: later.CAPELLA_FORK_VERSION, Epoch: later.CAPELLA_FORK_EPOCH},
+			Fork{Name: "deneb", Version: later.DENEB_FORK_VERSION, Epoch: later.DENEB_FORK_EPOCH},
+			Fork{Name: "electra", Version: later.ELECTRA_FORK_VERSION, Epoch: later.ELECTRA_FORK_EPOCH},
+			Fork{Name: "fulu", Version: later.FULU_FORK_VERSION, Epoch: later.FULU_FORK_EPOCH},
+		)
+		s.BlobSchedule = append([]BlobParameters(nil), later.BLOB_SCHEDULE...)
+		s.MaxBlobsPerBlockElectra = later.MAX_BLOBS_PER_BLOCK_ELECTRA
+	}
+	sort.SliceStable(s.Forks, func(i, j int) bool {
+		return s.Forks[i].Epoch < s.Forks[j].Epoch
+	})
+	return s
+}
+
+// LoadYAML creates the schedule from a consensus-specs style config YAML,
+// reading the <FORK>_FORK_VERSION and <FORK>_FORK_EPOCH keys of all known forks, and the BLOB_SCHEDULE.
+// Other keys are ignored.
+func LoadYAML(name string, r io.Reader, genesisValidatorsRoot common.Root) (*Schedule, error) {
+	var raw map[string]yaml.Node
+	if err := yaml.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("failed to decode config: %w", err)
+	}
+	s := &Schedule{Name: name, GenesisValidatorsRoot: genesisValidatorsRoot}
+	for _, fork := range forkNames {
+		prefix := strings.ToUpper(fork)
+		versionNode, ok := raw[prefix+"_FORK_VERSION"]
+		if !ok {
+			continue
+		}
+		var f Fork
+		f.Name = fork
+		if err := f.Version.UnmarshalText([]byte(versionNode.Value)); err != nil {
+			return nil, fmt.Errorf("invalid %s_FORK_VERSION: %w", prefix, err)
+		}
+		if fork != "genesis" {
+			epochNode, ok := raw[prefix+"_FORK_EPOCH"]
+			if !ok {
+				return nil, fmt.Errorf("missing %s_FORK_EPOCH", prefix)
+			}
+			var epoch uint64
+			if err := epochNode.Decode(&epoch); err != nil {
+				return nil, fmt.Errorf("invalid %s_FORK_EPOCH: %w", prefix, err)
+			}
+			f.Epoch = common.Epoch(epoch)
+		}
+		s.Forks = append(s.Forks, f)
+	}
+	if len(s.Forks) == 0 || s.Forks[0].Name != "genesis" {
+		return nil, fmt.Errorf("config has no GENESIS_FORK_VERSION")
+	}
+	if node, ok := raw["BLOB_SCHEDULE"]; ok {
+		if err := node.Decode(&s.BlobSchedule); err != nil {
+			return nil, fmt.Errorf("invalid BLOB_SCHEDULE: %w", err)
+		}
+	}
+	if node, ok := raw["MAX_BLOBS_PER_BLOCK_ELECTRA"]; ok {
+		if err := node.Decode(&s.MaxBlobsPerBlockElectra); err != nil {
+			return nil, fmt.Errorf("invalid MAX_BLOBS_PER_BLOCK_ELECTRA: %w", err)
+		}
+	}
+	sort.SliceStable(s.Forks, func(i, j int) bool {
+		return s.Forks[i].Epoch < s.Forks[j].Epoch
+	})
+	return s, nil
+}
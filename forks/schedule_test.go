@@ -0,0 +1,258 @@
+package forks
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+func mustDigest(t *testing.T, v string) (out common.ForkDigest) {
+	t.Helper()
+	if err := out.UnmarshalText([]byte(v)); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+// mainnetConfig is the mainnet config of zrnt, with the fork epochs it does not know about yet
+func mainnetConfig() (*common.Config, *LaterForksConfig) {
+	cfg := configs.Mainnet.Config
+	cfg.BELLATRIX_FORK_EPOCH = 144896
+	later := &LaterForksConfig{
+		CAPELLA_FORK_VERSION:        common.Version{0x03, 0x00, 0x00, 0x00},
+		CAPELLA_FORK_EPOCH:          194048,
+		DENEB_FORK_VERSION:          common.Version{0x04, 0x00, 0x00, 0x00},
+		DENEB_FORK_EPOCH:            269568,
+		ELECTRA_FORK_VERSION:        common.Version{0x05, 0x00, 0x00, 0x00},
+		ELECTRA_FORK_EPOCH:          364032,
+		FULU_FORK_VERSION:           common.Version{0x06, 0x00, 0x00, 0x00},
+		FULU_FORK_EPOCH:             411392,
+		MAX_BLOBS_PER_BLOCK_ELECTRA: 9,
+		BLOB_SCHEDULE: []BlobParameters{
+			{Epoch: 412672, MaxBlobsPerBlock: 15},
+			{Epoch: 419072, MaxBlobsPerBlock: 21},
+		},
+	}
+	return &cfg, later
+}
+
+func TestMainnetDigests(t *testing.T) {
+	cfg, later := mainnetConfig()
+	fromConfig := FromConfig("mainnet", cfg, later, Mainnet.GenesisValidatorsRoot)
+	if !reflect.DeepEqual(fromConfig, Mainnet) {
+		t.Fatalf("schedule from config %+v differs from preset %+v", fromConfig, Mainnet)
+	}
+	cases := []struct {
+		epoch  common.Epoch
+		digest string
+	}{
+		{0, "0xb5303f2a"},
+		{74239, "0xb5303f2a"},
+		{74240, "0xafcaaba0"},
+		{144896, "0x4a26c58b"},
+		{194048, "0xbba4da96"},
+		{269568, "0x6a95a1a9"},
+		{364031, "0x6a95a1a9"},
+	}
+	for _, s := range []*Schedule{Mainnet, fromConfig} {
+		for _, c := range cases {
+			digest, err := s.ForkDigest(c.epoch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := mustDigest(t, c.digest); digest != expected {
+				t.Fatalf("epoch %d: got digest %s, expected %s", c.epoch, digest, expected)
+			}
+		}
+	}
+}
+
+func TestFromConfigWithoutLaterForks(t *testing.T) {
+	cfg, _ := mainnetConfig()
+	s := FromConfig("mainnet", cfg, nil, Mainnet.GenesisValidatorsRoot)
+	var names []string
+	for _, f := range s.Forks {
+		names = append(names, f.Name)
+	}
+	if expected := []string{"genesis", "altair", "bellatrix"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("got forks %v, expected %v", names, expected)
+	}
+	if len(s.BlobSchedule) != 0 {
+		t.Fatalf("unexpected blob schedule %v", s.BlobSchedule)
+	}
+}
+
+// bpoSchedule is the schedule of the compute_fork_digest test of the Fulu consensus-specs, on the minimal config
+func bpoSchedule(gvr byte) *Schedule {
+	s := &Schedule{
+		Name: "bpo",
+		Forks: []Fork{
+			{Name: "genesis", Version: common.Version{0x00, 0x00, 0x00, 0x01}, Epoch: 0},
+			{Name: "altair", Version: common.Version{0x01, 0x00, 0x00, 0x01}, Epoch: 0},
+			{Name: "bellatrix", Version: common.Version{0x02, 0x00, 0x00, 0x01}, Epoch: 0},
+			{Name: "capella", Version: common.Version{0x03, 0x00, 0x00, 0x01}, Epoch: 0},
+			{Name: "deneb", Version: common.Version{0x04, 0x00, 0x00, 0x01}, Epoch: 0},
+			{Name: "electra", Version: common.Version{0x05, 0x00, 0x00, 0x01}, Epoch: 9},
+			{Name: "fulu", Version: common.Version{0x06, 0x00, 0x00, 0x01}, Epoch: 100},
+		},
+		BlobSchedule: []BlobParameters{
+			{Epoch: 100, MaxBlobsPerBlock: 100},
+			{Epoch: 150, MaxBlobsPerBlock: 175},
+			{Epoch: 200, MaxBlobsPerBlock: 200},
+			{Epoch: 250, MaxBlobsPerBlock: 275},
+			{Epoch: 300, MaxBlobsPerBlock: 300},
+		},
+		MaxBlobsPerBlockElectra: 9,
+	}
+	for i := range s.GenesisValidatorsRoot {
+		s.GenesisValidatorsRoot[i] = gvr
+	}
+	return s
+}
+
+func TestFuluForkDigest(t *testing.T) {
+	cases := []struct {
+		epoch  common.Epoch
+		gvr    byte
+		digest string
+	}{
+		{9, 0, "0x97b2c268"},
+		{10, 0, "0x97b2c268"},
+		{99, 0, "0x97b2c268"},
+		{100, 0, "0x44a571e8"},
+		{101, 0, "0x44a571e8"},
+		{150, 0, "0x1171afca"},
+		{199, 0, "0x1171afca"},
+		{200, 0, "0x427a30ab"},
+		{250, 0, "0xd5310ef1"},
+		{299, 0, "0xd5310ef1"},
+		{300, 0, "0x51d229f7"},
+		{301, 0, "0x51d229f7"},
+		{9, 1, "0x4a5c3011"},
+		{9, 2, "0xe8332b52"},
+		{9, 3, "0x0e38e75e"},
+		{100, 1, "0xbfe98545"},
+		{100, 2, "0x9b7e4788"},
+		{100, 3, "0x8b5ce4af"},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("epoch %d gvr %d", c.epoch, c.gvr), func(t *testing.T) {
+			digest, err := bpoSchedule(c.gvr).ForkDigest(c.epoch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := mustDigest(t, c.digest); digest != expected {
+				t.Fatalf("got digest %s, expected %s", digest, expected)
+			}
+		})
+	}
+}
+
+func TestTransitions(t *testing.T) {
+	transitions, err := Mainnet.Transitions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	type summary struct {
+		name  string
+		epoch common.Epoch
+		blobs *BlobParameters
+	}
+	expected := []summary{
+		{"genesis", 0, nil},
+		{"altair", 74240, nil},
+		{"bellatrix", 144896, nil},
+		{"capella", 194048, nil},
+		{"deneb", 269568, nil},
+		{"electra", 364032, nil},
+		// without earlier blob schedule entry, fulu uses the electra blob limit
+		{"fulu", 411392, &BlobParameters{Epoch: 364032, MaxBlobsPerBlock: 9}},
+		{"fulu+bpo(15)", 412672, &BlobParameters{Epoch: 412672, MaxBlobsPerBlock: 15}},
+		{"fulu+bpo(21)", 419072, &BlobParameters{Epoch: 419072, MaxBlobsPerBlock: 21}},
+	}
+	got := make([]summary, 0, len(transitions))
+	digests := make(map[common.ForkDigest]bool)
+	for _, tr := range transitions {
+		got = append(got, summary{tr.Name(), tr.Epoch, tr.Blobs})
+		digest, err := Mainnet.ForkDigest(tr.Epoch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if digest != tr.Digest {
+			t.Fatalf("transition %s has digest %s, expected %s", tr.Name(), tr.Digest, digest)
+		}
+		if digests[tr.Digest] {
+			t.Fatalf("transition %s has a duplicate digest %s", tr.Name(), tr.Digest)
+		}
+		digests[tr.Digest] = true
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("got transitions %+v, expected %+v", got, expected)
+	}
+	// blob parameter changes before fulu do not change the digest
+	s := *bpoSchedule(0)
+	s.BlobSchedule = append([]BlobParameters{{Epoch: 50, MaxBlobsPerBlock: 12}}, s.BlobSchedule...)
+	transitions, err = s.Transitions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tr := range transitions {
+		if tr.Epoch == 50 {
+			t.Fatal("blob parameter change before fulu is a transition")
+		}
+	}
+}
+
+func TestLoadYAML(t *testing.T) {
+	config := `# mainnet
+PRESET_BASE: 'mainnet'
+CONFIG_NAME: 'mainnet'
+GENESIS_FORK_VERSION: 0x00000000
+ALTAIR_FORK_VERSION: 0x01000000
+ALTAIR_FORK_EPOCH: 74240
+BELLATRIX_FORK_VERSION: 0x02000000
+BELLATRIX_FORK_EPOCH: 144896
+CAPELLA_FORK_VERSION: 0x03000000
+CAPELLA_FORK_EPOCH: 194048
+DENEB_FORK_VERSION: 0x04000000
+DENEB_FORK_EPOCH: 269568
+ELECTRA_FORK_VERSION: 0x05000000
+ELECTRA_FORK_EPOCH: 364032
+FULU_FORK_VERSION: 0x06000000
+FULU_FORK_EPOCH: 411392
+SECONDS_PER_SLOT: 12
+MAX_BLOBS_PER_BLOCK_ELECTRA: 9
+BLOB_SCHEDULE:
+  - EPOCH: 412672
+    MAX_BLOBS_PER_BLOCK: 15
+  - EPOCH: 419072
+    MAX_BLOBS_PER_BLOCK: 21
+`
+	s, err := LoadYAML("mainnet", strings.NewReader(config), Mainnet.GenesisValidatorsRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s, Mainnet) {
+		t.Fatalf("loaded schedule %+v differs from preset %+v", s, Mainnet)
+	}
+	invalid := []struct {
+		name   string
+		config string
+	}{
+		{"no genesis", "ALTAIR_FORK_VERSION: 0x01000000\nALTAIR_FORK_EPOCH: 1\n"},
+		{"missing epoch", "GENESIS_FORK_VERSION: 0x00000000\nALTAIR_FORK_VERSION: 0x01000000\n"},
+		{"invalid version", "GENESIS_FORK_VERSION: 0x00\n"},
+		{"invalid blob schedule", "GENESIS_FORK_VERSION: 0x00000000\nBLOB_SCHEDULE: 3\n"},
+	}
+	for _, c := range invalid {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := LoadYAML("invalid", strings.NewReader(c.config), common.Root{}); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package eth2peerstore
+
+// HeadSlotRate computes the average head slot advance per second of the status history,
+// ordered newest first. A synced peer advances about one slot per slot duration,
+// a stuck peer does not advance at all. Zero if the history covers no time.
+func HeadSlotRate(history []StatusSnapshot) float64 {
+	if len(history) < 2 {
+		return 0
+	}
+	newest, oldest := history[0], history[len(history)-1]
+	dt := newest.Time.Sub(oldest.Time).Seconds()
+	if dt <= 0 {
+		return 0
+	}
+	return (float64(newest.Status.HeadSlot) - float64(oldest.Status.HeadSlot)) / dt
+}
+
+// FinalizedEpochRate computes the average finalized epoch advance per second of the status history,
+// ordered newest first. Zero if the history covers no time.
+func FinalizedEpochRate(history []StatusSnapshot) float64 {
+	if len(history) < 2 {
+		return 0
+	}
+	newest, oldest := history[0], history[len(history)-1]
+	dt := newest.Time.Sub(oldest.Time).Seconds()
+	if dt <= 0 {
+		return 0
+	}
+	return (float64(newest.Status.FinalizedEpoch) - float64(oldest.Status.FinalizedEpoch)) / dt
+}
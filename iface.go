@@ -7,8 +7,12 @@ import (
 	ds "github.com/ipfs/go-datastore"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/protolambda/go-eth2-peerstore/addrutil"
+	"github.com/protolambda/go-eth2-peerstore/agent"
 	"github.com/protolambda/go-eth2-peerstore/dstee"
+	"github.com/protolambda/go-eth2-peerstore/types"
 	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/ztyp/view"
 	"time"
 )
 
@@ -27,6 +31,18 @@ type ENRBook interface {
 	LatestENR(ctx context.Context, id peer.ID) (n *enode.Node, err error)
 }
 
+// ENRSnapshot is an ENR, and the time it was registered at
+type ENRSnapshot struct {
+	Time time.Time   `json:"time"`
+	ENR  *enode.Node `json:"enr"`
+}
+
+type ENRHistoryBook interface {
+	// ENRHistory returns up to limit of the latest ENRs of the peer, newest first. No limit if limit <= 0.
+	// Use addrutil.DiffENR to compare subsequent records.
+	ENRHistory(ctx context.Context, id peer.ID, limit int) ([]ENRSnapshot, error)
+}
+
 type StatusBook interface {
 	// Status retrieves the peer status, and may be nil if there is no status
 	Status(context.Context, peer.ID) (*common.Status, error)
@@ -34,6 +50,41 @@ type StatusBook interface {
 	RegisterStatus(context.Context, peer.ID, common.Status) error
 }
 
+// VersionedStatusBook stores the status in the version it was registered with.
+// Status of the StatusBook is the phase0 view of the latest status, regardless of its version.
+type VersionedStatusBook interface {
+	// VersionedStatus retrieves the peer status, in the version it was registered with
+	VersionedStatus(context.Context, peer.ID) (*types.VersionedStatus, error)
+	// StatusV2 retrieves the Fulu view of the peer status, if registered with version 2 or later
+	StatusV2(context.Context, peer.ID) (*types.StatusV2, error)
+	// RegisterStatusV2 updates the status of the peer, with a Fulu status
+	RegisterStatusV2(context.Context, peer.ID, types.StatusV2) error
+}
+
+type StatusRelevanceBook interface {
+	// StatusRelevance returns the label of the latest status of the peer, as classified when it was last classified.
+	// Registering a new status drops the label, unless the status is classified on registration.
+	StatusRelevance(context.Context, peer.ID) (Relevance, error)
+	// ClassifyStatus labels the latest status of the peer, and persists the label with the status.
+	ClassifyStatus(ctx context.Context, id peer.ID, c *StatusClassifier) (Relevance, error)
+	// ClassifyStatuses labels the status of every eth2 peer, e.g. after the local chain view changed,
+	// and returns the number of peers per label.
+	ClassifyStatuses(ctx context.Context, c *StatusClassifier) (map[Relevance]int, error)
+}
+
+// StatusSnapshot is a status, and the time it was registered at
+type StatusSnapshot struct {
+	Time   time.Time     `json:"time"`
+	Status common.Status `json:"status"`
+	// EarliestAvailableSlot is only known for statuses registered with version 2 or later
+	EarliestAvailableSlot *common.Slot `json:"earliest_available_slot,omitempty"`
+}
+
+type StatusHistoryBook interface {
+	// StatusHistory returns up to limit of the latest statuses of the peer, newest first. No limit if limit <= 0.
+	StatusHistory(ctx context.Context, id peer.ID, limit int) ([]StatusSnapshot, error)
+}
+
 type MetadataBook interface {
 	Metadata(context.Context, peer.ID) (*common.MetaData, error)
 	ClaimedSeq(context.Context, peer.ID) (seq common.SeqNr, err error)
@@ -42,6 +93,37 @@ type MetadataBook interface {
 	RegisterMetadata(ctx context.Context, id peer.ID, md common.MetaData) (newer bool, err error)
 }
 
+// VersionedMetadataBook stores the metadata in the version it was registered with.
+// Metadata of the MetadataBook is the phase0 view of the latest metadata, regardless of its version.
+type VersionedMetadataBook interface {
+	// VersionedMetadata returns the latest metadata, in the version it was registered with
+	VersionedMetadata(context.Context, peer.ID) (*types.VersionedMetaData, error)
+	// MetadataV2 returns the Altair view of the latest metadata, if registered with version 2 or later
+	MetadataV2(context.Context, peer.ID) (*types.MetaDataV2, error)
+	// MetadataV3 returns the Fulu view of the latest metadata, if registered with version 3 or later
+	MetadataV3(context.Context, peer.ID) (*types.MetaDataV3, error)
+	RegisterMetadataV2(ctx context.Context, id peer.ID, md types.MetaDataV2) (newer bool, err error)
+	RegisterMetadataV3(ctx context.Context, id peer.ID, md types.MetaDataV3) (newer bool, err error)
+	// MigrateMetadata rewrites metadata stored before versioning, returning how many entries were migrated
+	MigrateMetadata(ctx context.Context) (migrated int, err error)
+}
+
+// SeenBook tracks when eth2 data of peers was stored
+type SeenBook interface {
+	// FirstSeen returns when any eth2 data of the peer was first stored
+	FirstSeen(context.Context, peer.ID) (time.Time, error)
+	// LastUpdated returns when any eth2 data of the peer was last updated
+	LastUpdated(context.Context, peer.ID) (time.Time, error)
+	// StatusUpdated returns when the status of the peer was last registered
+	StatusUpdated(context.Context, peer.ID) (time.Time, error)
+	// MetadataUpdated returns when newer metadata of the peer was last registered
+	MetadataUpdated(context.Context, peer.ID) (time.Time, error)
+	// ClaimUpdated returns when a higher seq nr claim of the peer was last registered
+	ClaimUpdated(context.Context, peer.ID) (time.Time, error)
+	// ENRUpdated returns when a newer ENR of the peer was last registered
+	ENRUpdated(ctx context.Context, id peer.ID) (time.Time, error)
+}
+
 type PeerAllData struct {
 	PeerID peer.ID  `json:"peer_id"`
 	NodeID enode.ID `json:"node_id"`
@@ -54,21 +136,55 @@ type PeerAllData struct {
 
 	UserAgent       string `json:"user_agent,omitempty"`
 	ProtocolVersion string `json:"protocol_version,omitempty"`
+	// Client parsed from the user agent, or from the ENR client entry if there is no user agent
+	Client *agent.Client `json:"client,omitempty"`
 
 	ForkDigest      *common.ForkDigest `json:"enr_fork_digest,omitempty"`
 	NextForkVersion *common.Version    `json:"enr_next_fork_version,omitempty"`
 	NextForkEpoch   *common.Epoch      `json:"enr_next_fork_epoch,omitempty"`
 
-	Attnets *common.AttnetBits `json:"enr_attnets,omitempty"`
+	Attnets  *common.AttnetBits `json:"enr_attnets,omitempty"`
+	Syncnets *types.SyncnetBits `json:"enr_syncnets,omitempty"`
+
+	// EIP-7636 client identification, and if it contradicts the identify user agent (when both are known)
+	ENRClient         *addrutil.ClientENREntry `json:"enr_client,omitempty"`
+	ENRClientMismatch bool                     `json:"enr_client_mismatch,omitempty"`
+
+	CustodyGroupCount *uint64            `json:"enr_cgc,omitempty"`
+	NextForkDigest    *common.ForkDigest `json:"enr_next_fork_digest,omitempty"`
 
 	// Metadata with highest sequence number
 	MetaData *common.MetaData `json:"metadata,omitempty"`
+	// Version of the metadata, and the fields of metadata version 2 and 3, if any
+	MetaDataVersion           types.MetaDataVersion `json:"metadata_version,omitempty"`
+	MetaDataSyncnets          *types.SyncnetBits    `json:"metadata_syncnets,omitempty"`
+	MetaDataCustodyGroupCount *view.Uint64View      `json:"metadata_custody_group_count,omitempty"`
 	// Highest claimed seq nr, we may not have the actual corresponding metadata yet.
 	ClaimedSeq common.SeqNr `json:"claimed_seq,omitempty"`
 	// Latest status
 	Status *common.Status `json:"status,omitempty"`
+	// Version of the status, and the earliest available slot if the status is version 2 or later
+	StatusVersion         types.StatusVersion `json:"status_version,omitempty"`
+	EarliestAvailableSlot *common.Slot        `json:"earliest_available_slot,omitempty"`
+	// Label of the latest status, see StatusClassifier
+	StatusRelevance Relevance `json:"status_relevance,omitempty"`
 	// Latest ENR
 	ENR *enode.Node `json:"enr,omitempty"`
+
+	// When any eth2 data of the peer was first stored
+	FirstSeen *time.Time `json:"first_seen,omitempty"`
+	// When any eth2 data of the peer was last updated
+	LastUpdated *time.Time `json:"last_updated,omitempty"`
+	// When the individual eth2 data was last updated
+	StatusUpdated   *time.Time `json:"status_updated,omitempty"`
+	MetadataUpdated *time.Time `json:"metadata_updated,omitempty"`
+	ClaimUpdated    *time.Time `json:"claimed_seq_updated,omitempty"`
+	ENRUpdated      *time.Time `json:"enr_updated,omitempty"`
+
+	// Fields (by json name) for which no data is known yet
+	Missing []string `json:"missing,omitempty"`
+	// Fields (by json name) which could not be retrieved, with the error message
+	Errors map[string]string `json:"errors,omitempty"`
 }
 
 func (p *PeerAllData) String() string {
@@ -84,9 +200,62 @@ func (p *PeerAllData) String() string {
 }
 
 type AllDataGetter interface {
+	// GetAllData collects everything known about the peer.
+	// Unknown or unreadable fields are reported in the Missing and Errors fields,
+	// and do not cause the whole retrieval to fail.
 	GetAllData(ctx context.Context, id peer.ID) (*PeerAllData, error)
 }
 
+// PeerCursor marks a position in the enumeration of eth2 peers.
+// The empty cursor marks the start of the enumeration.
+type PeerCursor string
+
+// PeerIterator enumerates the peers that have eth2 data stored, ordered by their datastore key.
+type PeerIterator interface {
+	// Eth2Peers lists up to limit peers after the given cursor, no limit if limit <= 0.
+	// The returned cursor continues the listing, and is empty when the end was reached.
+	Eth2Peers(ctx context.Context, after PeerCursor, limit int) (ids []peer.ID, next PeerCursor, err error)
+	// IteratePeers streams the data of every peer after the given cursor to fn,
+	// until fn returns false, the context is canceled, or all peers are visited.
+	// The returned cursor continues the iteration, and is empty when all peers were visited.
+	IteratePeers(ctx context.Context, after PeerCursor, fn func(data *PeerAllData) bool) (next PeerCursor, err error)
+}
+
+// PeerIndex looks up peers through secondary indexes, maintained together with the eth2 data.
+type PeerIndex interface {
+	// PeersOnAttnet lists the peers advertising the attestation subnet in their ENR or metadata.
+	PeersOnAttnet(ctx context.Context, subnet uint64) ([]peer.ID, error)
+	// PeersOnSyncnet lists the peers advertising the sync committee subnet in their ENR or metadata.
+	PeersOnSyncnet(ctx context.Context, subnet uint64) ([]peer.ID, error)
+	// PeersWithForkDigest lists the peers with the fork digest in their ENR or status.
+	PeersWithForkDigest(ctx context.Context, digest common.ForkDigest) ([]peer.ID, error)
+	// PeerByNodeID finds the peer with the given node ID, as known from its ENR.
+	PeerByNodeID(ctx context.Context, id enode.ID) (peer.ID, error)
+	// RebuildIndexes recomputes all indexes, e.g. for datastores created before indexing.
+	// Peers with data that cannot be read are reported in the error, their other data is still indexed.
+	RebuildIndexes(ctx context.Context) error
+}
+
+type ENRAdder interface {
+	// AddENR registers a discovered node, deriving the peer ID from the ENR pubkey.
+	// The pubkey is added to the key book, the ENR addresses to the address book with the given TTL,
+	// and the ENR to the ENR book if it has a higher seq nr.
+	AddENR(ctx context.Context, n *enode.Node, ttl time.Duration) (id peer.ID, updated bool, err error)
+}
+
+type PeerRemover interface {
+	// RemovePeerData removes all eth2 data of the peer in a single datastore batch,
+	// followed by the libp2p data, like the libp2p RemovePeer.
+	// Like the libp2p RemovePeer, addresses are left to expire in the address book.
+	RemovePeerData(ctx context.Context, id peer.ID) error
+	// RemoveStatus removes the status of the peer, if any
+	RemoveStatus(context.Context, peer.ID) error
+	// RemoveMetadata removes the metadata, claimed seq nr and fetch counter of the peer, if any
+	RemoveMetadata(context.Context, peer.ID) error
+	// RemoveENR removes the ENR of the peer, if any
+	RemoveENR(ctx context.Context, id peer.ID) error
+}
+
 type TeedDatastore interface {
 	// AddTee registers a tee, and returns true if it was already registered
 	AddTee(tee dstee.Tee) (exists bool)
@@ -101,8 +270,19 @@ type ExtendedPeerstore interface {
 	Datastore() ds.Batching
 	peerstore.Peerstore
 	StatusBook
+	VersionedStatusBook
+	StatusHistoryBook
+	StatusRelevanceBook
 	MetadataBook
+	VersionedMetadataBook
 	ENRBook
+	ENRHistoryBook
+	SeenBook
 	AllDataGetter
+	PeerIterator
+	PeerQuerier
+	PeerIndex
+	PeerRemover
+	ENRAdder
 	// TODO: maybe track when we've last been connected to a peer?
 }
@@ -0,0 +1,112 @@
+// Package peerdas implements the PeerDAS custody assignment of the Fulu consensus specs.
+package peerdas
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"sort"
+)
+
+const (
+	NUMBER_OF_COLUMNS        = 128
+	NUMBER_OF_CUSTODY_GROUPS = 128
+	// CUSTODY_REQUIREMENT is the minimum custody group count of a node, and the default if the count is unknown
+	CUSTODY_REQUIREMENT = 4
+)
+
+type CustodyIndex uint64
+
+type ColumnIndex uint64
+
+// GetCustodyGroups returns the sorted custody groups of the node, as in get_custody_groups of the spec.
+func GetCustodyGroups(nodeID enode.ID, custodyGroupCount uint64) ([]CustodyIndex, error) {
+	if custodyGroupCount > NUMBER_OF_CUSTODY_GROUPS {
+		return nil, fmt.Errorf("custody group count %d is larger than the number of custody groups %d",
+			custodyGroupCount, NUMBER_OF_CUSTODY_GROUPS)
+	}
+	out := make([]CustodyIndex, 0, custodyGroupCount)
+	if custodyGroupCount == NUMBER_OF_CUSTODY_GROUPS {
+		for i := CustodyIndex(0); i < NUMBER_OF_CUSTODY_GROUPS; i++ {
+			out = append(out, i)
+		}
+		return out, nil
+	}
+	// the node ID is a big-endian uint256, hashed as little-endian uint256
+	var current [32]byte
+	for i := 0; i < 32; i++ {
+		current[i] = nodeID[31-i]
+	}
+	var seen [NUMBER_OF_CUSTODY_GROUPS]bool
+	for uint64(len(out)) < custodyGroupCount {
+		h := sha256.Sum256(current[:])
+		group := CustodyIndex(binary.LittleEndian.Uint64(h[:8]) % NUMBER_OF_CUSTODY_GROUPS)
+		if !seen[group] {
+			seen[group] = true
+			out = append(out, group)
+		}
+		// increment, wrapping around to 0 after UINT256_MAX
+		for i := 0; i < 32; i++ {
+			current[i]++
+			if current[i] != 0 {
+				break
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i] < out[j]
+	})
+	return out, nil
+}
+
+// ComputeColumnsForCustodyGroup returns the columns of the custody group, as in compute_columns_for_custody_group of the spec.
+func ComputeColumnsForCustodyGroup(group CustodyIndex) ([]ColumnIndex, error) {
+	if group >= NUMBER_OF_CUSTODY_GROUPS {
+		return nil, fmt.Errorf("custody group %d is out of range", group)
+	}
+	columnsPerGroup := uint64(NUMBER_OF_COLUMNS / NUMBER_OF_CUSTODY_GROUPS)
+	out := make([]ColumnIndex, 0, columnsPerGroup)
+	for i := uint64(0); i < columnsPerGroup; i++ {
+		out = append(out, ColumnIndex(NUMBER_OF_CUSTODY_GROUPS*i+uint64(group)))
+	}
+	return out, nil
+}
+
+// CustodyColumns returns the sorted columns the node custodies, given its custody group count.
+func CustodyColumns(nodeID enode.ID, custodyGroupCount uint64) ([]ColumnIndex, error) {
+	groups, err := GetCustodyGroups(nodeID, custodyGroupCount)
+	if err != nil {
+		return nil, err
+	}
+	var out []ColumnIndex
+	for _, g := range groups {
+		columns, err := ComputeColumnsForCustodyGroup(g)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, columns...)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i] < out[j]
+	})
+	return out, nil
+}
+
+// CustodiesColumn returns true if the node custodies the given column, given its custody group count.
+func CustodiesColumn(nodeID enode.ID, custodyGroupCount uint64, column ColumnIndex) (bool, error) {
+	if column >= NUMBER_OF_COLUMNS {
+		return false, fmt.Errorf("column %d is out of range", column)
+	}
+	groups, err := GetCustodyGroups(nodeID, custodyGroupCount)
+	if err != nil {
+		return false, err
+	}
+	group := CustodyIndex(uint64(column) % NUMBER_OF_CUSTODY_GROUPS)
+	for _, g := range groups {
+		if g == group {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,97 @@
+package peerdas
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+const (
+	zeroID = "0000000000000000000000000000000000000000000000000000000000000000"
+	oneID  = "0000000000000000000000000000000000000000000000000000000000000001"
+	midID  = "8000000000000000000000000000000000000000000000000000000000000000"
+	maxID  = "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"
+	someID = "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb"
+)
+
+func allGroups() []CustodyIndex {
+	out := make([]CustodyIndex, NUMBER_OF_CUSTODY_GROUPS)
+	for i := range out {
+		out[i] = CustodyIndex(i)
+	}
+	return out
+}
+
+// Expected groups are computed with get_custody_groups of the Fulu consensus specs.
+func TestGetCustodyGroups(t *testing.T) {
+	cases := []struct {
+		name   string
+		nodeID string
+		count  uint64
+		groups []CustodyIndex
+	}{
+		{"min node ID, no custody", zeroID, 0, []CustodyIndex{}},
+		{"min node ID, single group", zeroID, 1, []CustodyIndex{102}},
+		{"min node ID, custody requirement", zeroID, CUSTODY_REQUIREMENT, []CustodyIndex{1, 17, 87, 102}},
+		{"min node ID, 8 groups", zeroID, 8, []CustodyIndex{1, 17, 19, 42, 75, 87, 102, 117}},
+		{"min node ID, all groups", zeroID, NUMBER_OF_CUSTODY_GROUPS, allGroups()},
+		{"one node ID, custody requirement", oneID, CUSTODY_REQUIREMENT, []CustodyIndex{1, 17, 75, 87}},
+		{"one node ID, 8 groups", oneID, 8, []CustodyIndex{1, 6, 17, 19, 42, 75, 87, 117}},
+		{"mid node ID, custody requirement", midID, CUSTODY_REQUIREMENT, []CustodyIndex{5, 26, 99, 102}},
+		{"max node ID, single group", maxID, 1, []CustodyIndex{47}},
+		// the node ID wraps around to 0 after the max node ID
+		{"max node ID, custody requirement", maxID, CUSTODY_REQUIREMENT, []CustodyIndex{1, 47, 87, 102}},
+		{"max node ID, 8 groups", maxID, 8, []CustodyIndex{1, 17, 19, 42, 47, 75, 87, 102}},
+		{"max node ID, all groups", maxID, NUMBER_OF_CUSTODY_GROUPS, allGroups()},
+		{"some node ID, custody requirement", someID, CUSTODY_REQUIREMENT, []CustodyIndex{14, 16, 109, 118}},
+		{"some node ID, 8 groups", someID, 8, []CustodyIndex{11, 14, 16, 40, 50, 78, 109, 118}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			groups, err := GetCustodyGroups(enode.HexID(c.nodeID), c.count)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(groups, c.groups) {
+				t.Fatalf("got %v, expected %v", groups, c.groups)
+			}
+		})
+	}
+}
+
+func TestGetCustodyGroupsTooMany(t *testing.T) {
+	if _, err := GetCustodyGroups(enode.HexID(zeroID), NUMBER_OF_CUSTODY_GROUPS+1); err == nil {
+		t.Fatal("expected error for custody group count above the number of custody groups")
+	}
+}
+
+func TestCustodyColumns(t *testing.T) {
+	cases := []struct {
+		name    string
+		nodeID  string
+		count   uint64
+		columns []ColumnIndex
+	}{
+		// with as many columns as custody groups, each group maps to the column with the same index
+		{"min node ID, custody requirement", zeroID, CUSTODY_REQUIREMENT, []ColumnIndex{1, 17, 87, 102}},
+		{"some node ID, custody requirement", someID, CUSTODY_REQUIREMENT, []ColumnIndex{14, 16, 109, 118}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			id := enode.HexID(c.nodeID)
+			columns, err := CustodyColumns(id, c.count)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(columns, c.columns) {
+				t.Fatalf("got %v, expected %v", columns, c.columns)
+			}
+			for _, col := range columns {
+				if ok, err := CustodiesColumn(id, c.count, col); err != nil || !ok {
+					t.Fatalf("expected column %d to be custodied, err: %v", col, err)
+				}
+			}
+		})
+	}
+}
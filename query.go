@@ -0,0 +1,221 @@
+package eth2peerstore
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/protolambda/go-eth2-peerstore/peerdas"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/ztyp/bitfields"
+)
+
+// PeerFilter selects peers based on their data.
+type PeerFilter func(data *PeerAllData) bool
+
+// PeerOrder returns true if peer a should be sorted before peer b.
+type PeerOrder func(a, b *PeerAllData) bool
+
+// PeerQuery describes which peers to select, and how to sort them.
+type PeerQuery struct {
+	// Filter to select peers with, all peers are selected if nil.
+	Filter PeerFilter
+	// Order to sort the peers by, ordered by datastore key if nil.
+	Order PeerOrder
+	// Limit the amount of results, no limit if <= 0.
+	Limit int
+}
+
+type PeerQuerier interface {
+	// QueryPeers returns the IDs of all eth2 peers that match the query.
+	QueryPeers(ctx context.Context, q PeerQuery) ([]peer.ID, error)
+	// PeersCustodyingColumn returns the IDs of all eth2 peers that custody the given PeerDAS data column.
+	PeersCustodyingColumn(ctx context.Context, column peerdas.ColumnIndex) ([]peer.ID, error)
+}
+
+// All selects peers that match all the given filters
+func All(filters ...PeerFilter) PeerFilter {
+	return func(data *PeerAllData) bool {
+		for _, f := range filters {
+			if !f(data) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Any selects peers that match any of the given filters
+func Any(filters ...PeerFilter) PeerFilter {
+	return func(data *PeerAllData) bool {
+		for _, f := range filters {
+			if f(data) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Not selects peers that do not match the given filter
+func Not(f PeerFilter) PeerFilter {
+	return func(data *PeerAllData) bool {
+		return !f(data)
+	}
+}
+
+// WithForkDigest selects peers with the given fork digest in their ENR
+func WithForkDigest(digest common.ForkDigest) PeerFilter {
+	return func(data *PeerAllData) bool {
+		return data.ForkDigest != nil && *data.ForkDigest == digest
+	}
+}
+
+// WithStatusForkDigest selects peers with the given fork digest in their latest status
+func WithStatusForkDigest(digest common.ForkDigest) PeerFilter {
+	return func(data *PeerAllData) bool {
+		return data.Status != nil && data.Status.ForkDigest == digest
+	}
+}
+
+// OnAttnet selects peers advertising the given attestation subnet, in their metadata or ENR
+func OnAttnet(subnet uint64) PeerFilter {
+	return func(data *PeerAllData) bool {
+		if subnet >= common.ATTESTATION_SUBNET_COUNT {
+			return false
+		}
+		if data.MetaData != nil && bitfields.GetBit(data.MetaData.Attnets[:], subnet) {
+			return true
+		}
+		return data.Attnets != nil && bitfields.GetBit(data.Attnets[:], subnet)
+	}
+}
+
+// PeerCustodyGroupCount returns the PeerDAS custody group count of the peer:
+// from its metadata if known, else from its ENR, else the minimum custody requirement.
+func PeerCustodyGroupCount(data *PeerAllData) uint64 {
+	if data.MetaDataCustodyGroupCount != nil {
+		return uint64(*data.MetaDataCustodyGroupCount)
+	}
+	if data.CustodyGroupCount != nil {
+		return *data.CustodyGroupCount
+	}
+	return peerdas.CUSTODY_REQUIREMENT
+}
+
+// CustodiesColumn selects peers that custody the given PeerDAS data column, see PeerCustodyGroupCount
+func CustodiesColumn(column peerdas.ColumnIndex) PeerFilter {
+	return func(data *PeerAllData) bool {
+		// the custody assignment depends on the node ID, unknown without pubkey
+		if data.NodeID == (enode.ID{}) {
+			return false
+		}
+		ok, err := peerdas.CustodiesColumn(data.NodeID, PeerCustodyGroupCount(data), column)
+		return err == nil && ok
+	}
+}
+
+// OnSyncnet selects peers advertising the given sync committee subnet, in their metadata or ENR
+func OnSyncnet(subnet uint64) PeerFilter {
+	return func(data *PeerAllData) bool {
+		if subnet >= common.SYNC_COMMITTEE_SUBNET_COUNT {
+			return false
+		}
+		if data.MetaDataSyncnets != nil && bitfields.GetBit(data.MetaDataSyncnets[:], subnet) {
+			return true
+		}
+		return data.Syncnets != nil && bitfields.GetBit(data.Syncnets[:], subnet)
+	}
+}
+
+// ClientIs selects peers running any of the given clients, by canonical name, see agent.KnownClients
+func ClientIs(names ...string) PeerFilter {
+	return func(data *PeerAllData) bool {
+		if data.Client == nil {
+			return false
+		}
+		for _, name := range names {
+			if data.Client.Is(name) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// HeadSlotWithin selects peers with a status head slot no more than distance slots away from the given slot
+func HeadSlotWithin(slot common.Slot, distance common.Slot) PeerFilter {
+	return func(data *PeerAllData) bool {
+		if data.Status == nil {
+			return false
+		}
+		head := data.Status.HeadSlot
+		if head > slot {
+			return head-slot <= distance
+		}
+		return slot-head <= distance
+	}
+}
+
+// minEpochsForBlockRequests is the number of epochs before the current epoch that nodes must serve blocks for,
+// as derived in the phase0 p2p spec.
+func minEpochsForBlockRequests(spec *common.Spec) common.Epoch {
+	return spec.MIN_VALIDATOR_WITHDRAWABILITY_DELAY + common.Epoch(spec.CHURN_LIMIT_QUOTIENT/2)
+}
+
+// CanServeSlotRange selects peers that can serve blocks for the count slots starting at start:
+// the range must end at or before their status head slot, and start at or after their earliest available slot.
+// Peers with a version 1 status do not report an earliest available slot, and may have pruned older blocks:
+// they are only assumed to serve the MIN_EPOCHS_FOR_BLOCK_REQUESTS epochs of the spec before the epoch of their head.
+func CanServeSlotRange(spec *common.Spec, start common.Slot, count uint64) PeerFilter {
+	minEpochs := minEpochsForBlockRequests(spec)
+	return func(data *PeerAllData) bool {
+		if data.Status == nil || count == 0 {
+			return false
+		}
+		earliest := common.Slot(0)
+		if data.EarliestAvailableSlot != nil {
+			earliest = *data.EarliestAvailableSlot
+		} else if headEpoch := spec.SlotToEpoch(data.Status.HeadSlot); headEpoch > minEpochs {
+			if slot, err := spec.EpochStartSlot(headEpoch - minEpochs); err == nil {
+				earliest = slot
+			}
+		}
+		if start < earliest {
+			return false
+		}
+		return uint64(start)+count-1 <= uint64(data.Status.HeadSlot)
+	}
+}
+
+// SupportsProtocol selects peers that support the given protocol, e.g. "/eth2/beacon_chain/req/blocks_by_range/2"
+func SupportsProtocol(protocol string) PeerFilter {
+	return func(data *PeerAllData) bool {
+		for _, p := range data.Protocols {
+			if p == protocol {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// ByPeerID sorts peers by their peer ID
+func ByPeerID(a, b *PeerAllData) bool {
+	return a.PeerID < b.PeerID
+}
+
+// ByHeadSlot sorts peers by their status head slot, highest first. Peers without status go last.
+func ByHeadSlot(a, b *PeerAllData) bool {
+	if a.Status == nil || b.Status == nil {
+		return a.Status != nil
+	}
+	return a.Status.HeadSlot > b.Status.HeadSlot
+}
+
+// ByLatency sorts peers by their latency, lowest first. Peers without latency measurement go last.
+func ByLatency(a, b *PeerAllData) bool {
+	if a.Latency == 0 || b.Latency == 0 {
+		return a.Latency != 0
+	}
+	return a.Latency < b.Latency
+}
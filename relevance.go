@@ -0,0 +1,114 @@
+package eth2peerstore
+
+import (
+	"fmt"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+// Relevance labels a peer status by how useful the peer is to the local chain
+type Relevance uint8
+
+const (
+	// RelevanceUnknown if the status was not classified
+	RelevanceUnknown Relevance = iota
+	// Relevant peers follow the local chain, with a head close to the local head
+	Relevant
+	// Behind peers follow the local chain, with a head before the local head
+	Behind
+	// Ahead peers may follow the local chain, with a head or finalized checkpoint after the local one.
+	// Their finalized checkpoint cannot be verified until the local chain catches up.
+	Ahead
+	// WrongFork peers have a different fork digest than the local chain
+	WrongFork
+	// IrrelevantFinalized peers finalized a checkpoint that is not in the local chain
+	IrrelevantFinalized
+)
+
+func (r Relevance) String() string {
+	switch r {
+	case Relevant:
+		return "relevant"
+	case Behind:
+		return "behind"
+	case Ahead:
+		return "ahead"
+	case WrongFork:
+		return "wrong_fork"
+	case IrrelevantFinalized:
+		return "irrelevant_finalized"
+	default:
+		return "unknown"
+	}
+}
+
+func (r Relevance) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *Relevance) UnmarshalText(text []byte) error {
+	for _, v := range []Relevance{RelevanceUnknown, Relevant, Behind, Ahead, WrongFork, IrrelevantFinalized} {
+		if v.String() == string(text) {
+			*r = v
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown relevance: %q", text)
+}
+
+// LocalChainView is the view of the local chain to classify peer statuses against
+type LocalChainView interface {
+	// ForkDigest is the current fork digest of the local chain
+	ForkDigest() common.ForkDigest
+	// Finalized is the finalized checkpoint of the local chain
+	Finalized() common.Checkpoint
+	// HeadSlot is the slot of the head of the local chain
+	HeadSlot() common.Slot
+	// CheckpointRoot returns the root of the block at the start slot of the epoch in the local canonical chain,
+	// i.e. the root of the checkpoint at the epoch. False if unknown, e.g. if pruned or after the local head.
+	CheckpointRoot(epoch common.Epoch) (common.Root, bool)
+}
+
+// StatusClassifier labels peer statuses, following the status handshake rules of the consensus p2p spec.
+type StatusClassifier struct {
+	Local LocalChainView
+	// HeadSlotTolerance is the distance to the local head slot within which peers are Relevant, rather than Behind or Ahead
+	HeadSlotTolerance common.Slot
+}
+
+// Classify labels the status:
+// WrongFork if the fork digest differs from the local fork digest,
+// IrrelevantFinalized if the finalized checkpoint is not in the local chain (when it can be checked),
+// Ahead if the finalized checkpoint or head is after the local one, Behind if the head is before the local head,
+// and Relevant otherwise.
+func (c *StatusClassifier) Classify(st *common.Status) Relevance {
+	if st.ForkDigest != c.Local.ForkDigest() {
+		return WrongFork
+	}
+	finalized := c.Local.Finalized()
+	// the genesis checkpoint has a zero root, and is shared by every chain
+	if st.FinalizedEpoch > 0 && st.FinalizedEpoch <= finalized.Epoch {
+		if root, ok := c.Local.CheckpointRoot(st.FinalizedEpoch); ok && root != st.FinalizedRoot {
+			return IrrelevantFinalized
+		}
+	}
+	headSlot := c.Local.HeadSlot()
+	if st.FinalizedEpoch > finalized.Epoch || st.HeadSlot > headSlot+c.HeadSlotTolerance {
+		return Ahead
+	}
+	if st.HeadSlot+c.HeadSlotTolerance < headSlot {
+		return Behind
+	}
+	return Relevant
+}
+
+// RelevanceIs selects peers with a status classified with any of the given labels
+func RelevanceIs(labels ...Relevance) PeerFilter {
+	return func(data *PeerAllData) bool {
+		for _, l := range labels {
+			if data.StatusRelevance == l {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,159 @@
+package subnets
+
+import (
+	"context"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/protolambda/go-eth2-peerstore"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/ztyp/bitfields"
+	"sort"
+	"time"
+)
+
+// Divergence describes how the ENR attnets of a peer differ from its MetaData attnets
+type Divergence struct {
+	PeerID peer.ID `json:"peer_id"`
+	// Sequence numbers of the compared ENR and MetaData
+	ENRSeq      uint64       `json:"enr_seq"`
+	MetaDataSeq common.SeqNr `json:"metadata_seq"`
+	// Subnets only advertised in the ENR, and only in the MetaData
+	OnlyENR      []uint64 `json:"only_enr"`
+	OnlyMetaData []uint64 `json:"only_metadata"`
+	// StaleENR and StaleMetaData mark the source that is likely outdated, at most one is true.
+	// Clients bump both the ENR seq and the MetaData seq number when their attnets change,
+	// so the source with the lower seq number is outdated, or if a newer MetaData seq number was claimed,
+	// the MetaData is outdated. If the seq numbers do not tell, the source that was updated first is outdated.
+	// Neither is true if neither the seq numbers nor the update times tell.
+	StaleENR      bool `json:"stale_enr"`
+	StaleMetaData bool `json:"stale_metadata"`
+	// Since is when the stored ENR and MetaData started to diverge: the latest update of either, if known.
+	// The peer may have diverged before that, but this is not tracked.
+	Since *time.Time `json:"since,omitempty"`
+	// Duration the divergence persisted, up to the time of comparison. Zero if Since is not known.
+	Duration time.Duration `json:"duration,omitempty"`
+}
+
+// Diverged returns true if the ENR and MetaData attnets of the peer are both known, and differ.
+func Diverged(data *eth2peerstore.PeerAllData) bool {
+	return data.Attnets != nil && data.MetaData != nil && *data.Attnets != data.MetaData.Attnets
+}
+
+// Compare compares the ENR and MetaData attnets of the peer, and returns nil if they did not diverge, see Diverged.
+// The duration of the divergence is measured up to now.
+func Compare(data *eth2peerstore.PeerAllData, now time.Time) *Divergence {
+	if !Diverged(data) {
+		return nil
+	}
+	out := &Divergence{
+		PeerID:       data.PeerID,
+		MetaDataSeq:  data.MetaData.SeqNumber,
+		OnlyENR:      []uint64{},
+		OnlyMetaData: []uint64{},
+	}
+	if data.ENR != nil {
+		out.ENRSeq = data.ENR.Seq()
+	}
+	for i := uint64(0); i < ATTESTATION_SUBNET_COUNT; i++ {
+		inENR := bitfields.GetBit(data.Attnets[:], i)
+		inMetaData := bitfields.GetBit(data.MetaData.Attnets[:], i)
+		if inENR && !inMetaData {
+			out.OnlyENR = append(out.OnlyENR, i)
+		} else if inMetaData && !inENR {
+			out.OnlyMetaData = append(out.OnlyMetaData, i)
+		}
+	}
+	switch {
+	case data.ClaimedSeq > out.MetaDataSeq:
+		out.StaleMetaData = true
+	case out.ENRSeq != 0 && out.ENRSeq < uint64(out.MetaDataSeq):
+		out.StaleENR = true
+	case out.ENRSeq != 0 && out.ENRSeq > uint64(out.MetaDataSeq):
+		out.StaleMetaData = true
+	case data.ENRUpdated != nil && data.MetadataUpdated != nil && !data.ENRUpdated.Equal(*data.MetadataUpdated):
+		out.StaleENR = data.ENRUpdated.Before(*data.MetadataUpdated)
+		out.StaleMetaData = !out.StaleENR
+	}
+	if data.ENRUpdated != nil && data.MetadataUpdated != nil {
+		since := *data.ENRUpdated
+		if data.MetadataUpdated.After(since) {
+			since = *data.MetadataUpdated
+		}
+		out.Since = &since
+		out.Duration = now.Sub(since)
+	}
+	return out
+}
+
+// AttnetsDiverged selects peers with differing ENR and MetaData attnets, see Diverged.
+func AttnetsDiverged() eth2peerstore.PeerFilter {
+	return Diverged
+}
+
+// ConsistencyReport summarizes the ENR and MetaData attnets consistency of the peers of a peerstore.
+type ConsistencyReport struct {
+	// When the report was made, divergence durations are measured up to this time
+	Time time.Time `json:"time"`
+	// Number of peers with both ENR and MetaData attnets known
+	Peers int `json:"peers"`
+	// Number of peers with equal ENR and MetaData attnets
+	Consistent int `json:"consistent"`
+	// Number of diverged peers with an ENR that is outdated compared to the MetaData, and the other way around.
+	// Peers for which this is not known are not counted in either, see Divergence.StaleENR.
+	StaleENR      int `json:"stale_enr"`
+	StaleMetaData int `json:"stale_metadata"`
+	// Number of diverged peers per subnet, advertised only in the ENR, and only in the MetaData
+	OnlyENR      [ATTESTATION_SUBNET_COUNT]int `json:"only_enr"`
+	OnlyMetaData [ATTESTATION_SUBNET_COUNT]int `json:"only_metadata"`
+	// Diverged peers, longest divergence first
+	Divergences []*Divergence `json:"divergences"`
+}
+
+// Diverged returns the number of diverged peers
+func (r *ConsistencyReport) Diverged() int {
+	return len(r.Divergences)
+}
+
+func (r *ConsistencyReport) add(data *eth2peerstore.PeerAllData) {
+	if data.Attnets == nil || data.MetaData == nil {
+		return
+	}
+	r.Peers += 1
+	d := Compare(data, r.Time)
+	if d == nil {
+		r.Consistent += 1
+		return
+	}
+	if d.StaleENR {
+		r.StaleENR += 1
+	}
+	if d.StaleMetaData {
+		r.StaleMetaData += 1
+	}
+	for _, s := range d.OnlyENR {
+		r.OnlyENR[s] += 1
+	}
+	for _, s := range d.OnlyMetaData {
+		r.OnlyMetaData[s] += 1
+	}
+	r.Divergences = append(r.Divergences, d)
+}
+
+// Consistency compares the ENR and MetaData attnets of all eth2 peers of the peerstore.
+// Divergence durations are measured up to now.
+func Consistency(ctx context.Context, ps eth2peerstore.PeerIterator, now time.Time) (*ConsistencyReport, error) {
+	r := &ConsistencyReport{Time: now, Divergences: []*Divergence{}}
+	_, err := ps.IteratePeers(ctx, "", func(data *eth2peerstore.PeerAllData) bool {
+		r.add(data)
+		return ctx.Err() == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(r.Divergences, func(i, j int) bool {
+		return r.Divergences[i].Duration > r.Divergences[j].Duration
+	})
+	return r, nil
+}
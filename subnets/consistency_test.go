@@ -0,0 +1,162 @@
+package subnets
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	gcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/protolambda/go-eth2-peerstore"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+// sliceIterator iterates over the data of a fixed list of peers
+type sliceIterator []*eth2peerstore.PeerAllData
+
+func (s sliceIterator) Eth2Peers(ctx context.Context, after eth2peerstore.PeerCursor, limit int) ([]peer.ID, eth2peerstore.PeerCursor, error) {
+	ids := make([]peer.ID, 0, len(s))
+	for _, d := range s {
+		ids = append(ids, d.PeerID)
+	}
+	return ids, "", nil
+}
+
+func (s sliceIterator) IteratePeers(ctx context.Context, after eth2peerstore.PeerCursor, fn func(data *eth2peerstore.PeerAllData) bool) (eth2peerstore.PeerCursor, error) {
+	for _, d := range s {
+		if !fn(d) {
+			return eth2peerstore.PeerCursor(d.PeerID), nil
+		}
+	}
+	return "", nil
+}
+
+func testNode(t *testing.T, seq uint64) *enode.Node {
+	t.Helper()
+	k, err := gcrypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec enr.Record
+	rec.SetSeq(seq)
+	if err := enode.SignV4(&rec, k); err != nil {
+		t.Fatal(err)
+	}
+	n, err := enode.New(enode.ValidSchemes, &rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestCompare(t *testing.T) {
+	now := time.Date(2025, 12, 3, 21, 49, 11, 0, time.UTC)
+	earlier, later := now.Add(-time.Hour), now.Add(-time.Minute)
+	cases := []struct {
+		name          string
+		enrSeq        uint64
+		metadataSeq   uint64
+		claimedSeq    uint64
+		enrUpdated    *time.Time
+		mdUpdated     *time.Time
+		staleENR      bool
+		staleMetaData bool
+	}{
+		{"newer claimed metadata", 5, 5, 6, nil, nil, false, true},
+		// the claim outweighs the ENR seq number
+		{"newer claimed metadata, older enr", 3, 5, 6, nil, nil, false, true},
+		{"older enr seq", 4, 5, 0, nil, nil, true, false},
+		{"older metadata seq", 6, 5, 0, nil, nil, false, true},
+		// the seq numbers outweigh the update times
+		{"older enr seq, updated later", 4, 5, 0, &later, &earlier, true, false},
+		{"equal seqs, enr updated first", 5, 5, 0, &earlier, &later, true, false},
+		{"equal seqs, metadata updated first", 5, 5, 5, &later, &earlier, false, true},
+		{"equal seqs, updated at once", 5, 5, 0, &later, &later, false, false},
+		{"equal seqs, update times unknown", 5, 5, 0, nil, &later, false, false},
+		{"no enr, enr updated first", 0, 5, 0, &earlier, &later, true, false},
+		{"no enr, update times unknown", 0, 5, 0, nil, nil, false, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			data := &eth2peerstore.PeerAllData{
+				PeerID:          "a",
+				Attnets:         attnets(1, 2, 3),
+				MetaData:        &common.MetaData{SeqNumber: common.SeqNr(c.metadataSeq), Attnets: *attnets(2, 3, 4, 5)},
+				ClaimedSeq:      common.SeqNr(c.claimedSeq),
+				ENRUpdated:      c.enrUpdated,
+				MetadataUpdated: c.mdUpdated,
+			}
+			if c.enrSeq != 0 {
+				data.ENR = testNode(t, c.enrSeq)
+			}
+			d := Compare(data, now)
+			if d == nil {
+				t.Fatal("no divergence")
+			}
+			if d.StaleENR != c.staleENR || d.StaleMetaData != c.staleMetaData {
+				t.Fatalf("got stale enr %v, stale metadata %v, expected %v, %v", d.StaleENR, d.StaleMetaData, c.staleENR, c.staleMetaData)
+			}
+			if d.ENRSeq != c.enrSeq || d.MetaDataSeq != common.SeqNr(c.metadataSeq) {
+				t.Fatalf("got seqs %d (enr), %d (metadata)", d.ENRSeq, d.MetaDataSeq)
+			}
+			if !reflect.DeepEqual(d.OnlyENR, []uint64{1}) || !reflect.DeepEqual(d.OnlyMetaData, []uint64{4, 5}) {
+				t.Fatalf("got only enr %v, only metadata %v", d.OnlyENR, d.OnlyMetaData)
+			}
+			if c.enrUpdated != nil && c.mdUpdated != nil {
+				if d.Since == nil || !d.Since.Equal(later) || d.Duration != time.Minute {
+					t.Fatalf("diverged since %v for %s, expected since %v", d.Since, d.Duration, later)
+				}
+			} else if d.Since != nil || d.Duration != 0 {
+				t.Fatalf("diverged since %v for %s, expected unknown", d.Since, d.Duration)
+			}
+		})
+	}
+}
+
+func TestCompareNotDiverged(t *testing.T) {
+	cases := []struct {
+		name string
+		data eth2peerstore.PeerAllData
+	}{
+		{"equal", eth2peerstore.PeerAllData{Attnets: attnets(1, 2), MetaData: &common.MetaData{Attnets: *attnets(1, 2)}}},
+		{"no metadata", eth2peerstore.PeerAllData{Attnets: attnets(1, 2)}},
+		{"no enr attnets", eth2peerstore.PeerAllData{MetaData: &common.MetaData{Attnets: *attnets(1, 2)}}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if d := Compare(&c.data, time.Now()); d != nil {
+				t.Fatalf("unexpected divergence %+v", d)
+			}
+		})
+	}
+}
+
+func TestConsistency(t *testing.T) {
+	now := time.Date(2025, 12, 3, 21, 49, 11, 0, time.UTC)
+	hourAgo, minuteAgo := now.Add(-time.Hour), now.Add(-time.Minute)
+	peers := sliceIterator{
+		{PeerID: "consistent", Attnets: attnets(1), MetaData: &common.MetaData{Attnets: *attnets(1)}},
+		{PeerID: "recent", Attnets: attnets(1), MetaData: &common.MetaData{Attnets: *attnets(2)},
+			ENRUpdated: &minuteAgo, MetadataUpdated: &minuteAgo},
+		{PeerID: "old", Attnets: attnets(1), MetaData: &common.MetaData{SeqNumber: 1, Attnets: *attnets(1, 2)},
+			ClaimedSeq: 2, ENRUpdated: &hourAgo, MetadataUpdated: &hourAgo},
+		{PeerID: "unknown", Attnets: attnets(1)},
+	}
+	r, err := Consistency(context.Background(), peers, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Peers != 3 || r.Consistent != 1 || r.Diverged() != 2 || r.StaleENR != 0 || r.StaleMetaData != 1 {
+		t.Fatalf("unexpected report %+v", r)
+	}
+	if r.OnlyENR[1] != 1 || r.OnlyMetaData[2] != 2 {
+		t.Fatalf("got only enr %v, only metadata %v", r.OnlyENR, r.OnlyMetaData)
+	}
+	// longest divergence first
+	if r.Divergences[0].PeerID != "old" || r.Divergences[1].PeerID != "recent" {
+		t.Fatalf("unexpected divergence order %v, %v", r.Divergences[0].PeerID, r.Divergences[1].PeerID)
+	}
+}
@@ -0,0 +1,240 @@
+package subnets
+
+import (
+	"context"
+	"fmt"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/protolambda/go-eth2-peerstore"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/ztyp/bitfields"
+	"sort"
+	"time"
+)
+
+// Connectedness reports the libp2p connectedness of a peer, e.g. the network of a libp2p host.
+type Connectedness interface {
+	Connectedness(peer.ID) network.Connectedness
+}
+
+// SubnetPeer is a peer that advertises a subnet
+type SubnetPeer struct {
+	PeerID peer.ID `json:"peer_id"`
+	// If the subnet is advertised in the ENR, and in the MetaData
+	ENR      bool `json:"enr"`
+	MetaData bool `json:"metadata"`
+	// Connected is only known if the coverage has a network attached
+	Connected bool `json:"connected"`
+	// Fresh if the eth2 data of the peer was updated within the max age of the coverage
+	Fresh       bool          `json:"fresh"`
+	LastUpdated *time.Time    `json:"last_updated,omitempty"`
+	Latency     time.Duration `json:"latency,omitempty"`
+	// Flagged if the peer is selected by the flag filter of the coverage
+	Flagged bool `json:"flagged,omitempty"`
+}
+
+// SubnetCoverage counts the peers that advertise a subnet
+type SubnetCoverage struct {
+	Subnet uint64 `json:"subnet"`
+	// Number of peers advertising the subnet in their ENR, MetaData, or both
+	Peers int `json:"peers"`
+	// Number of peers per source. Peers that advertise the subnet in both are counted in both.
+	ENR      int `json:"enr"`
+	MetaData int `json:"metadata"`
+	// Number of connected peers, zero if the coverage has no network attached
+	Connected int `json:"connected"`
+	// Number of peers with eth2 data updated within the max age
+	Fresh int `json:"fresh"`
+	// Number of peers that are flagged
+	Flagged int `json:"flagged"`
+	// The peers, ranked, see Coverage.PeersForSubnet
+	PeerList []SubnetPeer `json:"peer_list"`
+}
+
+func (sc *SubnetCoverage) add(p SubnetPeer) {
+	sc.Peers += 1
+	if p.ENR {
+		sc.ENR += 1
+	}
+	if p.MetaData {
+		sc.MetaData += 1
+	}
+	if p.Connected {
+		sc.Connected += 1
+	}
+	if p.Fresh {
+		sc.Fresh += 1
+	}
+	if p.Flagged {
+		sc.Flagged += 1
+	}
+	sc.PeerList = append(sc.PeerList, p)
+}
+
+// CoverageReport is the coverage of all attestation and sync committee subnets
+type CoverageReport struct {
+	Time     time.Time                                          `json:"time"`
+	Attnets  [ATTESTATION_SUBNET_COUNT]SubnetCoverage           `json:"attnets"`
+	Syncnets [common.SYNC_COMMITTEE_SUBNET_COUNT]SubnetCoverage `json:"syncnets"`
+}
+
+// Coverage maps the attestation and sync committee subnets to the stored peers that advertise them.
+type Coverage struct {
+	Peerstore eth2peerstore.ExtendedPeerstore
+	// Network is optional, to rank connected peers first
+	Network Connectedness
+	// MaxAge is the age of eth2 data up to which peers are considered fresh. All peers are fresh if zero.
+	MaxAge time.Duration
+	// Flag is optional, to rank the selected peers last, e.g. Verifier.Flagged
+	Flag eth2peerstore.PeerFilter
+	// Clock to measure freshness with, time.Now if nil
+	Clock func() time.Time
+}
+
+func (c *Coverage) now() time.Time {
+	if c.Clock == nil {
+		return time.Now()
+	}
+	return c.Clock()
+}
+
+func (c *Coverage) subnetPeer(data *eth2peerstore.PeerAllData, enr bool, metadata bool, now time.Time) SubnetPeer {
+	p := SubnetPeer{
+		PeerID:      data.PeerID,
+		ENR:         enr,
+		MetaData:    metadata,
+		LastUpdated: data.LastUpdated,
+		Latency:     data.Latency,
+	}
+	if c.Network != nil {
+		p.Connected = c.Network.Connectedness(data.PeerID) == network.Connected
+	}
+	p.Fresh = c.MaxAge == 0 || (data.LastUpdated != nil && now.Sub(*data.LastUpdated) <= c.MaxAge)
+	if c.Flag != nil {
+		p.Flagged = c.Flag(data)
+	}
+	return p
+}
+
+// rank sorts connected peers first, then unflagged peers, then fresh peers, then by lowest latency,
+// and then by most recent update.
+func rank(peers []SubnetPeer) {
+	sort.SliceStable(peers, func(i, j int) bool {
+		a, b := &peers[i], &peers[j]
+		if a.Connected != b.Connected {
+			return a.Connected
+		}
+		if a.Flagged != b.Flagged {
+			return b.Flagged
+		}
+		if a.Fresh != b.Fresh {
+			return a.Fresh
+		}
+		if a.Latency != b.Latency {
+			if a.Latency == 0 || b.Latency == 0 {
+				return a.Latency != 0
+			}
+			return a.Latency < b.Latency
+		}
+		if a.LastUpdated == nil || b.LastUpdated == nil {
+			return a.LastUpdated != nil
+		}
+		return a.LastUpdated.After(*b.LastUpdated)
+	})
+}
+
+func attnetSources(data *eth2peerstore.PeerAllData, subnet uint64) (enr bool, metadata bool) {
+	enr = data.Attnets != nil && bitfields.GetBit(data.Attnets[:], subnet)
+	metadata = data.MetaData != nil && bitfields.GetBit(data.MetaData.Attnets[:], subnet)
+	return
+}
+
+func syncnetSources(data *eth2peerstore.PeerAllData, subnet uint64) (enr bool, metadata bool) {
+	enr = data.Syncnets != nil && bitfields.GetBit(data.Syncnets[:], subnet)
+	metadata = data.MetaDataSyncnets != nil && bitfields.GetBit(data.MetaDataSyncnets[:], subnet)
+	return
+}
+
+// Report computes the coverage of every subnet, in a single pass over all eth2 peers.
+func (c *Coverage) Report(ctx context.Context) (*CoverageReport, error) {
+	now := c.now()
+	r := &CoverageReport{Time: now}
+	for i := range r.Attnets {
+		r.Attnets[i] = SubnetCoverage{Subnet: uint64(i), PeerList: []SubnetPeer{}}
+	}
+	for i := range r.Syncnets {
+		r.Syncnets[i] = SubnetCoverage{Subnet: uint64(i), PeerList: []SubnetPeer{}}
+	}
+	_, err := c.Peerstore.IteratePeers(ctx, "", func(data *eth2peerstore.PeerAllData) bool {
+		for i := range r.Attnets {
+			if enr, md := attnetSources(data, uint64(i)); enr || md {
+				r.Attnets[i].add(c.subnetPeer(data, enr, md, now))
+			}
+		}
+		for i := range r.Syncnets {
+			if enr, md := syncnetSources(data, uint64(i)); enr || md {
+				r.Syncnets[i].add(c.subnetPeer(data, enr, md, now))
+			}
+		}
+		return ctx.Err() == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	for i := range r.Attnets {
+		rank(r.Attnets[i].PeerList)
+	}
+	for i := range r.Syncnets {
+		rank(r.Syncnets[i].PeerList)
+	}
+	return r, nil
+}
+
+func (c *Coverage) peersFor(ctx context.Context, ids []peer.ID, limit int,
+	sources func(data *eth2peerstore.PeerAllData) (bool, bool)) ([]SubnetPeer, error) {
+	now := c.now()
+	out := make([]SubnetPeer, 0, len(ids))
+	for _, id := range ids {
+		data, err := c.Peerstore.GetAllData(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get data of peer %s: %w", id.Pretty(), err)
+		}
+		// the index may list both sources, only keep the peer if the data still advertises the subnet
+		if enr, md := sources(data); enr || md {
+			out = append(out, c.subnetPeer(data, enr, md, now))
+		}
+	}
+	rank(out)
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+// PeersForSubnet returns up to limit peers that advertise the attestation subnet, no limit if limit <= 0.
+// Connected peers go first, flagged peers last, and otherwise fresh peers with the lowest latency are preferred,
+// and then the most recently updated peers.
+func (c *Coverage) PeersForSubnet(ctx context.Context, subnet uint64, limit int) ([]SubnetPeer, error) {
+	ids, err := c.Peerstore.PeersOnAttnet(ctx, subnet)
+	if err != nil {
+		return nil, err
+	}
+	return c.peersFor(ctx, ids, limit, func(data *eth2peerstore.PeerAllData) (bool, bool) {
+		return attnetSources(data, subnet)
+	})
+}
+
+// PeersForSyncnet returns up to limit peers that advertise the sync committee subnet, no limit if limit <= 0.
+// Peers are ranked like PeersForSubnet.
+func (c *Coverage) PeersForSyncnet(ctx context.Context, subnet uint64, limit int) ([]SubnetPeer, error) {
+	ids, err := c.Peerstore.PeersOnSyncnet(ctx, subnet)
+	if err != nil {
+		return nil, err
+	}
+	return c.peersFor(ctx, ids, limit, func(data *eth2peerstore.PeerAllData) (bool, bool) {
+		return syncnetSources(data, subnet)
+	})
+}
@@ -0,0 +1,198 @@
+package subnets
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"reflect"
+	"testing"
+	"time"
+
+	gcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-peerstore/pstoreds"
+	"github.com/protolambda/go-eth2-peerstore"
+	"github.com/protolambda/go-eth2-peerstore/addrutil"
+	"github.com/protolambda/go-eth2-peerstore/dstrack"
+	"github.com/protolambda/go-eth2-peerstore/types"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+// connectedness reports the listed peers as connected
+type connectedness map[peer.ID]bool
+
+func (c connectedness) Connectedness(id peer.ID) network.Connectedness {
+	if c[id] {
+		return network.Connected
+	}
+	return network.NotConnected
+}
+
+func subnetENR(t *testing.T, k *ecdsa.PrivateKey, attnets *common.AttnetBits, syncnets *types.SyncnetBits) *enode.Node {
+	t.Helper()
+	var rec enr.Record
+	if attnets != nil {
+		rec.Set(addrutil.NewAttnetsENREntry(attnets))
+	}
+	if syncnets != nil {
+		rec.Set(addrutil.NewSyncnetsENREntry(syncnets))
+	}
+	if err := enode.SignV4(&rec, k); err != nil {
+		t.Fatal(err)
+	}
+	n, err := enode.New(enode.ValidSchemes, &rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestCoverage(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2025, 12, 3, 20, 0, 0, 0, time.UTC)
+	clock := start
+	ps, err := dstrack.NewExtendedPeerstore(ctx, dssync.MutexWrap(ds.NewMapDatastore()), pstoreds.DefaultOpts(),
+		dstrack.WithClock(func() time.Time { return clock }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+
+	ids := make([]peer.ID, 5)
+	keys := make([]*ecdsa.PrivateKey, 5)
+	for i := range ids {
+		k, err := gcrypto.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[i], ids[i] = k, addrutil.PeerIDFromPubkey(&k.PublicKey)
+	}
+	register := func(i int, latency time.Duration, subnets ...uint64) {
+		t.Helper()
+		ps.RecordLatency(ids[i], latency)
+		if _, err := ps.RegisterMetadata(ctx, ids[i], common.MetaData{SeqNumber: 1, Attnets: *attnets(subnets...)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// stale, with the lowest latency
+	register(0, time.Millisecond, 0)
+	clock = start.Add(time.Hour)
+	// fresh, and advertises subnet 1 in its ENR only
+	register(1, 3*time.Millisecond, 0)
+	if _, err := ps.UpdateENRMaybe(ctx, ids[1], subnetENR(t, keys[1], attnets(0, 1), nil)); err != nil {
+		t.Fatal(err)
+	}
+	// connected, with a higher latency than the flagged peer
+	register(2, 2*time.Millisecond, 0)
+	// flagged
+	register(3, time.Millisecond, 0)
+	var syncnets types.SyncnetBits
+	syncnets[0] = 1 << 2
+	if _, err := ps.UpdateENRMaybe(ctx, ids[4], subnetENR(t, keys[4], nil, &syncnets)); err != nil {
+		t.Fatal(err)
+	}
+
+	now := start.Add(time.Hour + time.Minute)
+	c := &Coverage{
+		Peerstore: ps,
+		Network:   connectedness{ids[2]: true},
+		MaxAge:    10 * time.Minute,
+		Flag: func(data *eth2peerstore.PeerAllData) bool {
+			return data.PeerID == ids[3]
+		},
+		Clock: func() time.Time { return now },
+	}
+	r, err := c.Report(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Time.Equal(now) {
+		t.Fatalf("got report time %v, expected %v", r.Time, now)
+	}
+	order := func(peers []SubnetPeer) (out []peer.ID) {
+		for _, p := range peers {
+			out = append(out, p.PeerID)
+		}
+		return
+	}
+	sc := r.Attnets[0]
+	if sc.Peers != 4 || sc.ENR != 1 || sc.MetaData != 4 || sc.Connected != 1 || sc.Fresh != 3 || sc.Flagged != 1 {
+		t.Fatalf("unexpected coverage of subnet 0: %+v", sc)
+	}
+	// connected first, flagged last, and fresh before lower latency
+	expected := []peer.ID{ids[2], ids[1], ids[0], ids[3]}
+	if got := order(sc.PeerList); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("got subnet 0 peers %v, expected %v", got, expected)
+	}
+	if p := sc.PeerList[3]; !p.Flagged || !p.Fresh || p.Connected || p.Latency != time.Millisecond || !p.LastUpdated.Equal(start.Add(time.Hour)) {
+		t.Fatalf("unexpected flagged peer %+v", p)
+	}
+	if p := sc.PeerList[2]; p.Fresh || !p.LastUpdated.Equal(start) {
+		t.Fatalf("unexpected stale peer %+v", p)
+	}
+	sc = r.Attnets[1]
+	if sc.Peers != 1 || sc.ENR != 1 || sc.MetaData != 0 || !sc.PeerList[0].ENR || sc.PeerList[0].MetaData {
+		t.Fatalf("unexpected coverage of subnet 1: %+v", sc)
+	}
+	if sc = r.Attnets[2]; sc.Peers != 0 || sc.PeerList == nil {
+		t.Fatalf("unexpected coverage of subnet 2: %+v", sc)
+	}
+	if sc = r.Syncnets[2]; sc.Peers != 1 || sc.PeerList[0].PeerID != ids[4] || !sc.PeerList[0].ENR {
+		t.Fatalf("unexpected coverage of syncnet 2: %+v", sc)
+	}
+
+	// the selection is ranked like the report
+	selected, err := c.PeersForSubnet(ctx, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := order(selected); !reflect.DeepEqual(got, expected[:2]) {
+		t.Fatalf("got selected peers %v, expected %v", got, expected[:2])
+	}
+	if selected, err = c.PeersForSubnet(ctx, 0, 0); err != nil || len(selected) != 4 {
+		t.Fatalf("got %d peers without limit, err %v", len(selected), err)
+	}
+	if selected, err = c.PeersForSyncnet(ctx, 2, 0); err != nil || !reflect.DeepEqual(order(selected), []peer.ID{ids[4]}) {
+		t.Fatalf("got syncnet peers %v, err %v", order(selected), err)
+	}
+
+	// all peers are fresh without max age
+	c.MaxAge = 0
+	if r, err = c.Report(ctx); err != nil || r.Attnets[0].Fresh != 4 {
+		t.Fatalf("got %+v, err %v, expected all peers fresh", r.Attnets[0], err)
+	}
+}
+
+func TestRank(t *testing.T) {
+	now := time.Date(2025, 12, 3, 21, 49, 11, 0, time.UTC)
+	earlier := now.Add(-time.Minute)
+	peers := []SubnetPeer{
+		{PeerID: "unknown update"},
+		{PeerID: "earlier update", LastUpdated: &earlier},
+		{PeerID: "later update", LastUpdated: &now},
+		{PeerID: "high latency", Latency: 2 * time.Millisecond},
+		{PeerID: "low latency", Latency: time.Millisecond},
+		{PeerID: "stale", Latency: time.Millisecond},
+		{PeerID: "flagged", Flagged: true, Fresh: true},
+		{PeerID: "connected", Connected: true, Flagged: true},
+	}
+	for i := range peers {
+		if peers[i].PeerID != "stale" && peers[i].PeerID != "connected" {
+			peers[i].Fresh = true
+		}
+	}
+	rank(peers)
+	expected := []peer.ID{"connected", "low latency", "high latency", "later update",
+		"earlier update", "unknown update", "stale", "flagged"}
+	var got []peer.ID
+	for _, p := range peers {
+		got = append(got, p.PeerID)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("got order %v, expected %v", got, expected)
+	}
+}
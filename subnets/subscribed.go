@@ -0,0 +1,56 @@
+// Package subnets implements the node ID based attestation subnet subscriptions of the Deneb consensus specs,
+// and verifies the attnets that peers advertise against them.
+package subnets
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"sort"
+)
+
+const (
+	ATTESTATION_SUBNET_COUNT       = 64
+	ATTESTATION_SUBNET_EXTRA_BITS  = 0
+	ATTESTATION_SUBNET_PREFIX_BITS = 6 + ATTESTATION_SUBNET_EXTRA_BITS
+	EPOCHS_PER_SUBNET_SUBSCRIPTION = 256
+	SUBNETS_PER_NODE               = 2
+	NODE_ID_BITS                   = 256
+	// SHUFFLE_ROUND_COUNT of the mainnet preset, the minimal preset uses 10 rounds.
+	SHUFFLE_ROUND_COUNT = 90
+)
+
+// ComputeSubscribedSubnet returns the index-th subnet the node subscribes to at the epoch,
+// as in compute_subscribed_subnet of the spec.
+func ComputeSubscribedSubnet(nodeID enode.ID, epoch common.Epoch, index uint64, shuffleRoundCount uint8) uint64 {
+	// the node ID is a big-endian uint256: the prefix is in the first byte, the offset in the last byte.
+	nodeIDPrefix := uint64(nodeID[0]) >> (8 - ATTESTATION_SUBNET_PREFIX_BITS)
+	nodeOffset := uint64(nodeID[31]) % EPOCHS_PER_SUBNET_SUBSCRIPTION
+	var period [8]byte
+	binary.LittleEndian.PutUint64(period[:], (uint64(epoch)+nodeOffset)/EPOCHS_PER_SUBNET_SUBSCRIPTION)
+	seed := common.Root(sha256.Sum256(period[:]))
+	permutatedPrefix := common.PermuteIndex(shuffleRoundCount, common.ValidatorIndex(nodeIDPrefix),
+		1<<ATTESTATION_SUBNET_PREFIX_BITS, seed)
+	return (uint64(permutatedPrefix) + index) % ATTESTATION_SUBNET_COUNT
+}
+
+// ComputeSubscribedSubnets returns the sorted subnets the node subscribes to at the epoch,
+// as in compute_subscribed_subnets of the spec.
+func ComputeSubscribedSubnets(nodeID enode.ID, epoch common.Epoch, shuffleRoundCount uint8) []uint64 {
+	out := make([]uint64, 0, SUBNETS_PER_NODE)
+	for i := uint64(0); i < SUBNETS_PER_NODE; i++ {
+		out = append(out, ComputeSubscribedSubnet(nodeID, epoch, i, shuffleRoundCount))
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i] < out[j]
+	})
+	return out
+}
+
+// NextSubscriptionChange returns the first epoch after the given epoch at which the subnets of the node rotate.
+func NextSubscriptionChange(nodeID enode.ID, epoch common.Epoch) common.Epoch {
+	nodeOffset := uint64(nodeID[31]) % EPOCHS_PER_SUBNET_SUBSCRIPTION
+	period := (uint64(epoch) + nodeOffset) / EPOCHS_PER_SUBNET_SUBSCRIPTION
+	return common.Epoch((period+1)*EPOCHS_PER_SUBNET_SUBSCRIPTION - nodeOffset)
+}
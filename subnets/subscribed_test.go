@@ -0,0 +1,87 @@
+package subnets
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+const (
+	zeroID = "0000000000000000000000000000000000000000000000000000000000000000"
+	oneID  = "0000000000000000000000000000000000000000000000000000000000000001"
+	midID  = "8000000000000000000000000000000000000000000000000000000000000000"
+	maxID  = "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"
+	someID = "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb"
+)
+
+// Expected subnets are computed with compute_subscribed_subnets of the consensus specs, mainnet preset.
+func TestComputeSubscribedSubnets(t *testing.T) {
+	cases := []struct {
+		name    string
+		nodeID  string
+		epoch   common.Epoch
+		subnets []uint64
+	}{
+		{"min node ID, genesis", zeroID, 0, []uint64{49, 50}},
+		{"min node ID, end of first period", zeroID, 255, []uint64{49, 50}},
+		{"min node ID, second period", zeroID, 256, []uint64{16, 17}},
+		{"min node ID, late epoch", zeroID, 300000, []uint64{12, 13}},
+		{"one node ID, genesis", oneID, 0, []uint64{49, 50}},
+		// the node offset of 1 moves the rotation one epoch earlier
+		{"one node ID, second period", oneID, 255, []uint64{16, 17}},
+		{"one node ID, after rotation", oneID, 256, []uint64{16, 17}},
+		{"one node ID, late epoch", oneID, 300000, []uint64{12, 13}},
+		{"mid node ID, genesis", midID, 0, []uint64{27, 28}},
+		{"mid node ID, end of first period", midID, 255, []uint64{27, 28}},
+		{"mid node ID, second period", midID, 256, []uint64{52, 53}},
+		{"mid node ID, late epoch", midID, 300000, []uint64{37, 38}},
+		{"max node ID, genesis", maxID, 0, []uint64{57, 58}},
+		{"max node ID, second period", maxID, 255, []uint64{55, 56}},
+		{"max node ID, still second period", maxID, 256, []uint64{55, 56}},
+		{"max node ID, late epoch", maxID, 300000, []uint64{7, 8}},
+		{"some node ID, genesis", someID, 0, []uint64{61, 62}},
+		{"some node ID, epoch 255", someID, 255, []uint64{56, 57}},
+		{"some node ID, epoch 256", someID, 256, []uint64{56, 57}},
+		{"some node ID, late epoch", someID, 300000, []uint64{42, 43}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			subnets := ComputeSubscribedSubnets(enode.HexID(c.nodeID), c.epoch, SHUFFLE_ROUND_COUNT)
+			if !reflect.DeepEqual(subnets, c.subnets) {
+				t.Fatalf("got %v, expected %v", subnets, c.subnets)
+			}
+		})
+	}
+}
+
+func TestNextSubscriptionChange(t *testing.T) {
+	cases := []struct {
+		name   string
+		nodeID string
+		epoch  common.Epoch
+		next   common.Epoch
+	}{
+		{"min node ID, genesis", zeroID, 0, 256},
+		{"min node ID, last epoch of period", zeroID, 255, 256},
+		{"min node ID, first epoch of period", zeroID, 256, 512},
+		{"one node ID, genesis", oneID, 0, 255},
+		{"one node ID, rotation epoch", oneID, 255, 511},
+		{"max node ID, genesis", maxID, 0, 1},
+		{"max node ID, rotation epoch", maxID, 1, 257},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			id := enode.HexID(c.nodeID)
+			next := NextSubscriptionChange(id, c.epoch)
+			if next != c.next {
+				t.Fatalf("got %d, expected %d", next, c.next)
+			}
+			before := ComputeSubscribedSubnets(id, next-1, SHUFFLE_ROUND_COUNT)
+			if at := ComputeSubscribedSubnets(id, c.epoch, SHUFFLE_ROUND_COUNT); !reflect.DeepEqual(at, before) {
+				t.Fatalf("subnets changed before the next subscription change: %v at %d, %v at %d", at, c.epoch, before, next-1)
+			}
+		})
+	}
+}
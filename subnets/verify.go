@@ -0,0 +1,147 @@
+package subnets
+
+import (
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/protolambda/go-eth2-peerstore"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/ztyp/bitfields"
+)
+
+// Verdict is the outcome of checking advertised attnets against the expected subscriptions
+type Verdict uint8
+
+const (
+	// Unknown if the attnets or node ID of the peer are not known
+	Unknown Verdict = iota
+	// Consistent if all expected subnets are advertised. Additional subnets are allowed.
+	Consistent
+	// Missing if no subnets are advertised at all
+	Missing
+	// Inconsistent if some, but not all of the expected subnets are advertised
+	Inconsistent
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case Consistent:
+		return "consistent"
+	case Missing:
+		return "missing"
+	case Inconsistent:
+		return "inconsistent"
+	default:
+		return "unknown"
+	}
+}
+
+func (v Verdict) MarshalText() ([]byte, error) {
+	return []byte(v.String()), nil
+}
+
+// Verification is the result of verifying the attnets of a peer
+type Verification struct {
+	// Expected subnets at the epoch of verification
+	Expected []uint64 `json:"expected"`
+	// Verdict of the ENR attnets
+	ENR Verdict `json:"enr"`
+	// Verdict of the MetaData attnets
+	MetaData Verdict `json:"metadata"`
+}
+
+// Ok is true if none of the known attnets are missing or inconsistent
+func (v *Verification) Ok() bool {
+	return (v.ENR == Unknown || v.ENR == Consistent) && (v.MetaData == Unknown || v.MetaData == Consistent)
+}
+
+// Verifier checks the advertised attnets of peers against the subnets derived from their node ID.
+type Verifier struct {
+	// ShuffleRoundCount of the preset, SHUFFLE_ROUND_COUNT if zero
+	ShuffleRoundCount uint8
+	// Tolerance is the number of epochs after a subscription change during which the previous subnets are accepted,
+	// to allow for delayed ENR and metadata updates. The union of the expected subnets over this range is accepted.
+	Tolerance common.Epoch
+}
+
+func (v *Verifier) rounds() uint8 {
+	if v.ShuffleRoundCount == 0 {
+		return SHUFFLE_ROUND_COUNT
+	}
+	return v.ShuffleRoundCount
+}
+
+// accepted returns the expected subnets at the epoch, and the subnets that are accepted within the tolerance.
+func (v *Verifier) accepted(nodeID enode.ID, epoch common.Epoch) (expected []uint64, accepted []common.AttnetBits) {
+	expected = ComputeSubscribedSubnets(nodeID, epoch, v.rounds())
+	var bits common.AttnetBits
+	for _, s := range expected {
+		bitfields.SetBit(bits[:], s, true)
+	}
+	accepted = append(accepted, bits)
+	if v.Tolerance > 0 {
+		start := common.Epoch(0)
+		if epoch > v.Tolerance {
+			start = epoch - v.Tolerance
+		}
+		// only a single change can happen within the tolerance, if it is smaller than a subscription period
+		if change := NextSubscriptionChange(nodeID, start); change <= epoch && start < change {
+			var prev common.AttnetBits
+			for _, s := range ComputeSubscribedSubnets(nodeID, start, v.rounds()) {
+				bitfields.SetBit(prev[:], s, true)
+			}
+			accepted = append(accepted, prev)
+		}
+	}
+	return expected, accepted
+}
+
+func verdict(advertised *common.AttnetBits, accepted []common.AttnetBits) Verdict {
+	if advertised == nil {
+		return Unknown
+	}
+	if *advertised == (common.AttnetBits{}) {
+		return Missing
+	}
+	for _, want := range accepted {
+		ok := true
+		for i := range want {
+			if want[i]&advertised[i] != want[i] {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return Consistent
+		}
+	}
+	return Inconsistent
+}
+
+// Verify checks the ENR and MetaData attnets of the peer against the expected subnets at the epoch.
+func (v *Verifier) Verify(data *eth2peerstore.PeerAllData, epoch common.Epoch) Verification {
+	if data.NodeID == (enode.ID{}) {
+		return Verification{}
+	}
+	expected, accepted := v.accepted(data.NodeID, epoch)
+	out := Verification{Expected: expected, ENR: verdict(data.Attnets, accepted)}
+	if data.MetaData != nil {
+		out.MetaData = verdict(&data.MetaData.Attnets, accepted)
+	}
+	return out
+}
+
+// Flagged selects peers with missing or inconsistent ENR or MetaData attnets at the epoch
+func (v *Verifier) Flagged(epoch common.Epoch) eth2peerstore.PeerFilter {
+	return func(data *eth2peerstore.PeerAllData) bool {
+		res := v.Verify(data, epoch)
+		return !res.Ok()
+	}
+}
+
+// ConsistentFirst sorts peers with consistent attnets before peers that are flagged, see Flagged.
+// Use with a secondary order to select subnet peers.
+func (v *Verifier) ConsistentFirst(epoch common.Epoch) eth2peerstore.PeerOrder {
+	return func(a, b *eth2peerstore.PeerAllData) bool {
+		ra, rb := v.Verify(a, epoch), v.Verify(b, epoch)
+		return ra.Ok() && !rb.Ok()
+	}
+}
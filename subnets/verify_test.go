@@ -0,0 +1,108 @@
+package subnets
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/protolambda/go-eth2-peerstore"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/ztyp/bitfields"
+)
+
+func attnets(subnets ...uint64) *common.AttnetBits {
+	var out common.AttnetBits
+	for _, s := range subnets {
+		bitfields.SetBit(out[:], s, true)
+	}
+	return &out
+}
+
+func TestVerify(t *testing.T) {
+	// the one node ID subscribes to 49, 50 up to epoch 254, and rotates to 16, 17 at epoch 255
+	id := enode.HexID(oneID)
+	v := &Verifier{Tolerance: 8}
+	cases := []struct {
+		name       string
+		verifier   *Verifier
+		epoch      common.Epoch
+		advertised *common.AttnetBits
+		verdict    Verdict
+	}{
+		{"current subnets", v, 262, attnets(16, 17), Consistent},
+		{"additional subnets", v, 262, attnets(16, 17, 30), Consistent},
+		{"all subnets", v, 262, attnets(allSubnets()...), Consistent},
+		{"previous subnets within tolerance", v, 262, attnets(49, 50), Consistent},
+		{"previous subnets after tolerance", v, 263, attnets(49, 50), Inconsistent},
+		{"current subnets after tolerance", v, 263, attnets(16, 17), Consistent},
+		{"previous subnets without tolerance", &Verifier{}, 255, attnets(49, 50), Inconsistent},
+		{"previous subnets before change", v, 254, attnets(49, 50), Consistent},
+		{"next subnets before change", v, 254, attnets(16, 17), Inconsistent},
+		// the subnets of before and after the change are not mixed
+		{"mixed subnets within tolerance", v, 262, attnets(17, 49), Inconsistent},
+		{"partial subnets", v, 262, attnets(16), Inconsistent},
+		{"other subnets", v, 262, attnets(3, 4), Inconsistent},
+		{"no subnets", v, 262, attnets(), Missing},
+		{"unknown subnets", v, 262, nil, Unknown},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			data := &eth2peerstore.PeerAllData{NodeID: id, Attnets: c.advertised}
+			if c.advertised != nil {
+				data.MetaData = &common.MetaData{Attnets: *c.advertised}
+			}
+			res := c.verifier.Verify(data, c.epoch)
+			if res.ENR != c.verdict || res.MetaData != c.verdict {
+				t.Fatalf("got verdicts %s (enr), %s (metadata), expected %s", res.ENR, res.MetaData, c.verdict)
+			}
+			if expected := ComputeSubscribedSubnets(id, c.epoch, SHUFFLE_ROUND_COUNT); !reflect.DeepEqual(res.Expected, expected) {
+				t.Fatalf("got expected subnets %v, expected %v", res.Expected, expected)
+			}
+			if ok := c.verdict == Consistent || c.verdict == Unknown; res.Ok() != ok {
+				t.Fatalf("got ok %v, expected %v", res.Ok(), ok)
+			}
+			if c.verifier.Flagged(c.epoch)(data) == res.Ok() {
+				t.Fatalf("flagged %v, with verification %+v", res.Ok(), res)
+			}
+		})
+	}
+}
+
+func allSubnets() []uint64 {
+	out := make([]uint64, 0, ATTESTATION_SUBNET_COUNT)
+	for i := uint64(0); i < ATTESTATION_SUBNET_COUNT; i++ {
+		out = append(out, i)
+	}
+	return out
+}
+
+func TestVerifySources(t *testing.T) {
+	v := &Verifier{}
+	id := enode.HexID(oneID)
+	// the ENR and metadata are judged separately
+	data := &eth2peerstore.PeerAllData{NodeID: id, Attnets: attnets(), MetaData: &common.MetaData{Attnets: *attnets(49, 50)}}
+	if res := v.Verify(data, 0); res.ENR != Missing || res.MetaData != Consistent || res.Ok() {
+		t.Fatalf("unexpected verification %+v", res)
+	}
+	// without metadata, the ENR alone is judged
+	data = &eth2peerstore.PeerAllData{NodeID: id, Attnets: attnets(49, 50)}
+	if res := v.Verify(data, 0); res.ENR != Consistent || res.MetaData != Unknown || !res.Ok() {
+		t.Fatalf("unexpected verification %+v", res)
+	}
+	// without node ID, nothing is expected
+	data = &eth2peerstore.PeerAllData{Attnets: attnets()}
+	if res := v.Verify(data, 0); !reflect.DeepEqual(res, Verification{}) {
+		t.Fatalf("unexpected verification %+v without node ID", res)
+	}
+}
+
+func TestConsistentFirst(t *testing.T) {
+	v := &Verifier{}
+	id := enode.HexID(oneID)
+	good := &eth2peerstore.PeerAllData{NodeID: id, Attnets: attnets(49, 50)}
+	bad := &eth2peerstore.PeerAllData{NodeID: id, Attnets: attnets(1, 2)}
+	less := v.ConsistentFirst(0)
+	if !less(good, bad) || less(bad, good) || less(good, good) {
+		t.Fatal("consistent peer is not ordered first")
+	}
+}
@@ -0,0 +1,51 @@
+package types
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// HistoryEntry is a timestamped item of a bounded history, e.g. of the statuses or ENRs of a peer.
+// A history is stored as a single value, oldest entry first, with each entry encoded as:
+// little-endian uint64 unix milliseconds, little-endian uint32 data length, data.
+type HistoryEntry struct {
+	TimeMs uint64
+	Data   []byte
+}
+
+// EncodeHistory encodes the entries, see HistoryEntry
+func EncodeHistory(entries []HistoryEntry) []byte {
+	size := 0
+	for _, e := range entries {
+		size += 8 + 4 + len(e.Data)
+	}
+	out := make([]byte, 0, size)
+	for _, e := range entries {
+		var h [8 + 4]byte
+		binary.LittleEndian.PutUint64(h[:8], e.TimeMs)
+		binary.LittleEndian.PutUint32(h[8:], uint32(len(e.Data)))
+		out = append(out, h[:]...)
+		out = append(out, e.Data...)
+	}
+	return out
+}
+
+// DecodeHistory decodes the entries of an encoded history, see HistoryEntry.
+// The data of the entries references the input.
+func DecodeHistory(v []byte) ([]HistoryEntry, error) {
+	var out []HistoryEntry
+	for len(v) > 0 {
+		if len(v) < 8+4 {
+			return nil, errors.New("history entry header is too short")
+		}
+		ms := binary.LittleEndian.Uint64(v[:8])
+		l := binary.LittleEndian.Uint32(v[8:12])
+		v = v[12:]
+		if uint64(len(v)) < uint64(l) {
+			return nil, errors.New("history entry data is too short")
+		}
+		out = append(out, HistoryEntry{TimeMs: ms, Data: v[:l]})
+		v = v[l:]
+	}
+	return out, nil
+}
@@ -0,0 +1,47 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHistoryRoundTrip(t *testing.T) {
+	entries := []HistoryEntry{
+		{TimeMs: 1733262551000, Data: []byte("first")},
+		{TimeMs: 1733262552123, Data: []byte{}},
+		{TimeMs: 1733262553000, Data: []byte("third")},
+	}
+	enc := EncodeHistory(entries)
+	if len(enc) != 3*(8+4)+len("first")+len("third") {
+		t.Fatalf("unexpected encoding length %d", len(enc))
+	}
+	dec, err := DecodeHistory(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dec, entries) {
+		t.Fatalf("got %v, expected %v", dec, entries)
+	}
+	if dec, err := DecodeHistory(nil); err != nil || len(dec) != 0 {
+		t.Fatalf("got %v, %v for empty history", dec, err)
+	}
+}
+
+func TestDecodeHistoryCorrupt(t *testing.T) {
+	enc := EncodeHistory([]HistoryEntry{{TimeMs: 1733262551000, Data: []byte("data")}})
+	cases := []struct {
+		name string
+		v    []byte
+	}{
+		{"short header", enc[:11]},
+		{"short data", enc[:len(enc)-1]},
+		{"trailing bytes", append(enc[:len(enc):len(enc)], 1, 2, 3)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := DecodeHistory(c.v); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
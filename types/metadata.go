@@ -0,0 +1,272 @@
+package types
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/ztyp/codec"
+	"github.com/protolambda/ztyp/tree"
+	. "github.com/protolambda/ztyp/view"
+)
+
+const syncnetByteLen = (common.SYNC_COMMITTEE_SUBNET_COUNT + 7) / 8
+
+// SyncnetBits is the Altair syncnets bitvector, of the sync committee subnets the peer is subscribed to
+type SyncnetBits [syncnetByteLen]byte
+
+func (sb *SyncnetBits) BitLen() uint64 {
+	return common.SYNC_COMMITTEE_SUBNET_COUNT
+}
+
+func (p *SyncnetBits) Deserialize(dr *codec.DecodingReader) error {
+	if p == nil {
+		return errors.New("nil syncnet bits")
+	}
+	_, err := dr.Read(p[:])
+	if err != nil {
+		return err
+	}
+	if p[0]>>common.SYNC_COMMITTEE_SUBNET_COUNT != 0 {
+		return fmt.Errorf("syncnet bits has bits set beyond bit length: %08b", p[0])
+	}
+	return nil
+}
+
+func (p SyncnetBits) Serialize(w *codec.EncodingWriter) error {
+	return w.Write(p[:])
+}
+
+func (p SyncnetBits) ByteLength() uint64 {
+	return syncnetByteLen
+}
+
+func (SyncnetBits) FixedLength() uint64 {
+	return syncnetByteLen
+}
+
+func (p SyncnetBits) HashTreeRoot(_ tree.HashFn) (out common.Root) {
+	copy(out[:], p[:])
+	return
+}
+
+func (p SyncnetBits) MarshalText() ([]byte, error) {
+	return []byte("0x" + hex.EncodeToString(p[:])), nil
+}
+
+func (p SyncnetBits) String() string {
+	return "0x" + hex.EncodeToString(p[:])
+}
+
+func (p *SyncnetBits) UnmarshalText(text []byte) error {
+	if p == nil {
+		return errors.New("cannot decode into nil SyncnetBits")
+	}
+	if len(text) >= 2 && text[0] == '0' && (text[1] == 'x' || text[1] == 'X') {
+		text = text[2:]
+	}
+	if len(text) != syncnetByteLen*2 {
+		return fmt.Errorf("unexpected length string '%s'", string(text))
+	}
+	_, err := hex.Decode(p[:], text)
+	return err
+}
+
+// MetaDataV2 is the Altair MetaData, served on the /eth2/beacon_chain/req/metadata/2/ protocol
+type MetaDataV2 struct {
+	SeqNumber common.SeqNr      `json:"seq_number" yaml:"seq_number"`
+	Attnets   common.AttnetBits `json:"attnets" yaml:"attnets"`
+	Syncnets  SyncnetBits       `json:"syncnets" yaml:"syncnets"`
+}
+
+func (d *MetaDataV2) Deserialize(dr *codec.DecodingReader) error {
+	return dr.FixedLenContainer(&d.SeqNumber, &d.Attnets, &d.Syncnets)
+}
+
+func (d *MetaDataV2) Serialize(w *codec.EncodingWriter) error {
+	return w.FixedLenContainer(&d.SeqNumber, &d.Attnets, &d.Syncnets)
+}
+
+const MetadataV2ByteLen = common.MetadataByteLen + syncnetByteLen
+
+func (d MetaDataV2) ByteLength() uint64 {
+	return MetadataV2ByteLen
+}
+
+func (*MetaDataV2) FixedLength() uint64 {
+	return MetadataV2ByteLen
+}
+
+func (d *MetaDataV2) HashTreeRoot(hFn tree.HashFn) common.Root {
+	return hFn.HashTreeRoot(&d.SeqNumber, &d.Attnets, &d.Syncnets)
+}
+
+func (m *MetaDataV2) String() string {
+	return fmt.Sprintf("MetaDataV2(seq: %d, attnets: %08b, syncnets: %04b)", m.SeqNumber, m.Attnets, m.Syncnets[0])
+}
+
+// MetaDataV3 is the Fulu MetaData, served on the /eth2/beacon_chain/req/metadata/3/ protocol
+type MetaDataV3 struct {
+	SeqNumber         common.SeqNr      `json:"seq_number" yaml:"seq_number"`
+	Attnets           common.AttnetBits `json:"attnets" yaml:"attnets"`
+	Syncnets          SyncnetBits       `json:"syncnets" yaml:"syncnets"`
+	CustodyGroupCount Uint64View        `json:"custody_group_count" yaml:"custody_group_count"`
+}
+
+func (d *MetaDataV3) Deserialize(dr *codec.DecodingReader) error {
+	return dr.FixedLenContainer(&d.SeqNumber, &d.Attnets, &d.Syncnets, &d.CustodyGroupCount)
+}
+
+func (d *MetaDataV3) Serialize(w *codec.EncodingWriter) error {
+	return w.FixedLenContainer(&d.SeqNumber, &d.Attnets, &d.Syncnets, &d.CustodyGroupCount)
+}
+
+const MetadataV3ByteLen = MetadataV2ByteLen + 8
+
+func (d MetaDataV3) ByteLength() uint64 {
+	return MetadataV3ByteLen
+}
+
+func (*MetaDataV3) FixedLength() uint64 {
+	return MetadataV3ByteLen
+}
+
+func (d *MetaDataV3) HashTreeRoot(hFn tree.HashFn) common.Root {
+	return hFn.HashTreeRoot(&d.SeqNumber, &d.Attnets, &d.Syncnets, &d.CustodyGroupCount)
+}
+
+func (m *MetaDataV3) String() string {
+	return fmt.Sprintf("MetaDataV3(seq: %d, attnets: %08b, syncnets: %04b, custody groups: %d)",
+		m.SeqNumber, m.Attnets, m.Syncnets[0], m.CustodyGroupCount)
+}
+
+// MetaDataVersion is the version of the MetaData req-resp protocol the metadata was served with
+type MetaDataVersion uint8
+
+const (
+	MetaDataVersion1 MetaDataVersion = 1
+	MetaDataVersion2 MetaDataVersion = 2
+	MetaDataVersion3 MetaDataVersion = 3
+)
+
+// VersionedMetaData holds MetaData of any version.
+// Syncnets is nil before version 2, CustodyGroupCount is nil before version 3.
+type VersionedMetaData struct {
+	Version           MetaDataVersion   `json:"version"`
+	SeqNumber         common.SeqNr      `json:"seq_number"`
+	Attnets           common.AttnetBits `json:"attnets"`
+	Syncnets          *SyncnetBits      `json:"syncnets,omitempty"`
+	CustodyGroupCount *Uint64View       `json:"custody_group_count,omitempty"`
+}
+
+func MetaDataFromV1(md common.MetaData) VersionedMetaData {
+	return VersionedMetaData{Version: MetaDataVersion1, SeqNumber: md.SeqNumber, Attnets: md.Attnets}
+}
+
+func MetaDataFromV2(md MetaDataV2) VersionedMetaData {
+	syncnets := md.Syncnets
+	return VersionedMetaData{Version: MetaDataVersion2, SeqNumber: md.SeqNumber, Attnets: md.Attnets, Syncnets: &syncnets}
+}
+
+func MetaDataFromV3(md MetaDataV3) VersionedMetaData {
+	syncnets := md.Syncnets
+	cgc := md.CustodyGroupCount
+	return VersionedMetaData{Version: MetaDataVersion3, SeqNumber: md.SeqNumber, Attnets: md.Attnets,
+		Syncnets: &syncnets, CustodyGroupCount: &cgc}
+}
+
+// V1 returns the phase0 view of the metadata, available for every version.
+func (m *VersionedMetaData) V1() common.MetaData {
+	return common.MetaData{SeqNumber: m.SeqNumber, Attnets: m.Attnets}
+}
+
+// V2 returns the Altair view of the metadata, ok is false if the metadata is older than version 2.
+func (m *VersionedMetaData) V2() (md MetaDataV2, ok bool) {
+	if m.Version < MetaDataVersion2 || m.Syncnets == nil {
+		return MetaDataV2{}, false
+	}
+	return MetaDataV2{SeqNumber: m.SeqNumber, Attnets: m.Attnets, Syncnets: *m.Syncnets}, true
+}
+
+// V3 returns the Fulu view of the metadata, ok is false if the metadata is older than version 3.
+func (m *VersionedMetaData) V3() (md MetaDataV3, ok bool) {
+	if m.Version < MetaDataVersion3 || m.Syncnets == nil || m.CustodyGroupCount == nil {
+		return MetaDataV3{}, false
+	}
+	return MetaDataV3{SeqNumber: m.SeqNumber, Attnets: m.Attnets, Syncnets: *m.Syncnets,
+		CustodyGroupCount: *m.CustodyGroupCount}, true
+}
+
+// EncodeTagged encodes the metadata as a version byte, followed by the SSZ encoding of that version.
+func (m *VersionedMetaData) EncodeTagged() ([]byte, error) {
+	var obj codec.Serializable
+	switch m.Version {
+	case MetaDataVersion1:
+		md := m.V1()
+		obj = &md
+	case MetaDataVersion2:
+		md, ok := m.V2()
+		if !ok {
+			return nil, errors.New("metadata v2 is missing syncnets")
+		}
+		obj = &md
+	case MetaDataVersion3:
+		md, ok := m.V3()
+		if !ok {
+			return nil, errors.New("metadata v3 is missing syncnets or custody group count")
+		}
+		obj = &md
+	default:
+		return nil, fmt.Errorf("unknown metadata version %d", m.Version)
+	}
+	var out bytes.Buffer
+	out.WriteByte(byte(m.Version))
+	if err := obj.Serialize(codec.NewEncodingWriter(&out)); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// DecodeTaggedMetaData decodes metadata encoded with EncodeTagged.
+// Untagged phase0 metadata, of exactly common.MetadataByteLen bytes, is decoded as version 1.
+func DecodeTaggedMetaData(data []byte) (*VersionedMetaData, error) {
+	dec := func(obj codec.Deserializable, v []byte) error {
+		return obj.Deserialize(codec.NewDecodingReader(bytes.NewReader(v), uint64(len(v))))
+	}
+	if len(data) == common.MetadataByteLen {
+		var md common.MetaData
+		if err := dec(&md, data); err != nil {
+			return nil, err
+		}
+		out := MetaDataFromV1(md)
+		return &out, nil
+	}
+	if len(data) == 0 {
+		return nil, errors.New("empty metadata")
+	}
+	var out VersionedMetaData
+	switch v, rest := MetaDataVersion(data[0]), data[1:]; v {
+	case MetaDataVersion1:
+		var md common.MetaData
+		if err := dec(&md, rest); err != nil {
+			return nil, err
+		}
+		out = MetaDataFromV1(md)
+	case MetaDataVersion2:
+		var md MetaDataV2
+		if err := dec(&md, rest); err != nil {
+			return nil, err
+		}
+		out = MetaDataFromV2(md)
+	case MetaDataVersion3:
+		var md MetaDataV3
+		if err := dec(&md, rest); err != nil {
+			return nil, err
+		}
+		out = MetaDataFromV3(md)
+	default:
+		return nil, fmt.Errorf("unknown metadata version %d", v)
+	}
+	return &out, nil
+}
@@ -0,0 +1,114 @@
+package types
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/ztyp/codec"
+	. "github.com/protolambda/ztyp/view"
+)
+
+func TestVersionedMetaDataRoundTrip(t *testing.T) {
+	syncnets := SyncnetBits{0b1010}
+	cgc := Uint64View(8)
+	attnets := common.AttnetBits{0xff, 0, 0, 0, 0, 0, 0, 0x80}
+	cases := []struct {
+		name    string
+		md      VersionedMetaData
+		byteLen int
+	}{
+		{"v1", MetaDataFromV1(common.MetaData{SeqNumber: 3, Attnets: attnets}), 1 + common.MetadataByteLen},
+		{"v2", MetaDataFromV2(MetaDataV2{SeqNumber: 4, Attnets: attnets, Syncnets: syncnets}), 1 + MetadataV2ByteLen},
+		{"v3", MetaDataFromV3(MetaDataV3{SeqNumber: 5, Attnets: attnets, Syncnets: syncnets, CustodyGroupCount: cgc}), 1 + MetadataV3ByteLen},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			data, err := c.md.EncodeTagged()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(data) != c.byteLen {
+				t.Fatalf("got %d bytes, expected %d", len(data), c.byteLen)
+			}
+			if MetaDataVersion(data[0]) != c.md.Version {
+				t.Fatalf("got version tag %d, expected %d", data[0], c.md.Version)
+			}
+			md, err := DecodeTaggedMetaData(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*md, c.md) {
+				t.Fatalf("got %v, expected %v", md, c.md)
+			}
+		})
+	}
+}
+
+func TestDecodeUntaggedMetaData(t *testing.T) {
+	cases := []struct {
+		name string
+		md   common.MetaData
+	}{
+		{"empty", common.MetaData{}},
+		// the first byte of untagged metadata may look like a version tag
+		{"seq looks like v2 tag", common.MetaData{SeqNumber: 2, Attnets: common.AttnetBits{1}}},
+		{"all attnets", common.MetaData{SeqNumber: 1 << 40, Attnets: common.AttnetBits{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := c.md.Serialize(codec.NewEncodingWriter(&buf)); err != nil {
+				t.Fatal(err)
+			}
+			md, err := DecodeTaggedMetaData(buf.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if md.Version != MetaDataVersion1 {
+				t.Fatalf("got version %d, expected 1", md.Version)
+			}
+			if md.V1() != c.md {
+				t.Fatalf("got %v, expected %v", md.V1(), c.md)
+			}
+			if _, ok := md.V2(); ok {
+				t.Fatal("untagged metadata has no v2 view")
+			}
+		})
+	}
+}
+
+func TestVersionedMetaDataInvalid(t *testing.T) {
+	encodeCases := []struct {
+		name string
+		md   VersionedMetaData
+	}{
+		{"unknown version", VersionedMetaData{Version: 4}},
+		{"v2 without syncnets", VersionedMetaData{Version: MetaDataVersion2}},
+		{"v3 without custody group count", VersionedMetaData{Version: MetaDataVersion3, Syncnets: &SyncnetBits{}}},
+	}
+	for _, c := range encodeCases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := c.md.EncodeTagged(); err == nil {
+				t.Fatal("expected encoding error")
+			}
+		})
+	}
+	decodeCases := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"unknown version", append([]byte{4}, make([]byte, MetadataV3ByteLen)...)},
+		{"truncated v2", append([]byte{byte(MetaDataVersion2)}, make([]byte, MetadataV2ByteLen-1)...)},
+		{"syncnets beyond bit length", append(append([]byte{byte(MetaDataVersion2)}, make([]byte, common.MetadataByteLen)...), 0x10)},
+	}
+	for _, c := range decodeCases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := DecodeTaggedMetaData(c.data); err == nil {
+				t.Fatal("expected decoding error")
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package types
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/ztyp/codec"
+	"github.com/protolambda/ztyp/tree"
+)
+
+// StatusV2 is the Fulu Status, served on the /eth2/beacon_chain/req/status/2/ protocol
+type StatusV2 struct {
+	ForkDigest            common.ForkDigest `json:"fork_digest" yaml:"fork_digest"`
+	FinalizedRoot         common.Root       `json:"finalized_root" yaml:"finalized_root"`
+	FinalizedEpoch        common.Epoch      `json:"finalized_epoch" yaml:"finalized_epoch"`
+	HeadRoot              common.Root       `json:"head_root" yaml:"head_root"`
+	HeadSlot              common.Slot       `json:"head_slot" yaml:"head_slot"`
+	EarliestAvailableSlot common.Slot       `json:"earliest_available_slot" yaml:"earliest_available_slot"`
+}
+
+func (d *StatusV2) Deserialize(dr *codec.DecodingReader) error {
+	return dr.FixedLenContainer(&d.ForkDigest, &d.FinalizedRoot, &d.FinalizedEpoch, &d.HeadRoot, &d.HeadSlot, &d.EarliestAvailableSlot)
+}
+
+func (d *StatusV2) Serialize(w *codec.EncodingWriter) error {
+	return w.FixedLenContainer(&d.ForkDigest, &d.FinalizedRoot, &d.FinalizedEpoch, &d.HeadRoot, &d.HeadSlot, &d.EarliestAvailableSlot)
+}
+
+// StatusByteLen is the SSZ byte length of the phase0 Status
+const StatusByteLen = 4 + 32 + 8 + 32 + 8
+
+const StatusV2ByteLen = StatusByteLen + 8
+
+func (d StatusV2) ByteLength() uint64 {
+	return StatusV2ByteLen
+}
+
+func (*StatusV2) FixedLength() uint64 {
+	return StatusV2ByteLen
+}
+
+func (d *StatusV2) HashTreeRoot(hFn tree.HashFn) common.Root {
+	return hFn.HashTreeRoot(&d.ForkDigest, &d.FinalizedRoot, &d.FinalizedEpoch, &d.HeadRoot, &d.HeadSlot, &d.EarliestAvailableSlot)
+}
+
+// StatusVersion is the version of the Status req-resp protocol the status was served with
+type StatusVersion uint8
+
+const (
+	StatusVersion1 StatusVersion = 1
+	StatusVersion2 StatusVersion = 2
+)
+
+// VersionedStatus holds a Status of any version. EarliestAvailableSlot is nil before version 2.
+type VersionedStatus struct {
+	Version StatusVersion `json:"version"`
+	common.Status
+	EarliestAvailableSlot *common.Slot `json:"earliest_available_slot,omitempty"`
+}
+
+func StatusFromV1(st common.Status) VersionedStatus {
+	return VersionedStatus{Version: StatusVersion1, Status: st}
+}
+
+func StatusFromV2(st StatusV2) VersionedStatus {
+	slot := st.EarliestAvailableSlot
+	return VersionedStatus{Version: StatusVersion2, Status: common.Status{
+		ForkDigest:     st.ForkDigest,
+		FinalizedRoot:  st.FinalizedRoot,
+		FinalizedEpoch: st.FinalizedEpoch,
+		HeadRoot:       st.HeadRoot,
+		HeadSlot:       st.HeadSlot,
+	}, EarliestAvailableSlot: &slot}
+}
+
+// V1 returns the phase0 view of the status, available for every version.
+func (s *VersionedStatus) V1() common.Status {
+	return s.Status
+}
+
+// V2 returns the Fulu view of the status, ok is false if the status is older than version 2.
+func (s *VersionedStatus) V2() (st StatusV2, ok bool) {
+	if s.Version < StatusVersion2 || s.EarliestAvailableSlot == nil {
+		return StatusV2{}, false
+	}
+	return StatusV2{
+		ForkDigest:            s.ForkDigest,
+		FinalizedRoot:         s.FinalizedRoot,
+		FinalizedEpoch:        s.FinalizedEpoch,
+		HeadRoot:              s.HeadRoot,
+		HeadSlot:              s.HeadSlot,
+		EarliestAvailableSlot: *s.EarliestAvailableSlot,
+	}, true
+}
+
+// EncodeTagged encodes the status as a version byte, followed by the SSZ encoding of that version.
+func (s *VersionedStatus) EncodeTagged() ([]byte, error) {
+	var obj codec.Serializable
+	switch s.Version {
+	case StatusVersion1:
+		st := s.V1()
+		obj = &st
+	case StatusVersion2:
+		st, ok := s.V2()
+		if !ok {
+			return nil, errors.New("status v2 is missing earliest available slot")
+		}
+		obj = &st
+	default:
+		return nil, fmt.Errorf("unknown status version %d", s.Version)
+	}
+	var out bytes.Buffer
+	out.WriteByte(byte(s.Version))
+	if err := obj.Serialize(codec.NewEncodingWriter(&out)); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// DecodeTaggedStatus decodes a status encoded with EncodeTagged.
+// Untagged phase0 status, of exactly StatusByteLen bytes, is decoded as version 1.
+func DecodeTaggedStatus(data []byte) (*VersionedStatus, error) {
+	dec := func(obj codec.Deserializable, v []byte) error {
+		return obj.Deserialize(codec.NewDecodingReader(bytes.NewReader(v), uint64(len(v))))
+	}
+	if len(data) == StatusByteLen {
+		var st common.Status
+		if err := dec(&st, data); err != nil {
+			return nil, err
+		}
+		out := StatusFromV1(st)
+		return &out, nil
+	}
+	if len(data) == 0 {
+		return nil, errors.New("empty status")
+	}
+	var out VersionedStatus
+	switch v, rest := StatusVersion(data[0]), data[1:]; v {
+	case StatusVersion1:
+		var st common.Status
+		if err := dec(&st, rest); err != nil {
+			return nil, err
+		}
+		out = StatusFromV1(st)
+	case StatusVersion2:
+		var st StatusV2
+		if err := dec(&st, rest); err != nil {
+			return nil, err
+		}
+		out = StatusFromV2(st)
+	default:
+		return nil, fmt.Errorf("unknown status version %d", v)
+	}
+	return &out, nil
+}
@@ -0,0 +1,128 @@
+package types
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/ztyp/codec"
+)
+
+func testStatus() common.Status {
+	return common.Status{
+		ForkDigest:     common.ForkDigest{0xcc, 0x2c, 0x5c, 0xdb},
+		FinalizedRoot:  common.Root{1, 2, 3},
+		FinalizedEpoch: 1000,
+		HeadRoot:       common.Root{4, 5, 6},
+		HeadSlot:       32064,
+	}
+}
+
+func TestVersionedStatusRoundTrip(t *testing.T) {
+	st := testStatus()
+	cases := []struct {
+		name    string
+		st      VersionedStatus
+		byteLen int
+	}{
+		{"v1", StatusFromV1(st), 1 + StatusByteLen},
+		{"v2", StatusFromV2(StatusV2{
+			ForkDigest:            st.ForkDigest,
+			FinalizedRoot:         st.FinalizedRoot,
+			FinalizedEpoch:        st.FinalizedEpoch,
+			HeadRoot:              st.HeadRoot,
+			HeadSlot:              st.HeadSlot,
+			EarliestAvailableSlot: 1234,
+		}), 1 + StatusV2ByteLen},
+		{"v2 with genesis earliest slot", StatusFromV2(StatusV2{HeadSlot: 1}), 1 + StatusV2ByteLen},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			data, err := c.st.EncodeTagged()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(data) != c.byteLen {
+				t.Fatalf("got %d bytes, expected %d", len(data), c.byteLen)
+			}
+			if StatusVersion(data[0]) != c.st.Version {
+				t.Fatalf("got version tag %d, expected %d", data[0], c.st.Version)
+			}
+			st, err := DecodeTaggedStatus(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*st, c.st) {
+				t.Fatalf("got %v, expected %v", st, c.st)
+			}
+		})
+	}
+}
+
+func TestDecodeUntaggedStatus(t *testing.T) {
+	cases := []struct {
+		name string
+		st   common.Status
+	}{
+		{"empty", common.Status{}},
+		// the first byte of an untagged status is the fork digest, which may look like a version tag
+		{"digest looks like v2 tag", common.Status{ForkDigest: common.ForkDigest{byte(StatusVersion2)}, HeadSlot: 5}},
+		{"mainnet", testStatus()},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := c.st.Serialize(codec.NewEncodingWriter(&buf)); err != nil {
+				t.Fatal(err)
+			}
+			st, err := DecodeTaggedStatus(buf.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if st.Version != StatusVersion1 {
+				t.Fatalf("got version %d, expected 1", st.Version)
+			}
+			if st.V1() != c.st {
+				t.Fatalf("got %v, expected %v", st.V1(), c.st)
+			}
+			if _, ok := st.V2(); ok {
+				t.Fatal("untagged status has no v2 view")
+			}
+		})
+	}
+}
+
+func TestVersionedStatusInvalid(t *testing.T) {
+	encodeCases := []struct {
+		name string
+		st   VersionedStatus
+	}{
+		{"unknown version", VersionedStatus{Version: 3}},
+		{"v2 without earliest available slot", VersionedStatus{Version: StatusVersion2}},
+	}
+	for _, c := range encodeCases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := c.st.EncodeTagged(); err == nil {
+				t.Fatal("expected encoding error")
+			}
+		})
+	}
+	decodeCases := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"unknown version", append([]byte{3}, make([]byte, StatusV2ByteLen)...)},
+		{"truncated v2", append([]byte{byte(StatusVersion2)}, make([]byte, StatusV2ByteLen-1)...)},
+		// a tagged v1 status missing one byte has the untagged length, and decodes as untagged status
+		{"truncated v1", append([]byte{byte(StatusVersion1)}, make([]byte, StatusByteLen-2)...)},
+	}
+	for _, c := range decodeCases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := DecodeTaggedStatus(c.data); err == nil {
+				t.Fatal("expected decoding error")
+			}
+		})
+	}
+}